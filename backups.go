@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/backup"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+// getBackup streams a zip of all tables, which can be loaded into an empty database with the restore command
+func getBackup(w http.ResponseWriter, req *http.Request) *web.Page {
+	// a database that can't be read fails here, while an error response can still be sent
+	snapshot, err := backup.NewSnapshot(db)
+	if err != nil {
+		return getError(req, err)
+	}
+	defer snapshot.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, backupFilename(time.Now())))
+
+	if _, err := snapshot.Write(w); err != nil {
+		// the zip is sent while it is written, a failed backup can only be cut short.
+		// It lacks the manifest then, so it is not taken for a complete backup.
+		log.WithField("request_id", requestId(req)).Errorf("backup failed: %v", err)
+	}
+	return nil
+}
+
+func backupFilename(t time.Time) string {
+	return fmt.Sprintf("moviedb-backup-%s.zip", t.UTC().Format("20060102T150405Z"))
+}
+
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: moviedb-backend backup [<file.zip>]")
+		fmt.Fprintln(os.Stderr, "writes to moviedb-backup-<timestamp>.zip without a file, or to stdout with -")
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	file := flags.Arg(0)
+	if len(file) == 0 {
+		file = backupFilename(time.Now())
+	}
+	var out io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+
+	manifest, err := backup.Backup(adapter, out)
+	if err != nil {
+		return err
+	}
+	if file != "-" {
+		log.Infof("backup of schema version %d written to %s", manifest.SchemaVersion, file)
+	}
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: moviedb-backend restore <file.zip>")
+		fmt.Fprintln(os.Stderr, "the database has to be empty, it is migrated before restoring")
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	migration.RunMigrations("./migrations", adapter)
+
+	manifest, err := backup.Restore(adapter, f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+func copyCommand(args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: moviedb-backend copy <source-uri> <target-uri>")
+		fmt.Fprintln(os.Stderr, "uris are sqlite3://<file> or postgres://..., the target has to be empty and is migrated before copying")
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+
+	source, err := database.NewAdapterFromURI(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer source.Database.Close()
+	target, err := database.NewAdapterFromURI(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer target.Database.Close()
+	migration.RunMigrations("./migrations", target)
+
+	copies, err := backup.Copy(source, target)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(copies)
+}
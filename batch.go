@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+const (
+	maxBatchOperations = 500
+	maxBatchSize       = 10 << 20 // 10 MB, enough for maxBatchOperations movies with long descriptions
+
+	batchOk         = "ok"
+	batchFailed     = "failed"
+	batchRolledBack = "rolled_back" // succeeded, but undone because a later operation failed
+	batchSkipped    = "skipped"     // not run because an earlier operation failed
+)
+
+var batchOps = []string{"create", "update", "patch", "delete"}
+
+type batchRequest struct {
+	ContinueOnError bool              `json:"continue_on_error"`
+	Operations      []*batchOperation `json:"operations"`
+}
+
+// batchOperation creates, updates, patches or deletes a movie. Updates replace the whole movie,
+// patches only the fields given in movie. Creates can be forced like POST /movie?force=true.
+type batchOperation struct {
+	Op    string          `json:"op"`
+	Id    int             `json:"id"`
+	Force bool            `json:"force"`
+	Movie json.RawMessage `json:"movie"`
+}
+
+type batchReport struct {
+	Committed bool           `json:"committed" xml:"committed,attr"`
+	Succeeded int            `json:"succeeded" xml:"succeeded"`
+	Failed    int            `json:"failed" xml:"failed"`
+	Results   []*batchResult `json:"results" xml:"results>result"`
+}
+
+type batchResult struct {
+	Index       int       `json:"index" xml:"index,attr"`
+	Op          string    `json:"op" xml:"op,attr"`
+	Id          int       `json:"id,omitempty" xml:"id,omitempty"`
+	Status      string    `json:"status" xml:"status,attr"`
+	StatusCode  int       `json:"status_code,omitempty" xml:"status_code,omitempty"`
+	RowsDeleted int64     `json:"rows_deleted,omitempty" xml:"rows_deleted,omitempty"`
+	Error       *apiError `json:"error,omitempty" xml:"error,omitempty"`
+	picture     string    // of a deleted movie, removed once the batch is committed
+}
+
+// postBatch runs a list of operations on movies in a single transaction. By default the first failing operation
+// rolls everything back and the request fails with its status code, with continue_on_error only the failed
+// operations are undone and the rest is committed.
+func postBatch(w http.ResponseWriter, req *http.Request) *web.Page {
+	var batch batchRequest
+	body := limitBody(w, req, maxBatchSize)
+	if err := json.NewDecoder(body).Decode(&batch); err != nil {
+		if body.exceeded {
+			return errorPage(req, http.StatusRequestEntityTooLarge, codeTooLarge, "Batch too large", nil)
+		}
+		return badRequest(req, err)
+	}
+	if err := checkBatch(&batch); err != nil {
+		return badRequest(req, err)
+	}
+
+	tx, err := mdb.BeginTransaction()
+	if err != nil {
+		return getError(req, err)
+	}
+	defer tx.Rollback()
+
+	report := &batchReport{Results: []*batchResult{}}
+	for i, operation := range batch.Operations {
+		report.Results = append(report.Results, &batchResult{Index: i, Op: operation.Op, Id: operation.Id, Status: batchSkipped})
+	}
+
+	// movies changed by earlier operations, nil once deleted. Reading them from the database would
+	// only return what was committed before the batch.
+	changed := map[int]*moviedb.Movie{}
+	current := func(id int) (*moviedb.Movie, error) {
+		if movie, ok := changed[id]; ok {
+			if movie == nil {
+				return nil, &moviedb.NotFoundError{Entity: moviedb.EntityMovie, Id: strconv.Itoa(id)}
+			}
+			return movie, nil
+		}
+		return mdb.GetMovie(strconv.Itoa(id))
+	}
+
+	for i, operation := range batch.Operations {
+		result := report.Results[i]
+		if batch.ContinueOnError {
+			if err := tx.Savepoint("batch_operation"); err != nil {
+				return getError(req, err)
+			}
+		}
+
+		page := runOperation(req, tx, operation, result, current, changed)
+		if page == nil {
+			result.Status = batchOk
+			report.Succeeded++
+			continue
+		}
+
+		result.Status, result.StatusCode = batchFailed, page.StatusCode
+		if response, ok := page.Content.(*errorResponse); ok {
+			result.Error = response.Error
+		}
+		report.Failed++
+
+		if !batch.ContinueOnError {
+			for _, earlier := range report.Results[:i] {
+				earlier.Status = batchRolledBack
+			}
+			report.Succeeded = 0
+			return &web.Page{StatusCode: page.StatusCode, Content: report}
+		}
+		if err := tx.RollbackTo("batch_operation"); err != nil {
+			return getError(req, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return getError(req, err)
+	}
+	report.Committed = true
+	for _, result := range report.Results {
+		if result.Op == "delete" && result.Status == batchOk {
+			deletePicture(result.Id, result.picture)
+		}
+	}
+	return &web.Page{Content: report}
+}
+
+// checkBatch rejects malformed operations before anything is run
+func checkBatch(batch *batchRequest) error {
+	if len(batch.Operations) == 0 {
+		return fmt.Errorf("No operations given")
+	}
+	if len(batch.Operations) > maxBatchOperations {
+		return fmt.Errorf("Too many operations: %d, at most %d are allowed", len(batch.Operations), maxBatchOperations)
+	}
+	for i, operation := range batch.Operations {
+		if operation == nil {
+			return fmt.Errorf("Invalid operation %d: must not be null", i)
+		}
+		if !oneOf(operation.Op, batchOps) {
+			return fmt.Errorf("Invalid operation %d: op %s, must be one of: %s", i, operation.Op, strings.Join(batchOps, ", "))
+		}
+		if operation.Op != "create" && operation.Id <= 0 {
+			return fmt.Errorf("Invalid operation %d: %s needs an id", i, operation.Op)
+		}
+		if operation.Op != "delete" && (len(operation.Movie) == 0 || string(operation.Movie) == "null") {
+			return fmt.Errorf("Invalid operation %d: %s needs a movie", i, operation.Op)
+		}
+	}
+	return nil
+}
+
+// runOperation returns the error page of a failed operation, or nil if it succeeded
+func runOperation(req *http.Request, tx moviedb.Transaction, operation *batchOperation, result *batchResult,
+	current func(int) (*moviedb.Movie, error), changed map[int]*moviedb.Movie) *web.Page {
+
+	switch operation.Op {
+	case "create":
+		var movie moviedb.Movie
+		if err := json.Unmarshal(operation.Movie, &movie); err != nil {
+			return badRequest(req, err)
+		}
+		movie.Id = 0
+		add := tx.AddMovie
+		if operation.Force {
+			add = tx.ForceAddMovie
+		}
+		if err := add(&movie); err != nil {
+			return getError(req, err)
+		}
+		result.Id = movie.Id
+		changed[movie.Id] = &movie
+
+	case "update":
+		if _, err := current(operation.Id); err != nil {
+			return getError(req, err)
+		}
+		var movie moviedb.Movie
+		if err := json.Unmarshal(operation.Movie, &movie); err != nil {
+			return badRequest(req, err)
+		}
+		movie.Id = operation.Id
+		if err := tx.SaveMovie(&movie); err != nil {
+			return getError(req, err)
+		}
+		changed[movie.Id] = &movie
+
+	case "patch":
+		existing, err := current(operation.Id)
+		if err != nil {
+			return getError(req, err)
+		}
+		movie, err := patchMovie(existing, operation.Movie)
+		if err != nil {
+			return badRequest(req, err)
+		}
+		movie.Id = operation.Id
+		if err := tx.SaveMovie(movie); err != nil {
+			return getError(req, err)
+		}
+		changed[movie.Id] = movie
+
+	case "delete":
+		existing, err := current(operation.Id)
+		if err != nil {
+			return getError(req, err)
+		}
+		rows, err := tx.DeleteMovie(strconv.Itoa(operation.Id))
+		if err != nil {
+			return getError(req, err)
+		}
+		result.RowsDeleted, result.picture = rows, existing.Picture
+		changed[operation.Id] = nil
+	}
+	return nil
+}
+
+// patchMovie replaces the fields of a movie given in patch. The movie itself stays as it is, a patched copy
+// is returned. Like with PUT /movie, saving it only adds languages, genres, actors and directors.
+func patchMovie(movie *moviedb.Movie, patch json.RawMessage) (*moviedb.Movie, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for field, value := range fields {
+		merged[field] = value
+	}
+
+	if data, err = json.Marshal(merged); err != nil {
+		return nil, err
+	}
+	var patched moviedb.Movie
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
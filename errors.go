@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/enrichment"
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/moviedb-backend/modules/storage"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+const (
+	codeBadRequest       = "bad_request"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeValidation       = "validation_failed"
+	codeUnavailable      = "unavailable"
+	codeBusy             = "busy"
+	codeBadGateway       = "bad_gateway"
+	codeInternal         = "internal_error"
+	codeNotImplemented   = "not_implemented"
+	codeTooLarge         = "payload_too_large"
+	codeUnsupportedMedia = "unsupported_media_type"
+)
+
+// errorResponse is the envelope every API error is returned in
+type errorResponse struct {
+	Error *apiError `json:"error" xml:"error"`
+}
+
+type apiError struct {
+	Code      string      `json:"code" xml:"code"`
+	Message   string      `json:"message" xml:"message"`
+	RequestId string      `json:"request_id" xml:"request_id"`
+	Details   interface{} `json:"details,omitempty" xml:"details>detail,omitempty"`
+}
+
+// MarshalXML leaves out the envelope, in XML the root element itself is <error>
+func (e *errorResponse) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	return encoder.EncodeElement(e.Error, xml.StartElement{Name: xml.Name{Local: "error"}})
+}
+
+func errorPage(req *http.Request, status int, code, message string, details interface{}) *web.Page {
+	return &web.Page{
+		StatusCode: status,
+		Content: &errorResponse{&apiError{
+			Code:      code,
+			Message:   message,
+			RequestId: requestId(req),
+			Details:   details,
+		}},
+	}
+}
+
+// getError maps an error to its status code and error response.
+// Errors not known to be safe for clients are logged and replaced by a generic message.
+func getError(req *http.Request, err error) *web.Page {
+	switch e := err.(type) {
+	case *moviedb.NotFoundError:
+		return errorPage(req, http.StatusNotFound, codeNotFound, e.Error(), nil)
+	case *moviedb.ConflictError:
+		return errorPage(req, http.StatusConflict, codeConflict, e.Error(), nil)
+	case *moviedb.DuplicateError:
+		return errorPage(req, http.StatusConflict, codeConflict, e.Error(), e.Candidates)
+	case *moviedb.ValidationError:
+		return errorPage(req, http.StatusUnprocessableEntity, codeValidation, e.Error(), e.Violations)
+	case *moviedb.UnavailableError:
+		log.WithField("request_id", requestId(req)).Error(e.Err)
+		return errorPage(req, http.StatusServiceUnavailable, codeUnavailable, e.Error(), nil)
+	case *enrichment.UpstreamError:
+		log.WithField("request_id", requestId(req)).Error(e.Err)
+		return errorPage(req, http.StatusBadGateway, codeBadGateway, e.Error(), nil)
+	case *moviedb.BusyError:
+		// another writer holds the lock, the client may simply try again
+		log.WithField("request_id", requestId(req)).Warn(e.Err)
+		page := errorPage(req, http.StatusServiceUnavailable, codeBusy, e.Error(), nil)
+		page.Headers = http.Header{"Retry-After": {"1"}}
+		return page
+	}
+
+	switch err {
+	case errNoMetadataProvider:
+		return errorPage(req, http.StatusServiceUnavailable, codeUnavailable, err.Error(), nil)
+	case enrichment.ErrNoMatch:
+		return errorPage(req, http.StatusNotFound, codeNotFound, err.Error(), nil)
+	case storage.ErrNotFound:
+		return errorPage(req, http.StatusNotFound, codeNotFound, err.Error(), nil)
+	}
+
+	log.WithField("request_id", requestId(req)).Error(err)
+	return errorPage(req, http.StatusInternalServerError, codeInternal, "Internal server error", nil)
+}
+
+func badRequest(req *http.Request, err error) *web.Page {
+	return errorPage(req, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+}
+
+func notFound(w http.ResponseWriter, req *http.Request) *web.Page {
+	return errorPage(req, http.StatusNotFound, codeNotFound, "This is not the JSON you are looking for..", nil)
+}
+
+func requestId(req *http.Request) string {
+	return req.Header.Get("X-Request-Id")
+}
+
+// validRequestId limits ids given by clients, as they are returned in headers and error responses and logged
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIds makes sure every request has an id, which is also returned to the client.
+// Ids given by clients are kept if they are valid, otherwise replaced by a new one.
+func requestIds(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	id := req.Header.Get("X-Request-Id")
+	if !validRequestId.MatchString(id) {
+		id = newRequestId()
+		req.Header.Set("X-Request-Id", id)
+	}
+	w.Header().Set("X-Request-Id", id)
+	next(w, req)
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Error(err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/importer"
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+var exportFormats = []string{"csv", "json", "ndjson"}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json; charset=utf-8",
+	"ndjson": "application/x-ndjson; charset=utf-8",
+}
+
+// movieWriter writes movies one after the other in a single export format, Close ends the export
+type movieWriter interface {
+	Write(*moviedb.Movie) error
+	Flush() error
+	Close() error
+}
+
+// getExport streams all movies matching the listing filters with their relations, in the given format.
+// CSV exports can be read by the importer again, and take the same separator, delimiter and map parameters.
+func getExport(w http.ResponseWriter, req *http.Request) *web.Page {
+	q := req.URL.Query()
+	format := q.Get("format")
+	if len(format) == 0 {
+		format = "json"
+	}
+	if !oneOf(format, exportFormats) {
+		return badRequest(req, fmt.Errorf("Invalid format: %s, must be one of: %s", format, strings.Join(exportFormats, ", ")))
+	}
+	options, page := listingOptions(req)
+	if page != nil {
+		return page
+	}
+
+	var writer movieWriter
+	switch format {
+	case "csv":
+		csvOptions, err := csvOptions(q.Get("separator"), q.Get("delimiter"), q["map"])
+		if err != nil {
+			return badRequest(req, err)
+		}
+		writer = &csvWriter{importer.NewCSVWriter(w, csvOptions)}
+	case "json":
+		writer = &jsonWriter{w: w}
+	case "ndjson":
+		writer = &jsonWriter{w: w, lines: true}
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, format))
+
+	count := 0
+	err := mdb.ExportMovies(func(movie *moviedb.Movie) error {
+		if err := writer.Write(movie); err != nil {
+			return err
+		}
+		count++
+		// send what we have every now and then, instead of buffering the whole export
+		if count%100 == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+		return nil
+	}, options)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// once movies were sent the status can not be changed anymore, the export just ends early
+		if count == 0 {
+			w.Header().Del("Content-Disposition")
+			return getError(req, err)
+		}
+		log.Errorf("export failed after %d movies: %v", count, err)
+	}
+	return nil
+}
+
+// jsonWriter writes movies as a JSON array, or as one JSON document per line
+type jsonWriter struct {
+	w       io.Writer
+	lines   bool
+	written bool
+}
+
+func (j *jsonWriter) Write(movie *moviedb.Movie) error {
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n"
+	switch {
+	case j.lines:
+		separator = ""
+	case !j.written:
+		separator = "[\n"
+	}
+	j.written = true
+
+	_, err = fmt.Fprintf(j.w, "%s%s", separator, data)
+	if err == nil && j.lines {
+		_, err = io.WriteString(j.w, "\n")
+	}
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	if j.lines {
+		return nil
+	}
+	if !j.written {
+		_, err := io.WriteString(j.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+type csvWriter struct {
+	*importer.CSVWriter
+}
+
+func (c *csvWriter) Close() error {
+	return c.Flush()
+}
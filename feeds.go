@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/stdlib/env"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+const (
+	feedSize    = 20
+	maxFeedSize = 100
+)
+
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Author  atomAuthor   `xml:"author"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Id        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Links     []atomLink `xml:"link"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+// feedMovie is a movie as it appears in a feed, with its id and all links already resolved
+type feedMovie struct {
+	Change  *moviedb.MovieChange
+	Id      string
+	Link    string
+	Title   string
+	Content string
+}
+
+func getAtomFeed(w http.ResponseWriter, req *http.Request) *web.Page {
+	movies, updated, page := getFeedMovies(req)
+	if page != nil {
+		return page
+	}
+
+	base := publicURL(req)
+	feed := &atomFeed{
+		Id:      tagURI(strings.TrimPrefix(req.URL.RequestURI(), "/")),
+		Title:   feedTitle(),
+		Updated: updated.Format(time.RFC3339),
+		Author:  atomAuthor{feedTitle()},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + req.URL.RequestURI()},
+			{Rel: "alternate", Href: base + "/movies"},
+		},
+		Entries: []*atomEntry{},
+	}
+	for _, m := range movies {
+		feed.Entries = append(feed.Entries, &atomEntry{
+			Id:        m.Id,
+			Title:     m.Title,
+			Published: m.Change.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   m.Change.UpdatedAt.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Href: m.Link}},
+			Content:   atomText{"html", m.Content},
+		})
+	}
+	return serveFeed(w, req, "application/atom+xml; charset=utf-8", feed, updated)
+}
+
+func getRssFeed(w http.ResponseWriter, req *http.Request) *web.Page {
+	movies, updated, page := getFeedMovies(req)
+	if page != nil {
+		return page
+	}
+
+	feed := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feedTitle(),
+			Link:          publicURL(req) + "/movies",
+			Description:   "Movies recently added to the collection",
+			LastBuildDate: updated.Format(time.RFC1123Z),
+			Items:         []*rssItem{},
+		},
+	}
+	for _, m := range movies {
+		feed.Channel.Items = append(feed.Channel.Items, &rssItem{
+			Title:       m.Title,
+			Link:        m.Link,
+			Guid:        rssGuid{false, m.Id},
+			PubDate:     m.Change.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: m.Content,
+		})
+	}
+	return serveFeed(w, req, "application/rss+xml; charset=utf-8", feed, updated)
+}
+
+// getFeedMovies returns the last movies added matching the listing filters, newest first,
+// and when any of them was updated last
+func getFeedMovies(req *http.Request) ([]*feedMovie, time.Time, *web.Page) {
+	updated := time.Unix(0, 0).UTC()
+
+	limit := feedSize
+	if value := req.URL.Query().Get("limit"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, updated, badRequest(req, fmt.Errorf("Invalid limit: %s", value))
+		}
+		limit = n
+	}
+	if limit > maxFeedSize {
+		limit = maxFeedSize
+	}
+	options, page := listingOptions(req)
+	if page != nil {
+		return nil, updated, page
+	}
+	options.Sort = []moviedb.Sort{moviedb.NewSort("created_at", "desc")}
+
+	changes, err := mdb.GetRecentMovies(limit, options)
+	if err != nil {
+		return nil, updated, getError(req, err)
+	}
+
+	base := publicURL(req)
+	movies := []*feedMovie{}
+	for _, change := range changes {
+		if change.UpdatedAt.After(updated) {
+			updated = change.UpdatedAt.UTC()
+		}
+
+		movie := change.Movie
+		m := &feedMovie{
+			Change: change,
+			Id:     tagURI(fmt.Sprintf("movie/%d", movie.Id)),
+			Link:   fmt.Sprintf("%s/movie/%d", base, movie.Id),
+		}
+		m.Title = fmt.Sprintf("%s (%d)", plainText(movie.Title), movie.Year)
+		m.Content = fmt.Sprintf("<p>%s</p>", change.Description)
+		if len(change.Picture) > 0 {
+			m.Content = fmt.Sprintf(`<p><img src="%s/picture?size=medium" alt="%s"/></p>`, m.Link, html.EscapeString(m.Title)) + m.Content
+		}
+		movies = append(movies, m)
+	}
+	return movies, updated, nil
+}
+
+// serveFeed writes a feed, answering conditional requests with 304 Not Modified
+func serveFeed(w http.ResponseWriter, req *http.Request, contentType string, feed interface{}, updated time.Time) *web.Page {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return getError(req, err)
+	}
+	data = append([]byte(xml.Header), data...)
+
+	hash := sha1.Sum(data)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", strconv.Quote(hex.EncodeToString(hash[:])))
+	http.ServeContent(w, req, "", updated, bytes.NewReader(data))
+	return nil
+}
+
+// publicURL is where clients reach the API, used for all links in feeds.
+// Unless configured it is derived from the request, so behind a proxy JCIO_PUBLIC_URL should be set.
+func publicURL(req *http.Request) string {
+	if url := env.Get("JCIO_PUBLIC_URL", ""); len(url) > 0 {
+		return strings.TrimSuffix(url, "/")
+	}
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+// tagURI is a tag: URI (RFC 4151) used as id in feeds. Unlike links it must never change,
+// whichever host the feed is fetched from, so it only depends on the configured JCIO_FEED_TAG,
+// a domain and a date at which it was owned.
+func tagURI(specific string) string {
+	return fmt.Sprintf("tag:%s:%s", env.Get("JCIO_FEED_TAG", "jamesclonk.io,2014"), specific)
+}
+
+func feedTitle() string {
+	return env.Get("JCIO_FEED_TITLE", "Movie Database")
+}
+
+// plainText strips markup and entities like the ones found in movie titles
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTags.ReplaceAllString(s, " "))), " ")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+// The backend renders every page it gets as JSON, and can't handle a handler returning no page at all.
+// Pictures, exports, feeds and backups write their own responses though, so they are wrapped by these
+// instead. They only hand a returned page, usually an error, to the backend.
+
+// rawHandler lets a handler write its own response, a returned *web.Page is rendered by the backend as usual
+func rawHandler(backend *web.Backend, fn web.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if page := fn(w, req); page != nil {
+			backend.NewHandler(negotiated(func(http.ResponseWriter, *http.Request) *web.Page {
+				return page
+			}))(w, req)
+		}
+	}
+}
+
+// securedRawHandler is a rawHandler behind the same authentication as secured routes.
+// The backend only authenticates within NewSecuredHandler, which renders a page afterwards in any case.
+// When the handler has written its response already, a rawWriter drops that rendering.
+func securedRawHandler(backend *web.Backend, fn web.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rw := &rawWriter{ResponseWriter: w}
+		backend.NewSecuredHandler(func(_ http.ResponseWriter, req *http.Request) *web.Page {
+			if page := negotiated(fn)(w, req); page != nil {
+				return page
+			}
+			rw.done = true
+			return &web.Page{}
+		})(rw, req)
+	}
+}
+
+// rawWriter passes everything on, until done is set
+type rawWriter struct {
+	http.ResponseWriter
+	done bool
+}
+
+func (rw *rawWriter) Header() http.Header {
+	if rw.done {
+		return http.Header{}
+	}
+	return rw.ResponseWriter.Header()
+}
+
+func (rw *rawWriter) WriteHeader(status int) {
+	if !rw.done {
+		rw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (rw *rawWriter) Write(data []byte) (int, error) {
+	if rw.done {
+		return len(data), nil
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+// limitedBody caps a request body like http.MaxBytesReader does, and remembers whether a client sent more than that.
+// Decoders report the failing read in their own words, if at all.
+type limitedBody struct {
+	io.ReadCloser
+	limit, read int64
+	exceeded    bool
+}
+
+func limitBody(w http.ResponseWriter, req *http.Request, limit int64) *limitedBody {
+	body := &limitedBody{ReadCloser: http.MaxBytesReader(w, req.Body, limit), limit: limit}
+	req.Body = body
+	return body
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		b.exceeded = true
+	}
+	return n, err
+}
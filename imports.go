@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/jamesclonk-io/moviedb-backend/modules/importer"
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+const maxImportSize = 32 << 20 // 32 MB
+
+// postImport reads a collection from the request body, in one of the importer formats given with format, CSV by default.
+// It takes dry_run, force and batch_size parameters, type for the disk type of letterboxd and list imports,
+// and separator, delimiter and map parameters for CSV, like map=title:Name to read titles from the column Name.
+func postImport(w http.ResponseWriter, req *http.Request) *web.Page {
+	q := req.URL.Query()
+	options := importer.Options{DryRun: q.Get("dry_run") == "true", Force: q.Get("force") == "true"}
+	if value := q.Get("batch_size"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return badRequest(req, fmt.Errorf("Invalid batch_size: %s", value))
+		}
+		options.BatchSize = n
+	}
+
+	format := q.Get("format")
+	if len(format) == 0 {
+		format = "csv"
+	}
+	csvOptions, err := csvOptions(q.Get("separator"), q.Get("delimiter"), q["map"])
+	if err != nil {
+		return badRequest(req, err)
+	}
+	reader, err := importer.NewImporter(format, importer.FormatOptions{CSV: csvOptions, Type: q.Get("type")})
+	if err != nil {
+		return badRequest(req, err)
+	}
+	body := limitBody(w, req, maxImportSize)
+	rows, err := reader.Read(body)
+	if body.exceeded {
+		return errorPage(req, http.StatusRequestEntityTooLarge, codeTooLarge, "Collection too large", nil)
+	}
+	if err != nil {
+		return badRequest(req, err)
+	}
+
+	data, err := importer.Import(mdb, rows, options)
+	return getData(req, data, err)
+}
+
+func csvOptions(separator, delimiter string, mapping []string) (importer.CSVOptions, error) {
+	options := importer.CSVOptions{Delimiter: delimiter}
+	if len(separator) > 0 {
+		if utf8.RuneCountInString(separator) != 1 {
+			return options, fmt.Errorf("Invalid separator: %s, must be a single character", separator)
+		}
+		options.Separator, _ = utf8.DecodeRuneInString(separator)
+	}
+
+	m, err := importer.ParseMapping(mapping...)
+	if err != nil {
+		return options, err
+	}
+	options.Mapping = m
+	return options, nil
+}
+
+// importCommand imports a collection file, or stdin if the file is "-", and prints the report as JSON.
+// It uses the same database configuration as the backend.
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only validate rows and look for duplicates")
+	force := flags.Bool("force", false, "import rows looking like duplicates or matching an existing movie too")
+	batchSize := flags.Int("batch-size", 50, "rows committed per transaction")
+	separator := flags.String("separator", ",", "separator between columns")
+	delimiter := flags.String("delimiter", "|", "delimiter between values of multi-valued columns")
+	mapping := flags.String("map", "", "comma separated field:column pairs, like title:Name,year:Released")
+	format := flags.String("format", "csv", "one of: "+strings.Join(importer.Formats, ", "))
+	diskType := flags.String("type", "DVD", "disk type of letterboxd and list imports, and of dvdprofiler entries without media types")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: moviedb-backend import [flags] <file>")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	var pairs []string
+	if len(*mapping) > 0 {
+		pairs = strings.Split(*mapping, ",")
+	}
+	csvOptions, err := csvOptions(*separator, *delimiter, pairs)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if file := flags.Arg(0); file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	reader, err := importer.NewImporter(*format, importer.FormatOptions{CSV: csvOptions, Type: *diskType})
+	if err != nil {
+		return err
+	}
+	rows, err := reader.Read(in)
+	if err != nil {
+		return err
+	}
+
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	migration.RunMigrations("./migrations", adapter)
+
+	report, err := importer.Import(moviedb.NewMovieDB(adapter), rows, importer.Options{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+		Force:     *force,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed to import", report.Failed, report.Rows)
+	}
+	return nil
+}
@@ -3,22 +3,38 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/jamesclonk-io/moviedb-backend/modules/database"
 	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/jamesclonk-io/moviedb-backend/modules/enrichment"
 	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/moviedb-backend/modules/storage"
+	"github.com/jamesclonk-io/moviedb-backend/modules/thumbnail"
+	"github.com/jamesclonk-io/stdlib/env"
 	"github.com/jamesclonk-io/stdlib/logger"
 	"github.com/jamesclonk-io/stdlib/web"
 	"github.com/jamesclonk-io/stdlib/web/negroni"
 )
 
 var (
-	log *logrus.Logger
-	mdb moviedb.MovieDB
+	log      *logrus.Logger
+	db       *database.Adapter
+	mdb      moviedb.MovieDB
+	provider enrichment.MetadataProvider
+	blobs    storage.BlobStore
+
+	thumbnailSizes []thumbnail.Size
+
+	errNoMetadataProvider = errors.New("No metadata provider configured")
 )
 
 func init() {
@@ -27,37 +43,112 @@ func init() {
 
 func setup() *negroni.Negroni {
 	// setup movie database
-	adapter := database.NewAdapter()
-	migration.RunMigrations("./migrations", adapter)
-	mdb = moviedb.NewMovieDB(adapter)
+	db = database.NewAdapter()
+	migration.RunMigrations("./migrations", db)
+	mdb = moviedb.NewMovieDB(db)
+
+	// setup blobstore for pictures
+	blobs = storage.NewBlobStore(db)
+	sizes, err := thumbnail.ParseSizes(env.Get("JCIO_THUMBNAIL_SIZES", "small:160,medium:320,large:640"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	thumbnailSizes = sizes
+
+	// setup metadata provider for movie enrichment, if configured
+	if url := env.Get("JCIO_METADATA_PROVIDER_URL", ""); len(url) > 0 {
+		provider = enrichment.NewHTTPProvider(url)
+	}
 
 	// create backend service
 	backend := web.NewBackend()
+	backend.Router.NotFoundHandler = backend.NewHandler(negotiated(notFound))
 
 	// setup API routes on backend
-	backend.NewRoute("/movie/{id}", getMovie).Methods("GET")
-	backend.NewSecuredRoute("/movie", postMovie).Methods("POST")
-	backend.NewSecuredRoute("/movie/{id}", putMovie).Methods("PUT")
-	backend.NewSecuredRoute("/movie/{id}", deleteMovie).Methods("DELETE")
-
-	backend.NewRoute("/movies", getMovies)
-	backend.NewRoute("/languages", getLanguages)
-	backend.NewRoute("/genres", getGenres)
-	backend.NewRoute("/person/{id}", getPerson)
-	backend.NewRoute("/actors", getActors)
-	backend.NewRoute("/directors", getDirectors)
-	backend.NewRoute("/statistics", getStatistics)
-
-	backend.NewRoute("/", index)
-	backend.NewRoute("/500", createError)
+	backend.NewRoute("/movie/{id}", negotiated(getMovie)).Methods("GET")
+	backend.NewRoute("/movie/by-external/{provider}/{external_id}", negotiated(getMovieByExternalId)).Methods("GET")
+	backend.NewRoute("/movie/{id}/similar", negotiated(getSimilarMovies)).Methods("GET")
+	backend.NewSecuredRoute("/movie", negotiated(postMovie)).Methods("POST")
+	backend.NewSecuredRoute("/movie/{id}", negotiated(putMovie)).Methods("PUT")
+	backend.NewSecuredRoute("/movie/{id}", negotiated(deleteMovie)).Methods("DELETE")
+	backend.NewSecuredRoute("/batch", negotiated(postBatch)).Methods("POST")
+	// proposals call the metadata provider, so they are secured like applying them
+	backend.NewSecuredRoute("/movie/{id}/enrichment", negotiated(getMovieEnrichment)).Methods("GET")
+	backend.NewSecuredRoute("/movie/{id}/enrichment", negotiated(postMovieEnrichment)).Methods("POST")
+	backend.Router.Handle("/movie/{id}/picture", rawHandler(backend, getMoviePicture)).Methods("GET")
+	backend.NewSecuredRoute("/movie/{id}/picture", negotiated(postMoviePicture)).Methods("POST")
+
+	backend.NewRoute("/movies", negotiated(getMovies))
+	backend.NewRoute("/movies/random", negotiated(getRandomMovies))
+	backend.NewRoute("/movies/recent", negotiated(getRecentMovies))
+	backend.Router.Handle("/export", rawHandler(backend, getExport)).Methods("GET")
+	backend.Router.Handle("/feed.atom", rawHandler(backend, getAtomFeed)).Methods("GET")
+	backend.Router.Handle("/feed.rss", rawHandler(backend, getRssFeed)).Methods("GET")
+	backend.NewRoute("/languages", negotiated(getLanguages))
+	backend.NewRoute("/genres", negotiated(getGenres))
+	backend.NewRoute("/formats", negotiated(getFormats))
+	backend.NewRoute("/disk-types", negotiated(getDiskTypes))
+	backend.NewRoute("/regions", negotiated(getRegions))
+	backend.NewRoute("/person/{id}", negotiated(getPerson))
+	backend.NewRoute("/person/by-external/{provider}/{external_id}", negotiated(getPersonByExternalId)).Methods("GET")
+	backend.NewRoute("/person/{id}/collaborators", negotiated(getCollaborators)).Methods("GET")
+	backend.NewRoute("/people/{a}/path/{b}", negotiated(getPersonPath)).Methods("GET")
+	backend.NewRoute("/actors", negotiated(getActors))
+	backend.NewRoute("/directors", negotiated(getDirectors))
+	backend.NewRoute("/statistics", negotiated(getStatistics))
+	backend.NewRoute("/statistics/timeline", negotiated(getTimeline))
+	backend.NewRoute("/statistics/breakdown", negotiated(getBreakdown))
+	backend.NewSecuredRoute("/duplicates", negotiated(getDuplicates)).Methods("GET")
+	backend.NewSecuredRoute("/import", negotiated(postImport)).Methods("POST")
+	backend.Router.Handle("/backup", securedRawHandler(backend, getBackup)).Methods("GET")
+
+	backend.NewRoute("/", negotiated(index))
+	backend.NewRoute("/500", negotiated(createError))
 
 	n := negroni.Sbagliato()
+	n.UseFunc(requestIds)
+	n.UseFunc(negotiation)
 	n.UseHandler(backend.Router)
 
 	return n
 }
 
+// errUsage is returned by commands called with wrong arguments, after printing their usage.
+var errUsage = errors.New("usage")
+
+// parseFlags parses the arguments of a command, flags it doesn't know are a usage error too
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		return errUsage
+	}
+	return err
+}
+
+// commands can be run instead of the backend server, like "moviedb-backend import movies.csv"
+var commands = map[string]func(args []string) error{
+	"import":  importCommand,
+	"backup":  backupCommand,
+	"restore": restoreCommand,
+	"copy":    copyCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("Unknown command: %s", os.Args[1])
+		}
+		switch err := command(os.Args[2:]); err {
+		case nil, flag.ErrHelp:
+		case errUsage:
+			os.Exit(2)
+		default:
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// setup http handler
 	n := setup()
 
@@ -70,10 +161,15 @@ func postMovie(w http.ResponseWriter, req *http.Request) *web.Page {
 	decoder := json.NewDecoder(req.Body)
 	var movie moviedb.Movie
 	if err := decoder.Decode(&movie); err != nil {
-		return web.Error("Error", http.StatusInternalServerError, err)
+		return badRequest(req, err)
+	}
+
+	add := mdb.AddMovie
+	if req.URL.Query().Get("force") == "true" {
+		add = mdb.ForceAddMovie
 	}
-	if err := mdb.AddMovie(&movie); err != nil {
-		return web.Error("Error", http.StatusInternalServerError, err)
+	if err := add(&movie); err != nil {
+		return getError(req, err)
 	}
 	return &web.Page{
 		Content: map[string]string{"Result": "OK"},
@@ -81,67 +177,384 @@ func postMovie(w http.ResponseWriter, req *http.Request) *web.Page {
 }
 
 func putMovie(w http.ResponseWriter, req *http.Request) *web.Page {
-	return web.Error("Error", http.StatusNotImplemented, errors.New("Not implemented"))
+	return errorPage(req, http.StatusNotImplemented, codeNotImplemented, "Not implemented", nil)
 }
 
 func deleteMovie(w http.ResponseWriter, req *http.Request) *web.Page {
-	id := mux.Vars(req)["id"]
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+	movie, err := mdb.GetMovie(id)
+	if err != nil {
+		return getError(req, err)
+	}
 	rows, err := mdb.DeleteMovie(id)
 	if err != nil {
-		log.Error(err)
-		return web.Error("Error", http.StatusInternalServerError, err)
+		return getError(req, err)
 	}
+	deletePicture(movie.Id, movie.Picture)
 	return &web.Page{
 		Content: map[string]interface{}{"RowsDeleted": rows},
 	}
 }
 
 func getMovie(w http.ResponseWriter, req *http.Request) *web.Page {
-	id := mux.Vars(req)["id"]
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
 	data, err := mdb.GetMovie(id)
-	return getData(data, err)
+	return getData(req, data, err)
+}
+
+func getMovieByExternalId(w http.ResponseWriter, req *http.Request) *web.Page {
+	provider := strings.ToLower(mux.Vars(req)["provider"])
+	externalId := mux.Vars(req)["external_id"]
+	if err := moviedb.ValidateExternalId(moviedb.EntityMovie, provider, externalId); err != nil {
+		return badRequest(req, err)
+	}
+	data, err := mdb.GetMovieByExternalId(provider, externalId)
+	return getData(req, data, err)
+}
+
+func getMovieEnrichment(w http.ResponseWriter, req *http.Request) *web.Page {
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+	proposal, err := proposeEnrichment(id, req.URL.Query().Get("source_id"), req.URL.Query().Get("barcode"))
+	if err != nil {
+		return getError(req, err)
+	}
+	return &web.Page{
+		Content: proposal,
+	}
+}
+
+func postMovieEnrichment(w http.ResponseWriter, req *http.Request) *web.Page {
+	var options struct {
+		SourceId string   `json:"source_id"`
+		Barcode  string   `json:"barcode"`
+		Fields   []string `json:"fields"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&options); err != nil {
+		return badRequest(req, err)
+	}
+
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+	proposal, err := proposeEnrichment(id, options.SourceId, options.Barcode)
+	if err != nil {
+		return getError(req, err)
+	}
+
+	movie := proposal.Apply(options.Fields...)
+	if err := mdb.SaveMovie(movie); err != nil {
+		return getError(req, err)
+	}
+	return &web.Page{
+		Content: map[string]string{"Result": "OK"},
+	}
+}
+
+func proposeEnrichment(id, sourceId, barcode string) (*enrichment.Proposal, error) {
+	movie, err := mdb.GetMovie(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider == nil {
+		return nil, errNoMetadataProvider
+	}
+
+	if len(sourceId) == 0 && len(barcode) > 0 {
+		results, err := provider.SearchByBarcode(barcode)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return nil, enrichment.ErrNoMatch
+		}
+		sourceId = results[0].Id
+	}
+	return enrichment.Propose(provider, movie, sourceId)
 }
 
 func getMovies(w http.ResponseWriter, req *http.Request) *web.Page {
-	data, err := mdb.GetMovieListings(moviedb.ParseMovieListingOptions(req))
-	return getData(data, err)
+	options, page := listingOptions(req)
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetMovieListings(options)
+	return getData(req, data, err)
+}
+
+// getRecentMovies lists the movies added or updated last, the 20 latest unless a limit is given
+func getRecentMovies(w http.ResponseWriter, req *http.Request) *web.Page {
+	limit := 20
+	if value := req.URL.Query().Get("limit"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return badRequest(req, fmt.Errorf("Invalid limit: %s", value))
+		}
+		limit = n
+	}
+	options, page := listingOptions(req)
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetRecentMovies(limit, options)
+	return getData(req, data, err)
+}
+
+// getRandomMovies takes the listing filters, count, seed and weight parameters, like weight=score.
+// Without a seed a new one is used, it is returned along with the movies to repeat the pick.
+func getRandomMovies(w http.ResponseWriter, req *http.Request) *web.Page {
+	q := req.URL.Query()
+	random := moviedb.RandomOptions{Count: 1, Seed: time.Now().UnixNano(), Weights: q["weight"]}
+	if value := q.Get("count"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return badRequest(req, fmt.Errorf("Invalid count: %s", value))
+		}
+		random.Count = n
+	}
+	if value := q.Get("seed"); len(value) > 0 {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return badRequest(req, fmt.Errorf("Invalid seed: %s", value))
+		}
+		random.Seed = seed
+	}
+	for _, weight := range random.Weights {
+		if !oneOf(weight, moviedb.RandomWeights) {
+			return badRequest(req, fmt.Errorf("Invalid weight: %s, must be one of: %s", weight, strings.Join(moviedb.RandomWeights, ", ")))
+		}
+	}
+
+	options, page := listingOptions(req)
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetRandomMovies(random, options)
+	return getData(req, data, err)
+}
+
+// getSimilarMovies takes the listing filters, a limit and the weights of all kinds of similarity,
+// like weight_genre=2 or weight_actor=0 to ignore actors
+func getSimilarMovies(w http.ResponseWriter, req *http.Request) *web.Page {
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+
+	q := req.URL.Query()
+	weights := moviedb.DefaultSimilarityWeights
+	for name, weight := range map[string]*float64{
+		"weight_genre":    &weights.Genre,
+		"weight_director": &weights.Director,
+		"weight_actor":    &weights.Actor,
+		"weight_decade":   &weights.Decade,
+		"weight_language": &weights.Language,
+	} {
+		if value := q.Get(name); len(value) > 0 {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || f < 0 {
+				return badRequest(req, fmt.Errorf("Invalid %s: %s", name, value))
+			}
+			*weight = f
+		}
+	}
+
+	limit := 10
+	if value := q.Get("limit"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return badRequest(req, fmt.Errorf("Invalid limit: %s", value))
+		}
+		limit = n
+	}
+
+	options, page := listingOptions(req)
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetSimilarMovies(id, weights, limit, options)
+	return getData(req, data, err)
 }
 
 func getLanguages(w http.ResponseWriter, req *http.Request) *web.Page {
 	data, err := mdb.GetLanguages()
-	return getData(data, err)
+	return getData(req, data, err)
 }
 
 func getGenres(w http.ResponseWriter, req *http.Request) *web.Page {
 	data, err := mdb.GetGenres()
-	return getData(data, err)
+	return getData(req, data, err)
+}
+
+func getFormats(w http.ResponseWriter, req *http.Request) *web.Page {
+	data, err := mdb.GetFormats()
+	return getData(req, data, err)
+}
+
+func getDiskTypes(w http.ResponseWriter, req *http.Request) *web.Page {
+	data, err := mdb.GetDiskTypes()
+	return getData(req, data, err)
+}
+
+func getRegions(w http.ResponseWriter, req *http.Request) *web.Page {
+	data, err := mdb.GetRegions()
+	return getData(req, data, err)
 }
 
 func getPerson(w http.ResponseWriter, req *http.Request) *web.Page {
-	id := mux.Vars(req)["id"]
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
 	data, err := mdb.GetPerson(id)
-	return getData(data, err)
+	return getData(req, data, err)
+}
+
+func getPersonByExternalId(w http.ResponseWriter, req *http.Request) *web.Page {
+	provider := strings.ToLower(mux.Vars(req)["provider"])
+	externalId := mux.Vars(req)["external_id"]
+	if err := moviedb.ValidateExternalId(moviedb.EntityPerson, provider, externalId); err != nil {
+		return badRequest(req, err)
+	}
+	data, err := mdb.GetPersonByExternalId(provider, externalId)
+	return getData(req, data, err)
+}
+
+func getCollaborators(w http.ResponseWriter, req *http.Request) *web.Page {
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetCollaborators(id)
+	return getData(req, data, err)
+}
+
+func getPersonPath(w http.ResponseWriter, req *http.Request) *web.Page {
+	from, page := pathIdOf(req, "a")
+	if page != nil {
+		return page
+	}
+	to, page := pathIdOf(req, "b")
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetPersonPath(from, to)
+	return getData(req, data, err)
 }
 
 func getActors(w http.ResponseWriter, req *http.Request) *web.Page {
 	data, err := mdb.GetActors()
-	return getData(data, err)
+	return getData(req, data, err)
 }
 
 func getDirectors(w http.ResponseWriter, req *http.Request) *web.Page {
 	data, err := mdb.GetDirectors()
-	return getData(data, err)
+	return getData(req, data, err)
 }
 
 func getStatistics(w http.ResponseWriter, req *http.Request) *web.Page {
-	data, err := mdb.GetStatistics()
-	return getData(data, err)
+	options, page := listingOptions(req)
+	if page != nil {
+		return page
+	}
+	data, err := mdb.GetStatistics(options)
+	return getData(req, data, err)
+}
+
+func getTimeline(w http.ResponseWriter, req *http.Request) *web.Page {
+	bucket := req.URL.Query().Get("bucket")
+	if len(bucket) == 0 {
+		bucket = "month"
+	}
+	if !oneOf(bucket, moviedb.TimelineBuckets) {
+		return badRequest(req, fmt.Errorf("Invalid bucket: %s, must be one of: %s", bucket, strings.Join(moviedb.TimelineBuckets, ", ")))
+	}
+	data, err := mdb.GetTimeline(bucket)
+	return getData(req, data, err)
+}
+
+func getBreakdown(w http.ResponseWriter, req *http.Request) *web.Page {
+	q := req.URL.Query()
+	options := moviedb.BreakdownOptions{By: q.Get("by"), Sort: "count", Order: "desc", Top: 10}
+	if !oneOf(options.By, moviedb.BreakdownDimensions) {
+		return badRequest(req, fmt.Errorf("Invalid breakdown: %s, must be one of: %s", options.By, strings.Join(moviedb.BreakdownDimensions, ", ")))
+	}
+	if sort := q.Get("sort"); len(sort) > 0 {
+		if !oneOf(sort, moviedb.BreakdownSorts) {
+			return badRequest(req, fmt.Errorf("Invalid sort: %s, must be one of: %s", sort, strings.Join(moviedb.BreakdownSorts, ", ")))
+		}
+		options.Sort = sort
+	}
+	if order := q.Get("order"); len(order) > 0 {
+		if order != "asc" && order != "desc" {
+			return badRequest(req, fmt.Errorf("Invalid order: %s, must be asc or desc", order))
+		}
+		options.Order = order
+	}
+	if top := q.Get("top"); len(top) > 0 {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 0 {
+			return badRequest(req, fmt.Errorf("Invalid top: %s", top))
+		}
+		options.Top = n
+	}
+	data, err := mdb.GetBreakdown(options)
+	return getData(req, data, err)
+}
+
+func getDuplicates(w http.ResponseWriter, req *http.Request) *web.Page {
+	data, err := mdb.GetDuplicates()
+	return getData(req, data, err)
+}
+
+// listingOptions parses the filters and sort order of movie listings, or returns a bad request page
+func listingOptions(req *http.Request) (moviedb.MovieListingOptions, *web.Page) {
+	q := req.URL.Query()
+	for _, key := range []string{"since", "until"} {
+		for _, value := range q[key] {
+			if _, err := moviedb.ParseTime(value); err != nil {
+				return moviedb.MovieListingOptions{}, badRequest(req, fmt.Errorf("Invalid %s: %s, must be a date or RFC 3339 timestamp", key, value))
+			}
+		}
+	}
+	return moviedb.ParseMovieListingOptions(req), nil
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pathId returns the id of the request path, or a bad request page if it is not numeric
+func pathId(req *http.Request) (string, *web.Page) {
+	return pathIdOf(req, "id")
+}
+
+func pathIdOf(req *http.Request, name string) (string, *web.Page) {
+	id := mux.Vars(req)[name]
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", badRequest(req, fmt.Errorf("Invalid id: %s", id))
+	}
+	return id, nil
 }
 
-func getData(data interface{}, err error) *web.Page {
+func getData(req *http.Request, data interface{}, err error) *web.Page {
 	if err != nil {
-		log.Error(err)
-		return web.Error("Error", http.StatusInternalServerError, err)
+		return getError(req, err)
 	}
 	return &web.Page{
 		Content: data,
@@ -155,5 +568,5 @@ func index(w http.ResponseWriter, req *http.Request) *web.Page {
 }
 
 func createError(w http.ResponseWriter, req *http.Request) *web.Page {
-	return web.Error("Error", http.StatusInternalServerError, fmt.Errorf("Error!"))
+	return getError(req, fmt.Errorf("Error!"))
 }
@@ -1,20 +1,38 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
 	"database/sql"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/jamesclonk-io/moviedb-backend/modules/backup"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/jamesclonk-io/moviedb-backend/modules/enrichment"
 	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/moviedb-backend/modules/storage"
+	"github.com/jamesclonk-io/moviedb-backend/modules/thumbnail"
 	"github.com/jamesclonk-io/stdlib/logger"
 	"github.com/jamesclonk-io/stdlib/web/negroni"
 	"github.com/stretchr/testify/assert"
@@ -26,6 +44,8 @@ var (
 	movieTestDbFileCopy string = "_fixtures/test_copy.db"
 	testUser            string = "test123"
 	testPassword        string = "testpw999"
+
+	update = flag.Bool("update", false, "update golden files in _fixtures/golden")
 )
 
 func init() {
@@ -40,10 +60,19 @@ func init() {
 	os.Setenv("JCIO_HTTP_AUTH_USER", testUser)
 	os.Setenv("JCIO_HTTP_AUTH_PASSWORD", testPassword)
 
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
 	m = setup()
 }
 
+func resetDatabase() {
+	copyFile(movieTestDbFile, movieTestDbFileCopy)
+
+	// bring test database schema up to date
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	migration.RunMigrations("./migrations", adapter)
+}
+
 func copyFile(from, to string) {
 	in, err := os.Open(from)
 	if err != nil {
@@ -76,7 +105,7 @@ func Test_Main_404(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, response.Code)
 
 	body := response.Body.String()
-	assert.Contains(t, body, `{"Code":"404","Status":"This is not the JSON you are looking for.."}`)
+	assert.Contains(t, body, `{"error":{"code":"not_found","message":"This is not the JSON you are looking for..","request_id":"`)
 }
 
 func Test_Main_500(t *testing.T) {
@@ -86,11 +115,58 @@ func Test_Main_500(t *testing.T) {
 		t.Error(err)
 	}
 
+	req.Header.Set("X-Request-Id", "abc-123")
+
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, "abc-123", response.Header().Get("X-Request-Id"))
 
+	// internal errors are never passed on to the client
 	body := response.Body.String()
-	assert.Contains(t, body, `"Error!"`)
+	assert.Equal(t, `{"error":{"code":"internal_error","message":"Internal server error","request_id":"abc-123"}}`, body)
+
+	// requests without an id get one
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/500", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	id := response.Header().Get("X-Request-Id")
+	assert.Equal(t, 32, len(id))
+	assert.Contains(t, response.Body.String(), fmt.Sprintf(`"request_id":"%s"`, id))
+
+	// so do requests with an id which is too long or has other characters
+	for _, invalid := range []string{strings.Repeat("a", 65), `abc"<script>`, "abc 123"} {
+		response = httptest.NewRecorder()
+		req.Header.Set("X-Request-Id", invalid)
+
+		m.ServeHTTP(response, req)
+		id = response.Header().Get("X-Request-Id")
+		assert.Equal(t, 32, len(id), invalid)
+		assert.NotContains(t, response.Body.String(), invalid)
+		assert.Contains(t, response.Body.String(), fmt.Sprintf(`"request_id":"%s"`, id))
+	}
+}
+
+func Test_Main_503(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://localhost:4008/movies", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Request-Id", "abc-123")
+
+	page := getError(req, &moviedb.UnavailableError{Err: errors.New("dial tcp: connection refused")})
+	assert.Equal(t, http.StatusServiceUnavailable, page.StatusCode)
+	assert.Equal(t, &errorResponse{&apiError{Code: "unavailable", Message: "database unavailable", RequestId: "abc-123"}}, page.Content)
+	assert.Nil(t, page.Headers)
+
+	// lock contention is only temporary, clients are told to retry
+	page = getError(req, &moviedb.BusyError{Err: errors.New("database is locked")})
+	assert.Equal(t, http.StatusServiceUnavailable, page.StatusCode)
+	assert.Equal(t, &errorResponse{&apiError{Code: "busy", Message: "database busy, please retry", RequestId: "abc-123"}}, page.Content)
+	assert.Equal(t, "1", page.Headers.Get("Retry-After"))
 }
 
 func Test_Main_GetMovie(t *testing.T) {
@@ -106,7 +182,7 @@ func Test_Main_GetMovie(t *testing.T) {
 	body := response.Body.String()
 	assert.Contains(t, body, `"id":914,"title":"Argo"`)
 	assert.Contains(t, body, `"genres":[{"id":27,"name":"Biography"},{"id":6,"name":"Drama"},{"id":28,"name":"History"},{"id":4,"name":"Thriller"}]`)
-	assert.Equal(t, `{"id":914,"title":"Argo","alttitle":{"String":"","Valid":true},"year":2012,"description":"Acting under the cover of a Hollywood producer scouting a location for a science fiction film, a CIA agent launches a dangerous operation to rescue six Americans in Tehran during the U.S. hostage crisis in Iran in 1980.","format":"16:9","length":129,"region":"B","rating":12,"disks":1,"score":5,"picture":"argo.jpg","type":"BluRay","languages":[{"id":1,"name":"Deutsch","country":"Schweiz","native_name":"Deutsch"},{"id":2,"name":"Englisch","country":"USA","native_name":"English"},{"id":3,"name":"Franz\u0026#246;sisch","country":"Frankreich","native_name":"Fran\u0026#231;ais"},{"id":4,"name":"Spanisch","country":"Spanien","native_name":"Espa\u0026#241;ol"}],"genres":[{"id":27,"name":"Biography"},{"id":6,"name":"Drama"},{"id":28,"name":"History"},{"id":4,"name":"Thriller"}],"actors":[{"id":5310,"name":"Alan Arkin"},{"id":331,"name":"Ben Affleck"},{"id":5321,"name":"Bill Tangradi"},{"id":3665,"name":"Bob Gunton"},{"id":3470,"name":"Bryan Cranston"},{"id":4139,"name":"Chris Messina"},{"id":2490,"name":"Christopher Denham"},{"id":5325,"name":"Christopher Stanley"},{"id":40,"name":"Clea DuVall"},{"id":5317,"name":"Farshad Farahat"},{"id":5322,"name":"Jamie McShane"},{"id":942,"name":"John Goodman"},{"id":5319,"name":"Karina Logue"},{"id":5313,"name":"Keith Szarabajka"},{"id":3232,"name":"Kyle Chandler"},{"id":5323,"name":"Matthew Glave"},{"id":5316,"name":"Omid Abtahi"},{"id":4776,"name":"Page Leong"},{"id":5315,"name":"Richard Dillane"},{"id":5314,"name":"Richard Kind"},{"id":5324,"name":"Roberto Garcia"},{"id":5312,"name":"Rory Cochrane"},{"id":5320,"name":"Ryan Ahern"},{"id":1859,"name":"Scoot McNairy"},{"id":5318,"name":"Sheila Vand"},{"id":5311,"name":"Tate Donovan"},{"id":1590,"name":"Titus Welliver"},{"id":3122,"name":"Victor Garber"},{"id":1326,"name":"Zeljko Ivanek"}],"directors":[{"id":331,"name":"Ben Affleck"}]}`, body)
+	assert.Equal(t, `{"id":914,"title":"Argo","alttitle":{"String":"","Valid":false},"alttitles":[],"year":2012,"description":"Acting under the cover of a Hollywood producer scouting a location for a science fiction film, a CIA agent launches a dangerous operation to rescue six Americans in Tehran during the U.S. hostage crisis in Iran in 1980.","format":"16:9","length":129,"region":"B","rating":12,"disks":1,"score":5,"picture":"argo.jpg","type":"BluRay","acquired_at":"2014-01-01T17:11:36Z","created_at":"2014-01-01T17:11:36Z","updated_at":"2014-01-01T17:11:36Z","languages":[{"id":1,"name":"Deutsch","country":"Schweiz","native_name":"Deutsch"},{"id":2,"name":"Englisch","country":"USA","native_name":"English"},{"id":3,"name":"Franz\u0026#246;sisch","country":"Frankreich","native_name":"Fran\u0026#231;ais"},{"id":4,"name":"Spanisch","country":"Spanien","native_name":"Espa\u0026#241;ol"}],"genres":[{"id":27,"name":"Biography"},{"id":6,"name":"Drama"},{"id":28,"name":"History"},{"id":4,"name":"Thriller"}],"actors":[{"id":5310,"name":"Alan Arkin"},{"id":331,"name":"Ben Affleck"},{"id":5321,"name":"Bill Tangradi"},{"id":3665,"name":"Bob Gunton"},{"id":3470,"name":"Bryan Cranston"},{"id":4139,"name":"Chris Messina"},{"id":2490,"name":"Christopher Denham"},{"id":5325,"name":"Christopher Stanley"},{"id":40,"name":"Clea DuVall"},{"id":5317,"name":"Farshad Farahat"},{"id":5322,"name":"Jamie McShane"},{"id":942,"name":"John Goodman"},{"id":5319,"name":"Karina Logue"},{"id":5313,"name":"Keith Szarabajka"},{"id":3232,"name":"Kyle Chandler"},{"id":5323,"name":"Matthew Glave"},{"id":5316,"name":"Omid Abtahi"},{"id":4776,"name":"Page Leong"},{"id":5315,"name":"Richard Dillane"},{"id":5314,"name":"Richard Kind"},{"id":5324,"name":"Roberto Garcia"},{"id":5312,"name":"Rory Cochrane"},{"id":5320,"name":"Ryan Ahern"},{"id":1859,"name":"Scoot McNairy"},{"id":5318,"name":"Sheila Vand"},{"id":5311,"name":"Tate Donovan"},{"id":1590,"name":"Titus Welliver"},{"id":3122,"name":"Victor Garber"},{"id":1326,"name":"Zeljko Ivanek"}],"directors":[{"id":331,"name":"Ben Affleck"}]}`, body)
 
 	response = httptest.NewRecorder()
 	req, err = http.NewRequest("POST", "https://localhost:4008/movie", nil)
@@ -148,11 +224,39 @@ func Test_Main_GetMovie(t *testing.T) {
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusUnauthorized, response.Code)
 	assert.Contains(t, response.Body.String(), `"Unauthorized!"`)
+
+	// missing movie, also for its sub resources
+	for _, path := range []string{"/movie/999999", "/movie/999999/picture", "/movie/999999/enrichment"} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.SetBasicAuth(testUser, testPassword)
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusNotFound, response.Code, path)
+		assert.Contains(t, response.Body.String(), `{"error":{"code":"not_found","message":"movie 999999 not found"`, path)
+	}
+
+	// non-numeric ids
+	for _, path := range []string{"/movie/argo", "/movie/1e3", "/movie/argo/picture", "/movie/argo/enrichment"} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.SetBasicAuth(testUser, testPassword)
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusBadRequest, response.Code, path)
+		assert.Contains(t, response.Body.String(), `"code":"bad_request"`, path)
+	}
 }
 
 func Test_Main_DeleteMovie(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
-	defer copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
+	defer resetDatabase()
 
 	// first with wrong auth
 	response := httptest.NewRecorder()
@@ -188,7 +292,7 @@ func Test_Main_DeleteMovie(t *testing.T) {
 	assert.Equal(t, http.StatusOK, response.Code)
 
 	body := response.Body.String()
-	assert.Contains(t, body, `{"RowsDeleted":10}`)
+	assert.Contains(t, body, `{"RowsDeleted":11}`)
 
 	// is it gone?
 	response = httptest.NewRecorder()
@@ -198,12 +302,36 @@ func Test_Main_DeleteMovie(t *testing.T) {
 	}
 
 	m.ServeHTTP(response, req)
-	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	// deleting it again
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "https://localhost:4008/movie/7", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Contains(t, response.Body.String(), `{"error":{"code":"not_found","message":"movie 7 not found"`)
+
+	// non-numeric id
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "https://localhost:4008/movie/abc", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `{"error":{"code":"bad_request","message":"Invalid id: abc"`)
 }
 
 func Test_Main_AddMovie(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
-	defer copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
+	defer resetDatabase()
 
 	// is it not there yet?
 	response := httptest.NewRecorder()
@@ -213,7 +341,7 @@ func Test_Main_AddMovie(t *testing.T) {
 	}
 
 	m.ServeHTTP(response, req)
-	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, http.StatusNotFound, response.Code)
 
 	// first with missing auth
 	response = httptest.NewRecorder()
@@ -227,19 +355,21 @@ func Test_Main_AddMovie(t *testing.T) {
 	assert.Contains(t, response.Body.String(), `"Unauthorized!"`)
 
 	// now with correct auth
+	acquired := time.Date(2015, 3, 14, 9, 26, 53, 0, time.UTC)
 	newMovie := &moviedb.Movie{
-		Id:       0,
-		Title:    "Super Testfilm",
-		Alttitle: sql.NullString{"The ultimate test!", true},
-		Year:     2039,
-		Score:    3,
-		Rating:   16,
-		Region:   "1",
-		Format:   "16:9",
-		Disks:    3,
-		Type:     "BluRay",
-		Length:   234,
-		Picture:  "super_testfilm.jpg",
+		Id:         0,
+		Title:      "Super Testfilm",
+		Alttitle:   sql.NullString{String: "The ultimate test!", Valid: true},
+		Year:       2039,
+		Score:      3,
+		Rating:     16,
+		Region:     "1",
+		Format:     "16:9",
+		Disks:      3,
+		Type:       "BluRay",
+		Length:     234,
+		Picture:    "super_testfilm.jpg",
+		AcquiredAt: &acquired,
 		Languages: []*moviedb.Language{
 			&moviedb.Language{Name: "Deutsch"},
 			&moviedb.Language{Name: "1337"},
@@ -289,150 +419,508 @@ func Test_Main_AddMovie(t *testing.T) {
 
 	body = response.Body.String()
 	assert.Contains(t, body, `{"id":915,"title":"Super Testfilm"`)
-	assert.Equal(t, `{"id":915,"title":"Super Testfilm","alttitle":{"String":"The ultimate test!","Valid":true},"year":2039,"description":"","format":"16:9","length":234,"region":"1","rating":16,"disks":3,"score":3,"picture":"super_testfilm.jpg","type":"BluRay","languages":[{"id":23,"name":"1337","country":"","native_name":""},{"id":1,"name":"Deutsch","country":"Schweiz","native_name":"Deutsch"},{"id":24,"name":"Serbokroatisch","country":"","native_name":""}],"genres":[{"id":34,"name":"Deutsche Soap"},{"id":4,"name":"Thriller"}],"actors":[{"id":7,"name":"Brad Pitt"},{"id":8,"name":"Edward Norton"},{"id":5326,"name":"Looize de Testador"}],"directors":[{"id":11,"name":"David Fincher"},{"id":5327,"name":"Senõr Spielbergo"}]}`, body)
+
+	// timestamps are set when saving
+	timestamps := regexp.MustCompile(`"created_at":"(\d{4}-\d\d-\d\dT[\d:]+Z)","updated_at":"(\d{4}-\d\d-\d\dT[\d:]+Z)",`)
+	matches := timestamps.FindStringSubmatch(body)
+	if assert.Equal(t, 3, len(matches)) {
+		assert.Equal(t, matches[1], matches[2])
+	}
+	body = timestamps.ReplaceAllString(body, "")
+	assert.Equal(t, `{"id":915,"title":"Super Testfilm","alttitle":{"String":"The ultimate test!","Valid":true},"alttitles":[{"id":532,"title":"The ultimate test!","type":"localized"}],"year":2039,"description":"","format":"16:9","length":234,"region":"1","rating":16,"disks":3,"score":3,"picture":"super_testfilm.jpg","type":"BluRay","acquired_at":"2015-03-14T09:26:53Z","languages":[{"id":23,"name":"1337","country":"","native_name":""},{"id":1,"name":"Deutsch","country":"Schweiz","native_name":"Deutsch"},{"id":24,"name":"Serbokroatisch","country":"","native_name":""}],"genres":[{"id":34,"name":"Deutsche Soap"},{"id":4,"name":"Thriller"}],"actors":[{"id":7,"name":"Brad Pitt"},{"id":8,"name":"Edward Norton"},{"id":5326,"name":"Looize de Testador"}],"directors":[{"id":11,"name":"David Fincher"},{"id":5327,"name":"Senõr Spielbergo"}]}`, body)
+
+	// invalid movies are rejected with all violations
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/movie", strings.NewReader(`{"title":"","year":0,"length":-1,"disks":1,"type":"Laserdisc"}`))
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"validation_failed"`)
+	assert.Contains(t, response.Body.String(), `"details":[{"field":"title","rule":"required","message":"must not be empty"},{"field":"year","rule":"range","message":"must be between 1888 and 2100"},{"field":"length","rule":"range","message":"must be at least 0"},{"field":"type","rule":"reference","message":"must be one of: DVD, BluRay"}]`)
 }
 
-func Test_Main_Movies(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
+func Test_Main_Batch(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	batch := func(body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "https://localhost:4008/batch", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+		}
+		req.SetBasicAuth(testUser, testPassword)
+		m.ServeHTTP(response, req)
+		return response
+	}
+	movie := func(id string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "https://localhost:4008/movie/"+id, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		m.ServeHTTP(response, req)
+		return response
+	}
 
+	// batches need auth
 	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/movies", nil)
+	req, err := http.NewRequest("POST", "https://localhost:4008/batch", strings.NewReader(`{"operations":[{"op":"delete","id":7}]}`))
 	if err != nil {
 		t.Error(err)
 	}
-
 	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	// all operations in one transaction, later ones see the changes of earlier ones
+	response = batch(`{"operations":[
+		{"op":"create","force":true,"movie":{"title":"Batch Movie","year":2020,"type":"DVD","disks":1,"genres":[{"name":"Action"}]}},
+		{"op":"patch","id":915,"movie":{"title":"Batch Movie 2","genres":[{"name":"Drama"}]}},
+		{"op":"patch","id":1,"movie":{"score":1}},
+		{"op":"update","id":2,"movie":{"title":"Replaced","year":2001,"type":"BluRay","disks":2}},
+		{"op":"delete","id":7}
+	]}`)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"committed":true,"succeeded":5,"failed":0,"results":[`+
+		`{"index":0,"op":"create","id":915,"status":"ok"},`+
+		`{"index":1,"op":"patch","id":915,"status":"ok"},`+
+		`{"index":2,"op":"patch","id":1,"status":"ok"},`+
+		`{"index":3,"op":"update","id":2,"status":"ok"},`+
+		`{"index":4,"op":"delete","id":7,"status":"ok","rows_deleted":11}]}`, response.Body.String())
+
+	body := movie("915").Body.String()
+	assert.Contains(t, body, `"title":"Batch Movie 2","alttitle":`)
+	assert.Contains(t, body, `"year":2020,`)
+	assert.Contains(t, body, `"genres":[{"id":1,"name":"Action"},{"id":6,"name":"Drama"}]`)
+	body = movie("1").Body.String()
+	assert.Contains(t, body, `"title":"Face/Off"`)
+	assert.Contains(t, body, `"score":1,`)
+	assert.NotContains(t, body, `"actors":[]`)
+	body = movie("2").Body.String()
+	assert.Contains(t, body, `"title":"Replaced"`)
+	assert.Contains(t, body, `"disks":2,`)
+	assert.Contains(t, body, `"type":"BluRay"`)
+	assert.Equal(t, http.StatusNotFound, movie("7").Code)
+
+	// the first failure rolls everything back
+	response = batch(`{"operations":[
+		{"op":"patch","id":1,"movie":{"score":4}},
+		{"op":"patch","id":7,"movie":{"score":4}},
+		{"op":"delete","id":3}
+	]}`)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Contains(t, response.Body.String(), `{"committed":false,"succeeded":0,"failed":1,"results":[`+
+		`{"index":0,"op":"patch","id":1,"status":"rolled_back"},`+
+		`{"index":1,"op":"patch","id":7,"status":"failed","status_code":404,"error":{"code":"not_found","message":"movie 7 not found",`)
+	assert.Contains(t, response.Body.String(), `{"index":2,"op":"delete","id":3,"status":"skipped"}]}`)
+	assert.Contains(t, movie("1").Body.String(), `"score":1,`)
+	assert.Equal(t, http.StatusOK, movie("3").Code)
+
+	// or only failed operations are left out
+	response = batch(`{"continue_on_error":true,"operations":[
+		{"op":"patch","id":1,"movie":{"score":4}},
+		{"op":"create","movie":{"title":"No Type","year":2020,"disks":1}},
+		{"op":"patch","id":3,"movie":{"year":1700}},
+		{"op":"delete","id":3}
+	]}`)
+	assert.Equal(t, http.StatusOK, response.Code)
+	body = response.Body.String()
+	assert.Contains(t, body, `{"committed":true,"succeeded":2,"failed":2,"results":[{"index":0,"op":"patch","id":1,"status":"ok"},`)
+	assert.Contains(t, body, `{"index":1,"op":"create","status":"failed","status_code":422,"error":{"code":"validation_failed",`)
+	assert.Contains(t, body, `"details":[{"field":"type","rule":"required","message":"must not be empty"}]}}`)
+	assert.Contains(t, body, `{"index":2,"op":"patch","id":3,"status":"failed","status_code":422,`)
+	assert.Contains(t, body, `{"index":3,"op":"delete","id":3,"status":"ok","rows_deleted":`)
+	assert.Contains(t, movie("1").Body.String(), `"score":4,`)
+	assert.Equal(t, http.StatusNotFound, movie("3").Code)
+	assert.Equal(t, http.StatusNotFound, movie("916").Code)
+
+	// duplicate checks see the movies created and deleted by earlier operations
+	response = batch(`{"continue_on_error":true,"operations":[
+		{"op":"create","movie":{"title":"Twice","year":2020,"type":"DVD","disks":1}},
+		{"op":"create","movie":{"title":"Twice","year":2020,"type":"DVD","disks":1}},
+		{"op":"delete","id":181},
+		{"op":"delete","id":420},
+		{"op":"create","movie":{"title":"The Last Samurai","year":2003,"type":"DVD","disks":1}}
+	]}`)
 	assert.Equal(t, http.StatusOK, response.Code)
+	body = response.Body.String()
+	assert.Contains(t, body, `{"committed":true,"succeeded":4,"failed":1,"results":[{"index":0,"op":"create","id":916,"status":"ok"},`)
+	assert.Contains(t, body, `{"index":1,"op":"create","status":"failed","status_code":409,"error":{"code":"conflict",`)
+	assert.Contains(t, body, `"details":[{"id":916,"title":"Twice","year":2020,"reasons":["title","year"]}]}}`)
+	assert.Contains(t, body, `{"index":4,"op":"create","id":917,"status":"ok"}]}`)
+
+	// batches are read up to a limit
+	response = batch(`{"operations":[{"op":"delete","id":1,"padding":"` + strings.Repeat("a", maxBatchSize) + `"}]}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"payload_too_large","message":"Batch too large"`)
+	assert.Equal(t, http.StatusOK, movie("1").Code)
+
+	// malformed batches are rejected before anything is run
+	for body, message := range map[string]string{
+		`{"operations":[]}`: `"message":"No operations given"`,
+		`{"operations":[{"op":"patch","id":1,"movie":{"score":2}},{"op":"replace","id":1}]}`: `"message":"Invalid operation 1: op replace, must be one of: create, update, patch, delete"`,
+		`{"operations":[{"op":"delete"}]}`:                                                   `"message":"Invalid operation 0: delete needs an id"`,
+		`{"operations":[{"op":"update","id":1}]}`:                                            `"message":"Invalid operation 0: update needs a movie"`,
+		`{"operations":[{"op":"patch","id":1,"movie":null}]}`:                                `"message":"Invalid operation 0: patch needs a movie"`,
+		`[`: `"code":"bad_request"`,
+	} {
+		response = batch(body)
+		assert.Equal(t, http.StatusBadRequest, response.Code, body)
+		assert.Contains(t, response.Body.String(), message, body)
+	}
+	assert.Contains(t, movie("1").Body.String(), `"score":4,`)
+}
 
-	body := response.Body.String()
-	assert.Contains(t, body, `{"id":1,"title":"Face/Off","year":1997,"score":5,"rating":16}`)
-	assert.Contains(t, body, `{"id":135,"title":"James Bond 007:\u003cbr/\u003eOn her Majesty's Secret Service","year":1969,"score":2,"rating":16}`)
-	assert.Contains(t, body, `{"id":283,"title":"Sie nannten ihn M\u0026#252;cke","year":1978,"score":3,"rating":12}`)
+func Test_Main_Duplicates(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
 
-	response = httptest.NewRecorder()
-	req, err = http.NewRequest("GET", "https://localhost:4008/movies?sort=title&by=desc", nil)
+	// report needs auth
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/duplicates", nil)
 	if err != nil {
 		t.Error(err)
 	}
 
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	response = httptest.NewRecorder()
+	req.SetBasicAuth(testUser, testPassword)
+
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
 
-	body = response.Body.String()
-	assert.True(t, strings.HasPrefix(body, `[{"id":151,"title":"Zwei sind nicht zu bremsen","year":1978,"score":3,"rating":12}`))
+	var pairs []*moviedb.DuplicatePair
+	if err := json.Unmarshal(response.Body.Bytes(), &pairs); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 20, len(pairs))
+	assert.Contains(t, response.Body.String(), `{"movies":[{"id":1,"title":"Face/Off","year":1997},{"id":414,"title":"Face/Off","year":1997}],"reasons":["title","year","director"]}`)
 
+	// adding a duplicate is refused
+	data := []byte(`{"title":"The Last Samurai","year":2003,"disks":1,"type":"DVD","directors":[{"name":"Edward Zwick"}]}`)
 	response = httptest.NewRecorder()
-	req, err = http.NewRequest("GET", "https://localhost:4008/movies?sort=year&by=desc&sort=title&by=desc", nil)
+	req, err = http.NewRequest("POST", "https://localhost:4008/movie", bytes.NewBuffer(data))
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"conflict"`)
+	assert.Contains(t, response.Body.String(), `"details":[{"id":181,"title":"The Last Samurai","year":2003,"reasons":["title","year","director"]},{"id":420,"title":"The Last Samurai","year":2003,"reasons":["title","year","director"]}]`)
+
+	// unless forced
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/movie?force=true", bytes.NewBuffer(data))
 	if err != nil {
 		t.Error(err)
 	}
+	req.SetBasicAuth(testUser, testPassword)
 
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"Result":"OK"}`, response.Body.String())
+}
 
-	body = response.Body.String()
-	assert.True(t, strings.HasPrefix(body, `[{"id":893,"title":"World War Z","year":2013,"score":3,"rating":16}`))
+func Test_Main_ImportCommand(t *testing.T) {
+	assert.Equal(t, errUsage, importCommand([]string{}))
+	assert.Equal(t, errUsage, importCommand([]string{"-unknown", "movies.csv"}))
+	assert.Equal(t, flag.ErrHelp, importCommand([]string{"-h"}))
+}
 
-	response = httptest.NewRecorder()
-	req, err = http.NewRequest("GET", "https://localhost:4008/movies?sort=title&by=asc&query=year&value=2013&query=score&value=4", nil)
+func Test_Main_Import(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	data := "Name;Year;Type;Actors;Genres\n" +
+		"Mad Max 2;1981;DVD;Mel Gibson|Bruce Spence;Action|Sci-Fi\n" +
+		"The Last Samurai;2003;DVD;Tom Cruise;Drama\n" +
+		"Mad Max 3;1985;DVD;Mel Gibson;Action\n" +
+		"Mad Max 4;;;;\n"
+
+	// import needs auth
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "https://localhost:4008/import?dry_run=true&separator=%3B&map=title:Name", strings.NewReader(data))
 	if err != nil {
 		t.Error(err)
 	}
 
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	// dry run reports every row, but saves nothing
+	response = httptest.NewRecorder()
+	req.SetBasicAuth(testUser, testPassword)
+
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `{"dry_run":true,"rows":4,"valid":2,"invalid":1,"duplicates":1,"imported":0,"failed":0,`)
+	assert.Contains(t, response.Body.String(), `{"line":3,"title":"The Last Samurai","status":"duplicate","duplicates":[{"id":181,"title":"The Last Samurai","year":2003,"reasons":["title","year"]},{"id":420,"title":"The Last Samurai","year":2003,"reasons":["title","year"]}]}`)
+	assert.Contains(t, response.Body.String(), `{"line":5,"title":"Mad Max 4","status":"invalid","errors":[{"field":"year","rule":"range","message":"must be between 1888 and 2100"},{"field":"type","rule":"required","message":"must not be empty"}]}`)
 
-	body = response.Body.String()
-	assert.True(t, strings.HasPrefix(body, `[{"id":856,"title":"House of Cards (1)","year":2013,"score":4,"rating":16}`))
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/915", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
 
+	// real run imports valid rows in batches
 	response = httptest.NewRecorder()
-	req, err = http.NewRequest("GET", "https://localhost:4008/movies?query=year&value=2013&query=language&value=3&query=genre&value=9&sort=title&by=asc", nil)
+	req, err = http.NewRequest("POST", "https://localhost:4008/import?batch_size=1&separator=%3B&map=title:Name", strings.NewReader(data))
 	if err != nil {
 		t.Error(err)
 	}
+	req.SetBasicAuth(testUser, testPassword)
 
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `{"dry_run":false,"rows":4,"valid":2,"invalid":1,"duplicates":1,"imported":2,"failed":0,`)
+	assert.Contains(t, response.Body.String(), `{"line":2,"title":"Mad Max 2","status":"imported","id":915}`)
+	assert.Contains(t, response.Body.String(), `{"line":4,"title":"Mad Max 3","status":"imported","id":916}`)
 
-	body = response.Body.String()
-	assert.True(t, strings.HasPrefix(body, `[{"id":867,"title":"Kick-Ass 2","year":2013,"score":3,"rating":18}`))
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/915", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"genres":[{"id":`)
+	assert.Contains(t, response.Body.String(), `"name":"Bruce Spence"`)
 
+	// other formats, with titles similar to existing movies
 	response = httptest.NewRecorder()
-	req, err = http.NewRequest("GET", "https://localhost:4008/movies?query=director&value=331&query=actor&value=2145", nil)
+	req, err = http.NewRequest("POST", "https://localhost:4008/import?dry_run=true&format=list&type=BluRay",
+		strings.NewReader("Mad Max 2 (1981)\nThe Last Samurrai (2003)\n"))
 	if err != nil {
 		t.Error(err)
 	}
+	req.SetBasicAuth(testUser, testPassword)
 
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `{"dry_run":true,"rows":2,"valid":0,"invalid":0,"duplicates":1,"imported":0,"failed":0,"matches":1,`)
+	assert.Contains(t, response.Body.String(), `{"line":2,"title":"The Last Samurrai","status":"match","matches":[{"id":181,"title":"The Last Samurai","year":2003,"reasons":["similar_title","year"],"similarity":0.9230769230769231},`)
+
+	// invalid options
+	for url, message := range map[string]string{
+		"https://localhost:4008/import?format=xls":                `"message":"Invalid format: xls, must be one of: csv, dvdprofiler, letterboxd, list"`,
+		"https://localhost:4008/import?batch_size=0":              `"message":"Invalid batch_size: 0"`,
+		"https://localhost:4008/import?separator=%3B%3B":          `"message":"Invalid separator: ;;, must be a single character"`,
+		"https://localhost:4008/import?map=name:Name":             `"message":"Invalid mapping field: name, must be one of: title, alttitles, `,
+		"https://localhost:4008/import?separator=%3B&map=title:X": `"message":"Column X mapped to title not found"`,
+	} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", url, strings.NewReader(data))
+		if err != nil {
+			t.Error(err)
+		}
+		req.SetBasicAuth(testUser, testPassword)
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusBadRequest, response.Code, url)
+		assert.Contains(t, response.Body.String(), message, url)
+	}
 
-	body = response.Body.String()
-	assert.Equal(t, `[{"id":647,"title":"The Town","year":2010,"score":4,"rating":16}]`, body)
+	// collections are read up to a limit
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/import?dry_run=true&format=list", strings.NewReader(strings.Repeat(strings.Repeat("a", 999)+"\n", maxImportSize/1000+1)))
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"payload_too_large","message":"Collection too large"`)
 }
 
-func Test_Main_Languages(t *testing.T) {
+func Test_Main_Export(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	// whole collection, one movie per line
 	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/languages", nil)
+	req, err := http.NewRequest("GET", "https://localhost:4008/export?format=ndjson", nil)
 	if err != nil {
 		t.Error(err)
 	}
 
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/x-ndjson; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="movies.ndjson"`, response.Header().Get("Content-Disposition"))
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if assert.Equal(t, 912, len(lines)) {
+		var movie moviedb.Movie
+		if err := json.Unmarshal([]byte(lines[911]), &movie); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "[914] Argo (2012)", movie.String())
+		assert.Equal(t, "Ben Affleck", movie.Directors[0].Name)
+	}
 
-	body := response.Body.String()
-	assert.Contains(t, body, `{"id":17,"name":"Koreanisch","country":"Korea","native_name":"Hangul"}`)
-	assert.Contains(t, body, `{"id":11,"name":"Thail\u0026#228;ndisch","country":"Thailand","native_name":"Phasa Thai"}`)
-}
+	// filtered, as json array
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/export?query=year&value=2012&sort=title&by=asc", nil)
+	if err != nil {
+		t.Error(err)
+	}
 
-func Test_Main_Genres(t *testing.T) {
-	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/genres", nil)
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json; charset=utf-8", response.Header().Get("Content-Type"))
+	var movies []*moviedb.Movie
+	if err := json.Unmarshal(response.Body.Bytes(), &movies); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 51, len(movies)) {
+		assert.Equal(t, "21 Jump Street", movies[0].Title)
+		assert.NotEmpty(t, movies[0].Actors)
+	}
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/export?query=year&value=1800", nil)
 	if err != nil {
 		t.Error(err)
 	}
 
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "[]\n", response.Body.String())
 
-	body := response.Body.String()
-	assert.Contains(t, body, `{"id":28,"name":"History"},{"id":3,"name":"Horror"}`)
-	assert.Contains(t, body, `{"id":15,"name":"War"},{"id":10,"name":"Western"}`)
-}
+	// csv can be imported again, where every movie is a duplicate of itself
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/export?format=csv&query=year&value=2012", nil)
+	if err != nil {
+		t.Error(err)
+	}
 
-func Test_Main_GetPerson(t *testing.T) {
-	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/person/470", nil)
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(response.Body.String(), "title,alttitles,year,description,format,length,region,rating,disks,score,picture,type,acquired_at,languages,genres,actors,directors\n"))
+
+	data := response.Body.String()
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/import?dry_run=true", strings.NewReader(data))
 	if err != nil {
 		t.Error(err)
 	}
+	req.SetBasicAuth(testUser, testPassword)
 
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `{"dry_run":true,"rows":51,"valid":0,"invalid":0,"duplicates":51,`)
+	assert.Contains(t, response.Body.String(), `{"line":52,"title":"Argo","status":"duplicate","duplicates":[{"id":914,"title":"Argo","year":2012,"reasons":["title","year","director"]}]}`)
 
-	body := response.Body.String()
-	assert.Equal(t, `{"id":470,"name":"Roger Moore"}`, body)
+	// invalid format
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/export?format=xls", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `"message":"Invalid format: xls, must be one of: csv, json, ndjson"`)
+	assert.Equal(t, "", response.Header().Get("Content-Disposition"))
 }
 
-func Test_Main_Actors(t *testing.T) {
+func Test_Main_BackupCommands(t *testing.T) {
+	assert.Equal(t, errUsage, backupCommand([]string{"a.zip", "b.zip"}))
+	assert.Equal(t, errUsage, restoreCommand([]string{}))
+	assert.Equal(t, errUsage, copyCommand([]string{"sqlite3://a.db"}))
+	assert.Equal(t, flag.ErrHelp, copyCommand([]string{"-help"}))
+}
+
+func Test_Main_Backup(t *testing.T) {
+	resetDatabase()
+
+	// backups need auth
 	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/actors", nil)
+	req, err := http.NewRequest("GET", "https://localhost:4008/backup", nil)
 	if err != nil {
 		t.Error(err)
 	}
 
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	response = httptest.NewRecorder()
+	req.SetBasicAuth(testUser, testPassword)
+
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/zip", response.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="moviedb-backup-\d{8}T\d{6}Z\.zip"$`, response.Header().Get("Content-Disposition"))
 
-	body := response.Body.String()
-	assert.Contains(t, body, `{"id":141,"name":"Alan Rickman"}`)
-	assert.Contains(t, body, `{"id":137,"name":"Bruce Willis"}`)
-	assert.Contains(t, body, `{"id":254,"name":"Leonardo DiCaprio"}`)
+	archive, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	if assert.NotNil(t, files["manifest.json"]) && assert.NotNil(t, files["movie_movie.ndjson"]) {
+		in, err := files["manifest.json"].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		var manifest backup.Manifest
+		if err := json.NewDecoder(in).Decode(&manifest); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, backup.FormatVersion, manifest.FormatVersion)
+		assert.Equal(t, "sqlite", manifest.DatabaseType)
+		assert.Equal(t, len(backup.Tables), len(manifest.Tables))
+
+		in, err = files["movie_movie.ndjson"].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if assert.Equal(t, 912, len(lines)) {
+			assert.True(t, strings.HasPrefix(lines[911], `{"id":914,"title":"Argo","year":2012,`))
+		}
+	}
+
+	// a database that can't be read is reported before any of the zip is sent
+	closed := database.NewAdapter()
+	closed.Database.Close()
+	open := db
+	db = closed
+	defer func() { db = open }()
+
+	response = httptest.NewRecorder()
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, "application/json; charset=UTF-8", response.Header().Get("Content-Type"))
+	assert.Empty(t, response.Header().Get("Content-Disposition"))
+	assert.Contains(t, response.Body.String(), `"code":"internal_error"`)
 }
 
-func Test_Main_Directors(t *testing.T) {
+func Test_Main_Movies(t *testing.T) {
+	resetDatabase()
+
 	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/directors", nil)
+	req, err := http.NewRequest("GET", "https://localhost:4008/movies", nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -441,14 +929,12 @@ func Test_Main_Directors(t *testing.T) {
 	assert.Equal(t, http.StatusOK, response.Code)
 
 	body := response.Body.String()
-	assert.Contains(t, body, `{"id":11,"name":"David Fincher"}`)
-	assert.Contains(t, body, `{"id":331,"name":"Ben Affleck"}`)
-	assert.Contains(t, body, `{"id":417,"name":"John Carpenter"}`)
-}
+	assert.Contains(t, body, `{"id":1,"title":"Face/Off","year":1997,"score":5,"rating":16}`)
+	assert.Contains(t, body, `{"id":135,"title":"James Bond 007:\u003cbr/\u003eOn her Majesty's Secret Service","year":1969,"score":2,"rating":16}`)
+	assert.Contains(t, body, `{"id":283,"title":"Sie nannten ihn M\u0026#252;cke","year":1978,"score":3,"rating":12}`)
 
-func Test_Main_Statistics(t *testing.T) {
-	response := httptest.NewRecorder()
-	req, err := http.NewRequest("GET", "https://localhost:4008/statistics", nil)
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies?sort=title&by=desc", nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -456,17 +942,1088 @@ func Test_Main_Statistics(t *testing.T) {
 	m.ServeHTTP(response, req)
 	assert.Equal(t, http.StatusOK, response.Code)
 
-	body := response.Body.String()
-	assert.Contains(t, body, `"ground_zero":"1999-08-01T00:13:37Z","last_update":"2014-01-01T17:11:36Z","count":912`)
-	assert.Contains(t, body, `"movie_types":[{"type":"DVD","disks":1238,"length":154253,"count":602},{"type":"BluRay","disks":493,"length":61691,"count":310}]`)
-	assert.Contains(t, body, `"actors":4696,"directors":578,"people_total":5244`)
-	assert.Contains(t, body, `"top5_actors":[{"id":483,"name":"Bud Spencer","count":26},{"id":338,"name":"Matt Damon","count":22},{"id":369,"name":"Desmond Llewelyn","count":21},{"id":76,"name":"Cate Blanchett","count":16},{"id":651,"name":"Morgan Freeman","count":16}]`)
-	assert.Contains(t, body, `"top5_directors":[{"id":493,"name":"Kenji Kamiyama","count":17},{"id":127,"name":"George Lucas","count":11},{"id":70,"name":"Peter Jackson","count":11},{"id":64,"name":"Steven Spielberg","count":11},{"id":121,"name":"Ridley Scott","count":9}]`)
-	assert.Contains(t, body, `"top5_actors_and_directors":[{"id":396,"name":"Clint Eastwood","count":20},{"id":189,"name":"Mel Gibson","count":14},{"id":220,"name":"George Clooney","count":12},{"id":211,"name":"Quentin Tarantino","count":12},{"id":331,"name":"Ben Affleck","count":11}]`)
-	assert.Contains(t, body, `"regions":[{"type":"0","count":12},{"type":"1","count":74},{"type":"2","count":514},{"type":"3","count":1},{"type":"B","count":311}]`)
-	assert.Contains(t, body, `"ratings":[{"type":"21","count":12},{"type":"18","count":132},{"type":"16","count":397},{"type":"12","count":304},{"type":"6","count":67}]`)
-	assert.Contains(t, body, `"scores":[{"type":"5","count":92},{"type":"4","count":214},{"type":"3","count":520},{"type":"2","count":81},{"type":"1","count":5}]`)
-	assert.Contains(t, body, `"dvd_movies":602,"bluray_movies":310,"dvd_disks":1238,"bluray_disks":493,"total_length":215944,"avg_length_per_movie":236,"avg_length_per_disk":124`)
-	assert.Contains(t, body, `avg_movies_per_day`)
-	assert.Contains(t, body, `new_movies_estimate`)
+	body = response.Body.String()
+	assert.True(t, strings.HasPrefix(body, `[{"id":151,"title":"Zwei sind nicht zu bremsen","year":1978,"score":3,"rating":12}`))
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies?sort=year&by=desc&sort=title&by=desc", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body = response.Body.String()
+	assert.True(t, strings.HasPrefix(body, `[{"id":893,"title":"World War Z","year":2013,"score":3,"rating":16}`))
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies?sort=title&by=asc&query=year&value=2013&query=score&value=4", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body = response.Body.String()
+	assert.True(t, strings.HasPrefix(body, `[{"id":856,"title":"House of Cards (1)","year":2013,"score":4,"rating":16}`))
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies?query=year&value=2013&query=language&value=3&query=genre&value=9&sort=title&by=asc", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body = response.Body.String()
+	assert.True(t, strings.HasPrefix(body, `[{"id":867,"title":"Kick-Ass 2","year":2013,"score":3,"rating":18}`))
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies?query=director&value=331&query=actor&value=2145", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body = response.Body.String()
+	assert.Equal(t, `[{"id":647,"title":"The Town","year":2010,"score":4,"rating":16}]`, body)
+}
+
+func Test_Main_ContentNegotiation(t *testing.T) {
+	requestIds := regexp.MustCompile(`<request_id>[0-9a-f]+</request_id>`)
+
+	// XML is compared against golden files
+	for url, golden := range map[string]string{
+		"/movie/914":                            "movie.xml",
+		"/movies?query=year&value=2013":         "movies.xml",
+		"/statistics/breakdown?by=decade&top=3": "breakdown.xml",
+		"/person/331/collaborators":             "collaborators.xml",
+		"/movie/9999":                           "error.xml",
+		"/people/330/path/338":                  "path.xml",
+	} {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "https://localhost:4008"+url, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.Header.Set("Accept", "application/xml")
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, "application/xml; charset=UTF-8", response.Header().Get("Content-Type"), url)
+		assert.Equal(t, "Accept", response.Header().Get("Vary"), url)
+
+		body := requestIds.ReplaceAllString(response.Body.String(), "<request_id></request_id>")
+		file := "_fixtures/golden/" + golden
+		if *update {
+			if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(expected), body, url)
+	}
+
+	// CSV for lists only, falling back to the next accepted format
+	for _, c := range []struct {
+		url, accept string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/movies?query=year&value=2013&sort=title&by=asc", "text/csv", http.StatusOK, "text/csv; charset=UTF-8",
+			"id,title,year,score,rating\n887,42,2013,3,6\n875,A Good Day to Die Hard,2013,3,16\n"},
+		{"/movies/recent?limit=2", "text/csv", http.StatusOK, "text/csv; charset=UTF-8",
+			"movie.id,movie.title,movie.year,movie.score,movie.rating,change,created_at,updated_at\n914,Argo,2012,5,12,added,2014-01-01T17:11:36Z,2014-01-01T17:11:36Z\n"},
+		{"/movie/914/similar?limit=1", "text/csv;q=0.9, application/json;q=0.1", http.StatusOK, "text/csv; charset=UTF-8",
+			"movie.id,movie.title,movie.year,movie.score,movie.rating,similarity,reasons\n647,The Town,2010,4,16,5.565,genre|director|actor|language|decade\n"},
+		{"/movie/914", "text/csv", http.StatusNotAcceptable, "application/json; charset=UTF-8",
+			`"code":"not_acceptable","message":"Response can not be rendered as csv"`},
+		{"/movie/914", "text/csv, application/xml;q=0.5", http.StatusOK, "application/xml; charset=UTF-8",
+			`<movie id="914">`},
+		{"/movie/914", "text/csv, application/*;q=0.5", http.StatusOK, "application/json; charset=UTF-8",
+			`{"id":914,"title":"Argo"`},
+		{"/languages", "text/html, */*;q=0.8", http.StatusOK, "application/json; charset=UTF-8",
+			`[{"id":5,"name":"Chinesisch"`},
+		{"/languages", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", http.StatusOK, "application/json; charset=UTF-8",
+			`[{"id":5,"name":"Chinesisch"`},
+		{"/languages", "application/xml;q=0.9, */*;q=0.8", http.StatusOK, "application/xml; charset=UTF-8",
+			"<results>\n  <language id=\""},
+		{"/languages", "application/json;q=0, */*", http.StatusOK, "application/xml; charset=UTF-8",
+			"<results>\n  <language id=\""},
+		{"/languages", "image/png", http.StatusOK, "application/json; charset=UTF-8",
+			`[{"id":5,"name":"Chinesisch"`},
+		{"/languages", "application/json;q=0.5, text/xml", http.StatusOK, "application/xml; charset=UTF-8",
+			"<results>\n  <language id=\""},
+		{"/movie/abc", "text/csv", http.StatusNotAcceptable, "application/json; charset=UTF-8",
+			`"code":"not_acceptable"`},
+	} {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "https://localhost:4008"+c.url, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.Header.Set("Accept", c.accept)
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, c.status, response.Code, c.url)
+		assert.Equal(t, c.contentType, response.Header().Get("Content-Type"), c.url)
+		assert.Contains(t, response.Body.String(), c.body, c.url)
+	}
+}
+
+func Test_Main_Languages(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/languages", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `{"id":17,"name":"Koreanisch","country":"Korea","native_name":"Hangul"}`)
+	assert.Contains(t, body, `{"id":11,"name":"Thail\u0026#228;ndisch","country":"Thailand","native_name":"Phasa Thai"}`)
+}
+
+func Test_Main_Genres(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/genres", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `{"id":28,"name":"History"},{"id":3,"name":"Horror"}`)
+	assert.Contains(t, body, `{"id":15,"name":"War"},{"id":10,"name":"Western"}`)
+}
+
+func Test_Main_References(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/formats":    `[{"code":"16:9","name":"Widescreen 16:9"},{"code":"4:3","name":"Fullscreen 4:3"}]`,
+		"/disk-types": `[{"code":"DVD","name":"DVD"},{"code":"BluRay","name":"Blu-ray Disc"}]`,
+		"/regions":    `{"code":"B","name":"Region B (Europe, Africa, Oceania)"},{"code":"C","name":"Region C (Central and South Asia)"}]`,
+	} {
+		response := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "https://localhost:4008"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusOK, response.Code, path)
+		assert.Contains(t, response.Body.String(), expected, path)
+	}
+}
+
+func Test_Main_GetPerson(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/person/470", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Equal(t, `{"id":470,"name":"Roger Moore"}`, body)
+
+	// missing person
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/person/999999", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Contains(t, response.Body.String(), `{"error":{"code":"not_found","message":"person 999999 not found"`)
+
+	// non-numeric id
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/person/roger", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `{"error":{"code":"bad_request","message":"Invalid id: roger"`)
+}
+
+func Test_Main_Actors(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/actors", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `{"id":141,"name":"Alan Rickman"}`)
+	assert.Contains(t, body, `{"id":137,"name":"Bruce Willis"}`)
+	assert.Contains(t, body, `{"id":254,"name":"Leonardo DiCaprio"}`)
+}
+
+func Test_Main_Directors(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/directors", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `{"id":11,"name":"David Fincher"}`)
+	assert.Contains(t, body, `{"id":331,"name":"Ben Affleck"}`)
+	assert.Contains(t, body, `{"id":417,"name":"John Carpenter"}`)
+}
+
+func Test_Main_Statistics(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/statistics", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `"ground_zero":"1999-08-01T00:13:37Z","last_update":"2014-01-01T17:11:36Z","count":912`)
+	assert.Contains(t, body, `"movie_types":[{"type":"DVD","disks":1238,"length":154253,"count":602},{"type":"BluRay","disks":493,"length":61691,"count":310}]`)
+	assert.Contains(t, body, `"actors":4696,"directors":578,"people_total":5244`)
+	assert.Contains(t, body, `"top5_actors":[{"id":483,"name":"Bud Spencer","count":26},{"id":338,"name":"Matt Damon","count":22},{"id":369,"name":"Desmond Llewelyn","count":21},{"id":76,"name":"Cate Blanchett","count":16},{"id":651,"name":"Morgan Freeman","count":16}]`)
+	assert.Contains(t, body, `"top5_directors":[{"id":493,"name":"Kenji Kamiyama","count":17},{"id":127,"name":"George Lucas","count":11},{"id":70,"name":"Peter Jackson","count":11},{"id":64,"name":"Steven Spielberg","count":11},{"id":121,"name":"Ridley Scott","count":9}]`)
+	assert.Contains(t, body, `"top5_actors_and_directors":[{"id":396,"name":"Clint Eastwood","count":20},{"id":189,"name":"Mel Gibson","count":14},{"id":220,"name":"George Clooney","count":12},{"id":211,"name":"Quentin Tarantino","count":12},{"id":331,"name":"Ben Affleck","count":11}]`)
+	assert.Contains(t, body, `"regions":[{"type":"0","count":12},{"type":"1","count":74},{"type":"2","count":514},{"type":"3","count":1},{"type":"B","count":311}]`)
+	assert.Contains(t, body, `"ratings":[{"type":"21","count":12},{"type":"18","count":132},{"type":"16","count":397},{"type":"12","count":304},{"type":"6","count":67}]`)
+	assert.Contains(t, body, `"scores":[{"type":"5","count":92},{"type":"4","count":214},{"type":"3","count":520},{"type":"2","count":81},{"type":"1","count":5}]`)
+	assert.Contains(t, body, `"dvd_movies":602,"bluray_movies":310,"dvd_disks":1238,"bluray_disks":493,"total_length":215944,"avg_length_per_movie":236,"avg_length_per_disk":124`)
+	assert.Contains(t, body, `avg_movies_per_day`)
+	assert.Contains(t, body, `new_movies_estimate`)
+}
+
+func Test_Main_StatisticsFiltered(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/statistics?query=actor&value=483", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `"ground_zero":"1999-08-01T00:13:37Z","last_update":"2014-01-01T17:11:36Z","count":26`)
+	assert.Contains(t, body, `"actors":25,"directors":11,"people_total":36`)
+	assert.Contains(t, body, `"top5_actors":[{"id":483,"name":"Bud Spencer","count":26},{"id":484,"name":"Terence Hill","count":10},`)
+	assert.Contains(t, body, `"top5_actors_and_directors":[]`)
+	assert.Contains(t, body, `"regions":[{"type":"2","count":26}]`)
+	assert.Contains(t, body, `"dvd_movies":26,"bluray_movies":0,"dvd_disks":26,"bluray_disks":0,"total_length":2570,"avg_length_per_movie":98,"avg_length_per_disk":98`)
+}
+
+func Test_Main_PersonPath(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/people/7/path/396", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"from":{"id":7,"name":"Brad Pitt"},"to":{"id":396,"name":"Clint Eastwood"},"degrees":2,"links":[`+
+		`{"from":{"id":7,"name":"Brad Pitt"},"movie":{"id":213,"title":"Ocean's Eleven","year":2001,"score":3,"rating":12},"to":{"id":338,"name":"Matt Damon"}},`+
+		`{"from":{"id":338,"name":"Matt Damon"},"movie":{"id":679,"title":"Invictus","year":2009,"score":3,"rating":12},"to":{"id":396,"name":"Clint Eastwood"}}]}`, response.Body.String())
+
+	for path, status := range map[string]int{
+		"/people/483/path/7":          http.StatusNotFound,
+		"/people/7/path/99999":        http.StatusNotFound,
+		"/people/abc/path/7":          http.StatusBadRequest,
+		"/people/7/path/abc":          http.StatusBadRequest,
+		"/person/99999/collaborators": http.StatusNotFound,
+		"/person/abc/collaborators":   http.StatusBadRequest,
+	} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, status, response.Code, path)
+	}
+}
+
+func Test_Main_Collaborators(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/person/484/collaborators", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `[{"id":483,"name":"Bud Spencer","count":10},{"id":486,"name":"Enzo Barboni","count":5},{"id":487,"name":"Sergio Corbucci","count":2},`)
+}
+
+func Test_Main_SimilarMovies(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/movie/213/similar?limit=1&weight_actor=0&weight_language=0", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `[{"movie":{"id":248,"title":"Ocean's Twelve","year":2004,"score":3,"rating":6},"similarity":6,"reasons":[`+
+		`{"kind":"genre","matches":["Comedy","Crime","Thriller"],"score":2},{"kind":"director","matches":["Steven Soderbergh"],"score":3},`+
+		`{"kind":"decade","matches":["2000s"],"score":1}]}]`, response.Body.String())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/213/similar?query=year&value=1998", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `[{"movie":{"id":97,"title":"Out Of Sight","year":1998,`)
+
+	for query, status := range map[string]int{
+		"/movie/99999/similar":                 http.StatusNotFound,
+		"/movie/abc/similar":                   http.StatusBadRequest,
+		"/movie/213/similar?limit=x":           http.StatusBadRequest,
+		"/movie/213/similar?weight_genre=-1":   http.StatusBadRequest,
+		"/movie/213/similar?weight_decade=abc": http.StatusBadRequest,
+	} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008"+query, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, status, response.Code, query)
+	}
+}
+
+func Test_Main_RandomMovies(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/movies/random?count=2&seed=7&query=genre&value=1&query=max_length&value=90&query=min_score&value=4", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"seed":7,"movies":[{"id":172,"title":"Ghost in the Shell","year":1995,"score":5,"rating":12},{"id":236,"title":"Noir - Vol.5","year":2001,"score":5,"rating":16}]}`, response.Body.String())
+
+	// a new seed is used every time unless given
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies/random?weight=score", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `{"seed":`)
+	assert.NotContains(t, response.Body.String(), `{"seed":7,`)
+
+	for query, message := range map[string]string{
+		"count=0":      "Invalid count: 0",
+		"seed=abc":     "Invalid seed: abc",
+		"weight=title": "Invalid weight: title, must be one of: score, recent",
+	} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008/movies/random?"+query, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusBadRequest, response.Code, query)
+		assert.Contains(t, response.Body.String(), `"message":"`+message+`"`, query)
+	}
+}
+
+func Test_Main_RecentMovies(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/movies/recent?limit=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `[{"movie":{"id":914,"title":"Argo","year":2012,"score":5,"rating":12},"change":"added","created_at":"2014-01-01T17:11:36Z","updated_at":"2014-01-01T17:11:36Z"},`+
+		`{"movie":{"id":913,"title":"The Wolverine","year":2013,"score":3,"rating":12},"change":"added","created_at":"2013-12-26T22:43:16Z","updated_at":"2013-12-26T22:43:16Z"}]`, response.Body.String())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movies?since=2013-12-20&sort=created_at&by=asc", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `[{"id":912,"title":"This Is the End","year":2013,"score":3,"rating":16},{"id":913,"title":"The Wolverine","year":2013,"score":3,"rating":12},{"id":914,"title":"Argo","year":2012,"score":5,"rating":12}]`, response.Body.String())
+
+	for query, message := range map[string]string{
+		"/movies/recent?limit=x":      "Invalid limit: x",
+		"/movies?since=last+week":     "Invalid since: last week, must be a date or RFC 3339 timestamp",
+		"/movies/recent?until=2014.1": "Invalid until: 2014.1, must be a date or RFC 3339 timestamp",
+	} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008"+query, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusBadRequest, response.Code, query)
+		assert.Contains(t, response.Body.String(), `"message":"`+message+`"`, query)
+	}
+}
+
+func Test_Main_Feeds(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/feed.atom?limit=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.Equal(t, "Wed, 01 Jan 2014 17:11:36 GMT", response.Header().Get("Last-Modified"))
+	etag := response.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	var atom atomFeed
+	if err := xml.Unmarshal(response.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "tag:jamesclonk.io,2014:feed.atom?limit=2", atom.Id)
+	assert.Equal(t, "2014-01-01T17:11:36Z", atom.Updated)
+	assert.Equal(t, 2, len(atom.Entries))
+	assert.Equal(t, "tag:jamesclonk.io,2014:movie/914", atom.Entries[0].Id)
+	assert.Equal(t, []atomLink{{Rel: "alternate", Href: "http://localhost:4008/movie/914"}}, atom.Entries[0].Links)
+	assert.Equal(t, "Argo (2012)", atom.Entries[0].Title)
+	assert.Equal(t, "2014-01-01T17:11:36Z", atom.Entries[0].Published)
+	assert.Equal(t, atomText{"html", `<p><img src="http://localhost:4008/movie/914/picture?size=medium" alt="Argo (2012)"/></p><p>Acting under the cover of a Hollywood producer scouting a location for a science fiction film, a CIA agent launches a dangerous operation to rescue six Americans in Tehran during the U.S. hostage crisis in Iran in 1980.</p>`}, atom.Entries[0].Content)
+	assert.Equal(t, "tag:jamesclonk.io,2014:movie/913", atom.Entries[1].Id)
+
+	// ids stay the same, no matter through which host or proxy the feed is fetched
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://movies.example.com/feed.atom?limit=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	m.ServeHTTP(response, req)
+	var proxied atomFeed
+	if err := xml.Unmarshal(response.Body.Bytes(), &proxied); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, atom.Id, proxied.Id)
+	assert.Equal(t, atom.Entries[0].Id, proxied.Entries[0].Id)
+	assert.Equal(t, "https://movies.example.com/movie/914", proxied.Entries[0].Links[0].Href)
+
+	// limits are capped
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/feed.atom?limit=1000", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	var capped atomFeed
+	if err := xml.Unmarshal(response.Body.Bytes(), &capped); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, maxFeedSize, len(capped.Entries))
+
+	// conditional requests
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/feed.atom?limit=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Empty(t, response.Body.String())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/feed.atom?limit=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("If-Modified-Since", "Wed, 01 Jan 2014 17:11:36 GMT")
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotModified, response.Code)
+
+	// rss, filtered like movie listings and with a configured public url
+	os.Setenv("JCIO_PUBLIC_URL", "https://movies.example.com/")
+	defer os.Unsetenv("JCIO_PUBLIC_URL")
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/feed.rss?query=year&value=1974", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/rss+xml; charset=utf-8", response.Header().Get("Content-Type"))
+
+	var rss rssFeed
+	if err := xml.Unmarshal(response.Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "2.0", rss.Version)
+	assert.Equal(t, "https://movies.example.com/movies", rss.Channel.Link)
+	assert.True(t, len(rss.Channel.Items) > 1)
+	assert.Equal(t, "Zwei Missionare (1974)", rss.Channel.Items[0].Title)
+	assert.Equal(t, "https://movies.example.com/movie/347", rss.Channel.Items[0].Link)
+	assert.Equal(t, rssGuid{false, "tag:jamesclonk.io,2014:movie/347"}, rss.Channel.Items[0].Guid)
+	assert.Equal(t, "Mon, 17 Jan 2005 07:33:01 +0000", rss.Channel.Items[0].PubDate)
+	for _, item := range rss.Channel.Items {
+		assert.True(t, strings.HasSuffix(item.Title, " (1974)"), item.Title)
+	}
+
+	// titles are plain text
+	assert.Equal(t, "James Bond 007: The Man with the golden Gun", plainText("James Bond 007:<br/>The Man with the golden Gun"))
+	assert.Equal(t, "Herr der Ringe - Die Gefährten", plainText("Herr der Ringe - Die Gef&#228;hrten"))
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/feed.rss?limit=0", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `"message":"Invalid limit: 0"`)
+}
+
+func Test_Main_Timeline(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/statistics/timeline", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `{"bucket":"month","points":[{"period":"1999-08","start":"1999-08-01T00:00:00Z","movies":6,"disks":8,"length":742,"total_movies":6,"total_disks":8,"total_length":742},`)
+	assert.Contains(t, body, `{"period":"2014-01","start":"2014-01-01T00:00:00Z","movies":1,"disks":1,"length":129,"total_movies":912,"total_disks":1731,"total_length":215944}]}`)
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/statistics/timeline?bucket=year", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `{"period":"2000","start":"2000-01-01T00:00:00Z","movies":63,"disks":82,"length":9054,"total_movies":90,"total_disks":131,"total_length":13858}`)
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/statistics/timeline?bucket=decade", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `"message":"Invalid bucket: decade, must be one of: week, month, year"`)
+}
+
+func Test_Main_Breakdown(t *testing.T) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/statistics/breakdown?by=language&top=2", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"by":"language","sort":"count","order":"desc","groups":[{"key":"2","name":"Englisch","count":811,"avg_score":3.33,"avg_rating":14.38,"total_length":204236},{"key":"1","name":"Deutsch","count":607,"avg_score":3.2,"avg_rating":13.79,"total_length":99934}]}`, response.Body.String())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/statistics/breakdown?by=decade&sort=name&order=asc&top=0", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"groups":[{"key":"1960","name":"1960s","count":12,`)
+	assert.Contains(t, response.Body.String(), `{"key":"2010","name":"2010s","count":185,"avg_score":3.17,"avg_rating":14.14,"total_length":38332}]}`)
+
+	for query, message := range map[string]string{
+		"":                    "Invalid breakdown: , must be one of: genre, language, decade, director, actor, format",
+		"by=studio":           "Invalid breakdown: studio, must be one of: genre, language, decade, director, actor, format",
+		"by=genre&sort=title": "Invalid sort: title, must be one of: count, score, rating, length, name",
+		"by=genre&order=up":   "Invalid order: up, must be asc or desc",
+		"by=genre&top=-1":     "Invalid top: -1",
+	} {
+		response = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "https://localhost:4008/statistics/breakdown?"+query, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		m.ServeHTTP(response, req)
+		assert.Equal(t, http.StatusBadRequest, response.Code, query)
+		assert.Contains(t, response.Body.String(), `"message":"`+message+`"`, query)
+	}
+}
+
+func Test_Main_ExternalIds(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	// add a movie with external ids
+	newMovie := &moviedb.Movie{
+		Title: "Terminator",
+		Year:  1984,
+		Disks: 1,
+		Type:  "DVD",
+		Directors: []*moviedb.Person{
+			&moviedb.Person{Name: "James Cameron", ExternalIds: []*moviedb.ExternalId{
+				&moviedb.ExternalId{Provider: "imdb", ExternalId: "nm0000116"},
+			}},
+		},
+		ExternalIds: []*moviedb.ExternalId{
+			&moviedb.ExternalId{Provider: "imdb", ExternalId: "tt0088247"},
+			&moviedb.ExternalId{Provider: "tmdb", ExternalId: "218"},
+		},
+	}
+	json, err := json.Marshal(newMovie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "https://localhost:4008/movie", bytes.NewBuffer(json))
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// lookup by external id
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/by-external/imdb/tt0088247", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `{"id":915,"title":"Terminator"`)
+	assert.Contains(t, body, `"external_ids":[{"provider":"imdb","id":"tt0088247"},{"provider":"tmdb","id":"218"}]`)
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/person/by-external/imdb/nm0000116", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"name":"James Cameron","external_ids":[{"provider":"imdb","id":"nm0000116"}]`)
+
+	// invalid external ids
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/by-external/imdb/nm0000116", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `"invalid imdb id for movie: nm0000116"`)
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/by-external/netflix/12345", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `"unknown external id provider: netflix"`)
+}
+
+func Test_Main_MovieEnrichment(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	// proposals need auth, as they call the metadata provider
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/movie/914/enrichment", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	// without a metadata provider
+	response = httptest.NewRecorder()
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Contains(t, response.Body.String(), `"No metadata provider configured"`)
+
+	// with a metadata provider
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/search":
+			fmt.Fprint(w, `[{"id":"argo","title":"Argo","year":2012}]`)
+		case "/movie/argo":
+			fmt.Fprint(w, `{"id":"argo","title":"Argo","year":2012,"length":120,"genres":["Drama","Political"],"actors":["Ben Affleck"]}`)
+		case "/movie/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer stub.Close()
+
+	provider = enrichment.NewHTTPProvider(stub.URL)
+	defer func() { provider = nil }()
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/enrichment", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"movie_id":914,"provider":"http","source_id":"argo","changes":[{"field":"length","current":129,"proposed":120},{"field":"genres","current":["Biography","Drama","History","Thriller"],"proposed":["Political"]}]}`, response.Body.String())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/enrichment?source_id=unknown", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	// a failing provider is a bad gateway, not an internal error
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/enrichment?source_id=broken", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadGateway, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"bad_gateway","message":"metadata provider unavailable"`)
+
+	// apply genres only
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/movie/914/enrichment", strings.NewReader(`{"fields":["genres"]}`))
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/movie/914/enrichment", strings.NewReader(`{"fields":["genres"]}`))
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"Result":"OK"}`, response.Body.String())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	body := response.Body.String()
+	assert.Contains(t, body, `"length":129`)
+	assert.Contains(t, body, `{"id":6,"name":"Drama"},{"id":28,"name":"History"},{"id":34,"name":"Political"},{"id":4,"name":"Thriller"}`)
+}
+
+func newPictureUpload(t *testing.T, url string, data []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("picture", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func newTestPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_Main_MoviePicture(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	// legacy pictures are not in the blobstore
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "https://localhost:4008/movie/914/picture", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Contains(t, response.Body.String(), `"Picture not found"`)
+
+	// a blob named like a legacy picture, it must survive replacing the picture
+	cover := newTestPNG(t, 40, 60)
+	if err := blobs.Put("argo.jpg", cover, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	defer blobs.Delete("argo.jpg")
+
+	// upload needs auth
+	response = httptest.NewRecorder()
+	req = newPictureUpload(t, "https://localhost:4008/movie/914/picture", cover)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	// only images are accepted
+	response = httptest.NewRecorder()
+	req = newPictureUpload(t, "https://localhost:4008/movie/914/picture", []byte("not a picture"))
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, response.Code)
+
+	// pictures too large to decode are rejected by their header
+	huge := newTestPNG(t, 1, 1)
+	binary.BigEndian.PutUint32(huge[16:], 20000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	response = httptest.NewRecorder()
+	req = newPictureUpload(t, "https://localhost:4008/movie/914/picture", huge)
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+	assert.Contains(t, response.Body.String(), `"Invalid picture: picture is too large: 20000x1, at most 8000x8000 are allowed"`)
+
+	response = httptest.NewRecorder()
+	req = newPictureUpload(t, "https://localhost:4008/movie/914/picture", cover)
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	assert.Contains(t, response.Body.String(), `"picture":"movie_914_`)
+
+	// picture field is set
+	movie, err := mdb.GetMovie("914")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(movie.Picture, "movie_914_"))
+	assert.True(t, strings.HasSuffix(movie.Picture, ".png"))
+	_, err = blobs.Get("argo.jpg")
+	assert.NoError(t, err)
+
+	// serve picture
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/picture", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "image/png", response.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=86400", response.Header().Get("Cache-Control"))
+	assert.Equal(t, cover, response.Body.Bytes())
+
+	etag := response.Header().Get("ETag")
+	assert.Equal(t, fmt.Sprintf("%q", storage.Hash(cover)), etag)
+
+	// conditional request
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/picture", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Equal(t, 0, response.Body.Len())
+
+	// thumbnails
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/picture?size=small", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "image/png", response.Header().Get("Content-Type"))
+
+	thumb, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, image.Rect(0, 0, 40, 60), thumb.Bounds())
+
+	// sizes changed, thumbnail gets generated lazily
+	sizes := thumbnailSizes
+	defer func() { thumbnailSizes = sizes }()
+	thumbnailSizes = []thumbnail.Size{{Name: "small", Width: 20}}
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/picture?size=small", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	thumb, err = png.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, image.Rect(0, 0, 20, 30), thumb.Bounds())
+
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "https://localhost:4008/movie/914/picture?size=huge", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), `"Unknown picture size: huge"`)
+
+	// legacy movies which would not pass validation anymore still get their picture
+	if _, err := db.Database.Exec(`update movie_movie set year = 1700 where id = 9`); err != nil {
+		t.Fatal(err)
+	}
+	response = httptest.NewRecorder()
+	req = newPictureUpload(t, "https://localhost:4008/movie/9/picture", cover)
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	movie, err = mdb.GetMovie("9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1700, movie.Year)
+	assert.True(t, strings.HasPrefix(movie.Picture, "movie_9_"))
+
+	// deleting the movie removes its picture and thumbnails
+	for _, key := range append(thumbnailKeys(movie.Picture), movie.Picture) {
+		_, err = blobs.Get(key)
+		assert.NoError(t, err, key)
+	}
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "https://localhost:4008/movie/9", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	for _, key := range append(thumbnailKeys(movie.Picture), movie.Picture) {
+		_, err = blobs.Get(key)
+		assert.Equal(t, storage.ErrNotFound, err, key)
+	}
+
+	// so does a batch deleting it
+	movie, err = mdb.GetMovie("914")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "https://localhost:4008/batch", strings.NewReader(`{"operations":[{"op":"delete","id":914}]}`))
+	if err != nil {
+		t.Error(err)
+	}
+	req.SetBasicAuth(testUser, testPassword)
+
+	m.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	_, err = blobs.Get(movie.Picture)
+	assert.Equal(t, storage.ErrNotFound, err)
+	_, err = blobs.Get("argo.jpg")
+	assert.NoError(t, err)
 }
@@ -0,0 +1,9 @@
+-- movie_external_id
+CREATE TABLE IF NOT EXISTS movie_external_id (
+	entity_type			TEXT NOT NULL,
+	entity_id			INTEGER NOT NULL,
+	provider			TEXT NOT NULL,
+	external_id			TEXT NOT NULL,
+	UNIQUE(entity_type, provider, external_id),
+	UNIQUE(entity_type, entity_id, provider)
+);
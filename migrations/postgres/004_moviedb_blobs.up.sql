@@ -0,0 +1,8 @@
+-- movie_blob
+CREATE TABLE IF NOT EXISTS movie_blob (
+    key             TEXT PRIMARY KEY,
+    content_type    TEXT NOT NULL,
+    hash            TEXT NOT NULL,
+    data            BYTEA NOT NULL,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
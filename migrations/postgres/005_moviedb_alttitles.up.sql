@@ -0,0 +1,18 @@
+-- movie_alttitle
+CREATE TABLE IF NOT EXISTS movie_alttitle (
+	id					SERIAL PRIMARY KEY,
+	movie_id			INTEGER NOT NULL,
+	title				TEXT NOT NULL,
+	language_id			INTEGER,
+	type				TEXT NOT NULL DEFAULT 'localized' CHECK (type IN ('original', 'localized', 'working', 'short')),
+	FOREIGN KEY(movie_id) REFERENCES movie_movie(id) ON DELETE CASCADE,
+	FOREIGN KEY(language_id) REFERENCES movie_language(id) ON DELETE SET NULL
+);
+
+-- move existing alttitles, their language and type is unknown
+INSERT INTO movie_alttitle (movie_id, title, type)
+	SELECT id, alttitle, 'localized' FROM movie_movie
+	WHERE alttitle IS NOT NULL AND alttitle <> ''
+	ORDER BY id;
+
+ALTER TABLE movie_movie DROP COLUMN alttitle;
@@ -0,0 +1,12 @@
+ALTER TABLE movie_movie DROP CONSTRAINT movie_movie_format_fkey;
+ALTER TABLE movie_movie DROP CONSTRAINT movie_movie_disk_type_fkey;
+ALTER TABLE movie_movie DROP CONSTRAINT movie_movie_disk_region_fkey;
+
+-- movie_region
+DROP TABLE movie_region;
+
+-- movie_disk_type
+DROP TABLE movie_disk_type;
+
+-- movie_format
+DROP TABLE movie_format;
@@ -0,0 +1,70 @@
+-- movie_format
+CREATE TABLE IF NOT EXISTS movie_format (
+	code				TEXT PRIMARY KEY,
+	name				TEXT NOT NULL,
+	position			INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO movie_format (code, name, position) VALUES
+	('16:9', 'Widescreen 16:9', 1),
+	('4:3', 'Fullscreen 4:3', 2);
+
+-- movie_disk_type
+CREATE TABLE IF NOT EXISTS movie_disk_type (
+	code				TEXT PRIMARY KEY,
+	name				TEXT NOT NULL,
+	position			INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO movie_disk_type (code, name, position) VALUES
+	('DVD', 'DVD', 1),
+	('BluRay', 'Blu-ray Disc', 2);
+
+-- movie_region
+CREATE TABLE IF NOT EXISTS movie_region (
+	code				TEXT PRIMARY KEY,
+	name				TEXT NOT NULL,
+	position			INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO movie_region (code, name, position) VALUES
+	('0', 'Region free', 1),
+	('1', 'Region 1 (USA, Canada)', 2),
+	('2', 'Region 2 (Europe, Japan, Middle East)', 3),
+	('3', 'Region 3 (Southeast Asia)', 4),
+	('4', 'Region 4 (Latin America, Oceania)', 5),
+	('5', 'Region 5 (Africa, Russia, South Asia)', 6),
+	('6', 'Region 6 (China)', 7),
+	('7', 'Region 7 (reserved)', 8),
+	('8', 'Region 8 (international venues)', 9),
+	('A', 'Region A (Americas, East Asia)', 10),
+	('B', 'Region B (Europe, Africa, Oceania)', 11),
+	('C', 'Region C (Central and South Asia)', 12);
+
+-- normalize existing free text values
+UPDATE movie_movie SET format = NULL WHERE trim(format) = '';
+UPDATE movie_movie SET format = '16:9' WHERE lower(replace(replace(format, ' ', ''), '/', ':')) IN ('16:9', '1.78:1', 'widescreen');
+UPDATE movie_movie SET format = '4:3' WHERE lower(replace(replace(format, ' ', ''), '/', ':')) IN ('4:3', '1.33:1', 'fullscreen');
+
+UPDATE movie_movie SET disk_type = NULL WHERE trim(disk_type) = '';
+UPDATE movie_movie SET disk_type = 'DVD' WHERE lower(replace(disk_type, ' ', '')) IN ('dvd', 'dvd5', 'dvd9');
+UPDATE movie_movie SET disk_type = 'BluRay' WHERE lower(replace(replace(disk_type, ' ', ''), '-', '')) IN ('bluray', 'bluraydisc', 'bd');
+
+UPDATE movie_movie SET disk_region = NULL WHERE trim(disk_region) = '';
+UPDATE movie_movie SET disk_region = upper(trim(disk_region));
+UPDATE movie_movie SET disk_region = '0' WHERE disk_region IN ('FREE', 'REGION FREE', 'ALL', 'RC0');
+
+-- anything still unknown is kept as its own code, so no data is lost
+INSERT INTO movie_format (code, name, position)
+	SELECT DISTINCT format, format, 99 FROM movie_movie
+	WHERE format IS NOT NULL AND format NOT IN (SELECT code FROM movie_format);
+INSERT INTO movie_disk_type (code, name, position)
+	SELECT DISTINCT disk_type, disk_type, 99 FROM movie_movie
+	WHERE disk_type IS NOT NULL AND disk_type NOT IN (SELECT code FROM movie_disk_type);
+INSERT INTO movie_region (code, name, position)
+	SELECT DISTINCT disk_region, disk_region, 99 FROM movie_movie
+	WHERE disk_region IS NOT NULL AND disk_region NOT IN (SELECT code FROM movie_region);
+
+ALTER TABLE movie_movie ADD CONSTRAINT movie_movie_format_fkey FOREIGN KEY (format) REFERENCES movie_format(code);
+ALTER TABLE movie_movie ADD CONSTRAINT movie_movie_disk_type_fkey FOREIGN KEY (disk_type) REFERENCES movie_disk_type(code);
+ALTER TABLE movie_movie ADD CONSTRAINT movie_movie_disk_region_fkey FOREIGN KEY (disk_region) REFERENCES movie_region(code);
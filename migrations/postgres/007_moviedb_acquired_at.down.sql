@@ -0,0 +1 @@
+ALTER TABLE movie_movie DROP COLUMN acquired_at;
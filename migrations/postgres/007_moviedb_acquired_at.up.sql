@@ -0,0 +1,6 @@
+ALTER TABLE movie_movie ADD COLUMN acquired_at TIMESTAMP;
+
+-- there is no recorded date per movie yet, so spread them by id between ground zero and the last update
+UPDATE movie_movie SET acquired_at = (SELECT date FROM movie_dbdate WHERE id = 1)
+	+ ((id - (SELECT min(id) FROM movie_movie))::float / GREATEST(1, (SELECT max(id) - min(id) FROM movie_movie)))
+	* ((SELECT date FROM movie_dbdate WHERE id = 2) - (SELECT date FROM movie_dbdate WHERE id = 1));
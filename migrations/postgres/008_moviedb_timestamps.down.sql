@@ -0,0 +1,2 @@
+ALTER TABLE movie_movie DROP COLUMN updated_at;
+ALTER TABLE movie_movie DROP COLUMN created_at;
@@ -0,0 +1,5 @@
+ALTER TABLE movie_movie ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE movie_movie ADD COLUMN updated_at TIMESTAMP;
+
+-- existing movies were created when they were acquired, and not changed since as far as we know
+UPDATE movie_movie SET created_at = acquired_at, updated_at = acquired_at;
@@ -0,0 +1,2 @@
+-- movie_external_id
+DROP TABLE `movie_external_id`;
@@ -0,0 +1,9 @@
+-- movie_external_id
+CREATE TABLE IF NOT EXISTS `movie_external_id` (
+	`entity_type`		text NOT NULL,
+	`entity_id`			integer NOT NULL,
+	`provider`			text NOT NULL,
+	`external_id`		text NOT NULL,
+	UNIQUE(`entity_type`, `provider`, `external_id`),
+	UNIQUE(`entity_type`, `entity_id`, `provider`)
+);
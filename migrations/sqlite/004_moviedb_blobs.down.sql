@@ -0,0 +1,2 @@
+-- movie_blob
+DROP TABLE `movie_blob`;
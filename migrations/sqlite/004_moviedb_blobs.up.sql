@@ -0,0 +1,8 @@
+-- movie_blob
+CREATE TABLE IF NOT EXISTS `movie_blob` (
+    `key`           text NOT NULL PRIMARY KEY,
+    `content_type`  text NOT NULL,
+    `hash`          text NOT NULL,
+    `data`          blob NOT NULL,
+    `created_at`    datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
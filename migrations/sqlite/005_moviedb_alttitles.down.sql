@@ -0,0 +1,10 @@
+ALTER TABLE `movie_movie` ADD COLUMN `alttitle` text;
+
+UPDATE `movie_movie` SET `alttitle` = (
+	SELECT ma.`title` FROM `movie_alttitle` ma
+	WHERE ma.`movie_id` = `movie_movie`.`id`
+	ORDER BY ma.`id` LIMIT 1
+);
+
+-- movie_alttitle
+DROP TABLE `movie_alttitle`;
@@ -0,0 +1,39 @@
+-- movie_alttitle
+CREATE TABLE IF NOT EXISTS `movie_alttitle` (
+	`id`				integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+	`movie_id`			integer NOT NULL,
+	`title`				text NOT NULL,
+	`language_id`		integer,
+	`type`				text NOT NULL DEFAULT 'localized' CHECK (`type` IN ('original', 'localized', 'working', 'short')),
+	FOREIGN KEY(`movie_id`) REFERENCES [movie_movie] ( [id] ) ON DELETE CASCADE,
+	FOREIGN KEY(`language_id`) REFERENCES [movie_language] ( [id] ) ON DELETE SET NULL
+);
+
+-- move existing alttitles, their language and type is unknown
+INSERT INTO `movie_alttitle` (`movie_id`, `title`, `type`)
+	SELECT `id`, `alttitle`, 'localized' FROM `movie_movie`
+	WHERE `alttitle` IS NOT NULL AND `alttitle` <> ''
+	ORDER BY `id`;
+
+-- sqlite only drops columns since 3.35, so movie_movie is rebuilt without alttitle
+CREATE TABLE `movie_movie_new` (
+	`id`				integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+	`title`				text NOT NULL,
+	`year`				integer,
+	`description`		text,
+	`format`			text,
+	`length`			integer,
+	`disk_region`		text,
+	`rating`			integer,
+	`disks`				integer,
+	`score`				integer,
+	`picture`			text,
+	`disk_type`			text
+);
+
+INSERT INTO `movie_movie_new` (`id`, `title`, `year`, `description`, `format`, `length`, `disk_region`, `rating`, `disks`, `score`, `picture`, `disk_type`)
+	SELECT `id`, `title`, `year`, `description`, `format`, `length`, `disk_region`, `rating`, `disks`, `score`, `picture`, `disk_type`
+	FROM `movie_movie`;
+
+DROP TABLE `movie_movie`;
+ALTER TABLE `movie_movie_new` RENAME TO `movie_movie`;
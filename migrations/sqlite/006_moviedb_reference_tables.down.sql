@@ -0,0 +1,8 @@
+-- movie_region
+DROP TABLE `movie_region`;
+
+-- movie_disk_type
+DROP TABLE `movie_disk_type`;
+
+-- movie_format
+DROP TABLE `movie_format`;
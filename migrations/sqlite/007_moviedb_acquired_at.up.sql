@@ -0,0 +1,6 @@
+ALTER TABLE `movie_movie` ADD COLUMN `acquired_at` datetime;
+
+-- there is no recorded date per movie yet, so spread them by id between ground zero and the last update
+UPDATE `movie_movie` SET `acquired_at` = datetime(julianday((SELECT `date` FROM `movie_dbdate` WHERE `id` = 1))
+	+ ((`id` - (SELECT min(`id`) FROM `movie_movie`)) * 1.0 / max(1, (SELECT max(`id`) - min(`id`) FROM `movie_movie`)))
+	* (julianday((SELECT `date` FROM `movie_dbdate` WHERE `id` = 2)) - julianday((SELECT `date` FROM `movie_dbdate` WHERE `id` = 1))));
@@ -0,0 +1,23 @@
+-- sqlite only drops columns since 3.35, so movie_movie is rebuilt without created_at and updated_at
+CREATE TABLE `movie_movie_new` (
+	`id`				integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+	`title`				text NOT NULL,
+	`year`				integer,
+	`description`		text,
+	`format`			text,
+	`length`			integer,
+	`disk_region`		text,
+	`rating`			integer,
+	`disks`				integer,
+	`score`				integer,
+	`picture`			text,
+	`disk_type`			text,
+	`acquired_at`		datetime
+);
+
+INSERT INTO `movie_movie_new` (`id`, `title`, `year`, `description`, `format`, `length`, `disk_region`, `rating`, `disks`, `score`, `picture`, `disk_type`, `acquired_at`)
+	SELECT `id`, `title`, `year`, `description`, `format`, `length`, `disk_region`, `rating`, `disks`, `score`, `picture`, `disk_type`, `acquired_at`
+	FROM `movie_movie`;
+
+DROP TABLE `movie_movie`;
+ALTER TABLE `movie_movie_new` RENAME TO `movie_movie`;
@@ -0,0 +1,5 @@
+ALTER TABLE `movie_movie` ADD COLUMN `created_at` datetime;
+ALTER TABLE `movie_movie` ADD COLUMN `updated_at` datetime;
+
+-- existing movies were created when they were acquired, and not changed since as far as we know
+UPDATE `movie_movie` SET `created_at` = `acquired_at`, `updated_at` = `acquired_at`;
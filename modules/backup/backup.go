@@ -0,0 +1,258 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+)
+
+// FormatVersion changes whenever the layout of backups changes, not with the database schema
+const FormatVersion = 1
+
+const manifestFile = "manifest.json"
+
+type Manifest struct {
+	FormatVersion int              `json:"format_version"`
+	SchemaVersion int              `json:"schema_version"`
+	DatabaseType  string           `json:"database_type"` // where the backup was taken, restores work with any type
+	CreatedAt     time.Time        `json:"created_at"`
+	Tables        []*TableManifest `json:"tables"`
+}
+
+type TableManifest struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup writes a zip with an NDJSON file per table and a manifest, all read within one transaction
+func Backup(adapter *database.Adapter, w io.Writer) (*Manifest, error) {
+	snapshot, err := NewSnapshot(adapter)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+	return snapshot.Write(w)
+}
+
+// beginSnapshot starts a read only transaction whose statements all see the same data. Postgres only sees what was
+// committed before each statement by default, sqlite sees the same data within any transaction already.
+func beginSnapshot(adapter *database.Adapter) (*sql.Tx, error) {
+	if adapter.Type == "postgres" {
+		return adapter.Database.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	}
+	return adapter.Database.Begin()
+}
+
+// Snapshot is the transaction a backup is read in. Opening it first lets callers report a database
+// that can't be backed up before they start sending anything.
+type Snapshot struct {
+	tx       *sql.Tx
+	manifest *Manifest
+}
+
+func NewSnapshot(adapter *database.Adapter) (*Snapshot, error) {
+	tx, err := beginSnapshot(adapter)
+	if err != nil {
+		return nil, err
+	}
+	version, err := SchemaVersion(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &Snapshot{
+		tx: tx,
+		manifest: &Manifest{
+			FormatVersion: FormatVersion,
+			SchemaVersion: version,
+			DatabaseType:  adapter.Type,
+			CreatedAt:     time.Now().UTC().Truncate(time.Second),
+			Tables:        []*TableManifest{},
+		},
+	}, nil
+}
+
+// Write writes the zip, the manifest comes last. If anything fails on the way, the zip is left without
+// the manifest and without its central directory, so it can be neither opened nor restored.
+func (s *Snapshot) Write(w io.Writer) (*Manifest, error) {
+	archive := zip.NewWriter(w)
+	for _, table := range Tables {
+		file := table.Name + ".ndjson"
+		out, err := archive.Create(file)
+		if err != nil {
+			return nil, err
+		}
+		rows, sum, err := table.dumpWithChecksum(s.tx, out)
+		if err != nil {
+			return nil, err
+		}
+		s.manifest.Tables = append(s.manifest.Tables, &TableManifest{
+			Name:   table.Name,
+			File:   file,
+			Rows:   rows,
+			SHA256: sum,
+		})
+	}
+
+	out, err := archive.Create(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.manifest); err != nil {
+		return nil, err
+	}
+	return s.manifest, archive.Close()
+}
+
+// Close ends the transaction, a snapshot only reads
+func (s *Snapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+// Restore loads a backup into an empty database, which has to be migrated to the same schema version already.
+// All checksums are verified before anything is loaded, the row counts afterwards. Nothing is kept if any of it fails.
+func Restore(adapter *database.Adapter, r io.ReaderAt, size int64) (*Manifest, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	manifest, err := readManifest(files[manifestFile])
+	if err != nil {
+		return nil, err
+	}
+	tables := map[string]*TableManifest{}
+	for _, table := range manifest.Tables {
+		tables[table.Name] = table
+	}
+	for _, table := range Tables {
+		t, ok := tables[table.Name]
+		if !ok {
+			return nil, fmt.Errorf("backup has no table %s", table.Name)
+		}
+		if files[t.File] == nil {
+			return nil, fmt.Errorf("backup has no file %s", t.File)
+		}
+		if err := verifyChecksum(files[t.File], t.SHA256); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := adapter.Database.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	version, err := SchemaVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+	if version != manifest.SchemaVersion {
+		return nil, fmt.Errorf("backup has schema version %d, database has %d", manifest.SchemaVersion, version)
+	}
+	if err := checkEmpty(tx); err != nil {
+		return nil, err
+	}
+
+	for _, table := range Tables {
+		if table.Seeded {
+			if err := table.Clear(tx); err != nil {
+				return nil, err
+			}
+		}
+		in, err := files[tables[table.Name].File].Open()
+		if err != nil {
+			return nil, err
+		}
+		_, err = table.Load(tx, in)
+		in.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := ResetSequences(tx, adapter.Type); err != nil {
+		return nil, err
+	}
+
+	for _, table := range Tables {
+		count, err := table.Count(tx)
+		if err != nil {
+			return nil, err
+		}
+		if expected := tables[table.Name].Rows; count != expected {
+			return nil, fmt.Errorf("%s has %d rows after restore, backup has %d", table.Name, count, expected)
+		}
+	}
+	return manifest, tx.Commit()
+}
+
+func readManifest(file *zip.File) (*Manifest, error) {
+	if file == nil {
+		return nil, fmt.Errorf("backup has no %s", manifestFile)
+	}
+	in, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(in).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", manifestFile, err)
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("backup has format version %d, only %d is supported", manifest.FormatVersion, FormatVersion)
+	}
+	return &manifest, nil
+}
+
+func verifyChecksum(file *zip.File, expected string) error {
+	in, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, in); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum of %s does not match, the backup is corrupt", file.Name)
+	}
+	return nil
+}
+
+// checkEmpty makes sure there is nothing but the rows created by migrations
+func checkEmpty(db Queryer) error {
+	for _, table := range Tables {
+		if table.Seeded {
+			continue
+		}
+		count, err := table.Count(db)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("database is not empty, %s has %d rows", table.Name, count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	movieTestDbFile     string = "../../_fixtures/test.db"
+	movieTestDbFileCopy string = "../../_fixtures/test_backup.db"
+	emptyTestDbFile     string = "../../_fixtures/test_restore.db"
+)
+
+func openDatabase(file string) *database.Adapter {
+	os.Setenv("JCIO_DATABASE_TYPE", "sqlite")
+	os.Setenv("JCIO_DATABASE_URI", fmt.Sprintf("sqlite3://%s", file))
+	adapter := database.NewAdapter()
+	migration.RunMigrations("../../migrations", adapter)
+	return adapter
+}
+
+func resetDatabases() {
+	in, err := os.Open(movieTestDbFile)
+	if err != nil {
+		panic(err)
+	}
+	defer in.Close()
+	out, err := os.Create(movieTestDbFileCopy)
+	if err != nil {
+		panic(err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		panic(err)
+	}
+
+	os.Remove(emptyTestDbFile)
+}
+
+func Test_Backup_Restore(t *testing.T) {
+	resetDatabases()
+	source := openDatabase(movieTestDbFileCopy)
+	defer source.Database.Close()
+
+	var buf bytes.Buffer
+	manifest, err := Backup(source, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, FormatVersion, manifest.FormatVersion)
+	assert.Equal(t, "sqlite", manifest.DatabaseType)
+	assert.True(t, manifest.SchemaVersion > 2)
+	if assert.Equal(t, len(Tables), len(manifest.Tables)) {
+		assert.Equal(t, "movie_movie", manifest.Tables[7].Name)
+		assert.Equal(t, 912, manifest.Tables[7].Rows)
+	}
+
+	// an empty database gets the same rows back
+	target := openDatabase(emptyTestDbFile)
+	defer target.Database.Close()
+
+	if _, err := Restore(target, bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	restored, err := Backup(target, &again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, table := range restored.Tables {
+		assert.Equal(t, manifest.Tables[i].Rows, table.Rows, table.Name)
+		assert.Equal(t, manifest.Tables[i].SHA256, table.SHA256, table.Name)
+	}
+
+	// new movies continue after the restored ids
+	if _, err := target.Database.Exec(`insert into movie_movie (title, year) values ('Backup', 2015)`); err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	if err := target.Database.QueryRow(`select max(id) from movie_movie`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 915, id)
+
+	// only empty databases can be restored into
+	_, err = Restore(target, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.EqualError(t, err, "database is not empty, movie_dbdate has 2 rows")
+}
+
+func Test_Backup_Corrupt(t *testing.T) {
+	resetDatabases()
+	target := openDatabase(emptyTestDbFile)
+	defer target.Database.Close()
+
+	// a backup with a changed table but the original manifest
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, table := range Tables {
+		out, _ := archive.Create(table.Name + ".ndjson")
+		if table.Name == "movie_genre" {
+			io.WriteString(out, `{"id":1,"name":"Action"}`+"\n")
+		}
+	}
+	out, _ := archive.Create(manifestFile)
+	io.WriteString(out, `{"format_version":1,"schema_version":8,"tables":[`)
+	for i, table := range Tables {
+		if i > 0 {
+			io.WriteString(out, ",")
+		}
+		fmt.Fprintf(out, `{"name":"%s","file":"%s.ndjson","rows":0,"sha256":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}`, table.Name, table.Name)
+	}
+	io.WriteString(out, `]}`)
+	archive.Close()
+
+	_, err := Restore(target, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.EqualError(t, err, "checksum of movie_genre.ndjson does not match, the backup is corrupt")
+
+	_, err = Restore(target, bytes.NewReader([]byte("no zip")), 6)
+	assert.Error(t, err)
+}
+
+func Test_Backup_Failed(t *testing.T) {
+	resetDatabases()
+	source := openDatabase(movieTestDbFileCopy)
+	defer source.Database.Close()
+
+	// a failing table leaves a zip that can't be read, let alone restored
+	if _, err := source.Database.Exec(`drop table movie_link_genre`); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err := Backup(source, &buf)
+	assert.Error(t, err)
+	assert.True(t, buf.Len() > 0)
+	_, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Error(t, err)
+
+	// a closed database fails before anything is written
+	source.Database.Close()
+	_, err = NewSnapshot(source)
+	assert.Error(t, err)
+}
+
+func Test_Backup_Copy(t *testing.T) {
+	resetDatabases()
+	source := openDatabase(movieTestDbFileCopy)
+	defer source.Database.Close()
+	target := openDatabase(emptyTestDbFile)
+	defer target.Database.Close()
+
+	copies, err := Copy(source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, len(Tables), len(copies)) {
+		for i, table := range Tables {
+			rows, sum, err := table.Checksum(source.Database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, table.Name, copies[i].Name)
+			assert.Equal(t, rows, copies[i].Rows, table.Name)
+			assert.Equal(t, sum, copies[i].SHA256, table.Name)
+		}
+		assert.Equal(t, 912, copies[7].Rows)
+	}
+
+	// the target is not empty anymore
+	_, err = Copy(source, target)
+	assert.EqualError(t, err, "database is not empty, movie_dbdate has 2 rows")
+
+	// databases of different schema versions can't be copied
+	if _, err := target.Database.Exec(`insert into schema_migration (version) values (99)`); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Copy(source, target)
+	assert.Contains(t, err.Error(), "target has 99")
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+)
+
+type TableCopy struct {
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Copy moves all rows from one database into another, empty one of the same schema version, keeping all ids.
+// Both can be of any type, the tables are compared by row count and checksum afterwards and nothing is kept if they differ.
+// The source is read within one snapshot like a backup, so changes made to it meanwhile are not copied halfway.
+func Copy(source, target *database.Adapter) ([]*TableCopy, error) {
+	sourceTx, err := beginSnapshot(source)
+	if err != nil {
+		return nil, err
+	}
+	defer sourceTx.Rollback()
+
+	targetTx, err := target.Database.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer targetTx.Rollback()
+
+	sourceVersion, err := SchemaVersion(sourceTx)
+	if err != nil {
+		return nil, err
+	}
+	targetVersion, err := SchemaVersion(targetTx)
+	if err != nil {
+		return nil, err
+	}
+	if sourceVersion != targetVersion {
+		return nil, fmt.Errorf("source has schema version %d, target has %d", sourceVersion, targetVersion)
+	}
+	if err := checkEmpty(targetTx); err != nil {
+		return nil, err
+	}
+
+	copies := []*TableCopy{}
+	for _, table := range Tables {
+		if table.Seeded {
+			if err := table.Clear(targetTx); err != nil {
+				return nil, err
+			}
+		}
+
+		// rows are loaded while they are dumped, without holding a whole table in memory
+		reader, writer := io.Pipe()
+		result := &TableCopy{Name: table.Name}
+		dumped := make(chan error, 1)
+		go func() {
+			rows, sum, err := table.dumpWithChecksum(sourceTx, writer)
+			result.Rows, result.SHA256 = rows, sum
+			writer.CloseWithError(err)
+			dumped <- err
+		}()
+		_, err := table.Load(targetTx, reader)
+		reader.CloseWithError(fmt.Errorf("%s could not be loaded", table.Name))
+		if dumpErr := <-dumped; dumpErr != nil && err == nil {
+			err = dumpErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, result)
+	}
+	if err := ResetSequences(targetTx, target.Type); err != nil {
+		return nil, err
+	}
+
+	for i, table := range Tables {
+		rows, sum, err := table.Checksum(targetTx)
+		if err != nil {
+			return nil, err
+		}
+		if rows != copies[i].Rows {
+			return nil, fmt.Errorf("%s has %d rows after copying, source has %d", table.Name, rows, copies[i].Rows)
+		}
+		if sum != copies[i].SHA256 {
+			return nil, fmt.Errorf("checksum of %s does not match after copying", table.Name)
+		}
+	}
+	return copies, targetTx.Commit()
+}
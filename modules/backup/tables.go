@@ -0,0 +1,317 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+	Integer Kind = iota
+	Text
+	Timestamp
+	Bytes
+)
+
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+type Table struct {
+	Name    string
+	Columns []Column
+	OrderBy string
+	Serial  bool // id is generated by a sequence on postgres
+	Seeded  bool // filled by migrations already, its rows are replaced when loading
+}
+
+// Tables lists all tables in dependency order, referenced tables come first.
+// It has to be kept in line with the migrations, see SchemaVersion.
+var Tables = []*Table{
+	{Name: "movie_dbdate", Columns: []Column{{"id", Integer}, {"date", Timestamp}, {"description", Text}}, OrderBy: "id", Serial: true},
+	{Name: "movie_language", Columns: []Column{{"id", Integer}, {"name", Text}, {"country", Text}, {"native_name", Text}}, OrderBy: "id", Serial: true},
+	{Name: "movie_genre", Columns: []Column{{"id", Integer}, {"name", Text}}, OrderBy: "id", Serial: true},
+	{Name: "movie_people", Columns: []Column{{"id", Integer}, {"name", Text}}, OrderBy: "id", Serial: true},
+	{Name: "movie_format", Columns: []Column{{"code", Text}, {"name", Text}, {"position", Integer}}, OrderBy: "code", Seeded: true},
+	{Name: "movie_disk_type", Columns: []Column{{"code", Text}, {"name", Text}, {"position", Integer}}, OrderBy: "code", Seeded: true},
+	{Name: "movie_region", Columns: []Column{{"code", Text}, {"name", Text}, {"position", Integer}}, OrderBy: "code", Seeded: true},
+	{Name: "movie_movie", Columns: []Column{
+		{"id", Integer}, {"title", Text}, {"year", Integer}, {"description", Text}, {"format", Text},
+		{"length", Integer}, {"disk_region", Text}, {"rating", Integer}, {"disks", Integer}, {"score", Integer},
+		{"picture", Text}, {"disk_type", Text}, {"acquired_at", Timestamp}, {"created_at", Timestamp}, {"updated_at", Timestamp},
+	}, OrderBy: "id", Serial: true},
+	{Name: "movie_alttitle", Columns: []Column{{"id", Integer}, {"movie_id", Integer}, {"title", Text}, {"language_id", Integer}, {"type", Text}}, OrderBy: "id", Serial: true},
+	{Name: "movie_link_actor", Columns: []Column{{"movie_id", Integer}, {"person_id", Integer}}, OrderBy: "movie_id, person_id"},
+	{Name: "movie_link_director", Columns: []Column{{"movie_id", Integer}, {"person_id", Integer}}, OrderBy: "movie_id, person_id"},
+	{Name: "movie_link_genre", Columns: []Column{{"movie_id", Integer}, {"genre_id", Integer}}, OrderBy: "movie_id, genre_id"},
+	{Name: "movie_link_language", Columns: []Column{{"movie_id", Integer}, {"language_id", Integer}}, OrderBy: "movie_id, language_id"},
+	{Name: "movie_external_id", Columns: []Column{{"entity_type", Text}, {"entity_id", Integer}, {"provider", Text}, {"external_id", Text}}, OrderBy: "entity_type, entity_id, provider"},
+	{Name: "movie_blob", Columns: []Column{{"key", Text}, {"content_type", Text}, {"hash", Text}, {"data", Bytes}, {"created_at", Timestamp}}, OrderBy: "key"},
+}
+
+// SchemaVersion is the last migration applied to a database
+func SchemaVersion(db Queryer) (int, error) {
+	var version int
+	rows, err := db.Query(`select coalesce(max(version), 0) from schema_migration`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return 0, err
+		}
+	}
+	return version, rows.Err()
+}
+
+// Queryer is implemented by *sql.DB and *sql.Tx
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (t *Table) columnNames() []string {
+	names := make([]string, 0, len(t.Columns))
+	for _, column := range t.Columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+// Count returns the number of rows of a table
+func (t *Table) Count(db Queryer) (int, error) {
+	var count int
+	rows, err := db.Query(fmt.Sprintf(`select count(*) from %s`, t.Name))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, err
+		}
+	}
+	return count, rows.Err()
+}
+
+// Checksum returns the number of rows and the sha256 of their dump
+func (t *Table) Checksum(db Queryer) (int, string, error) {
+	return t.dumpWithChecksum(db, ioutil.Discard)
+}
+
+func (t *Table) dumpWithChecksum(db Queryer, w io.Writer) (int, string, error) {
+	hash := sha256.New()
+	rows, err := t.Dump(db, io.MultiWriter(w, hash))
+	if err != nil {
+		return 0, "", err
+	}
+	return rows, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Dump writes all rows as NDJSON, an object per row with the columns in table order.
+// Values are normalized, dumps of the same data are identical no matter which database they come from.
+func (t *Table) Dump(db Queryer, w io.Writer) (int, error) {
+	rows, err := db.Query(fmt.Sprintf(`select %s from %s order by %s`, strings.Join(t.columnNames(), ", "), t.Name, t.OrderBy))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	values := make([]interface{}, len(t.Columns))
+	pointers := make([]interface{}, len(t.Columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return count, err
+		}
+
+		line := []byte{'{'}
+		for i, column := range t.Columns {
+			value, err := normalize(column.Kind, values[i])
+			if err != nil {
+				return count, fmt.Errorf("%s.%s: %v", t.Name, column.Name, err)
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return count, err
+			}
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, strconv.Quote(column.Name)...)
+			line = append(line, ':')
+			line = append(line, data...)
+		}
+		line = append(line, '}', '\n')
+
+		if _, err := w.Write(line); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// normalize turns whatever the driver returned into an int64, string or nil.
+// Timestamps become RFC 3339 in UTC, bytes are base64 encoded.
+func normalize(kind Kind, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if b, ok := value.([]byte); ok && kind != Bytes {
+		value = string(b)
+	}
+
+	switch kind {
+	case Integer:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+	case Text:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		}
+	case Timestamp:
+		switch v := value.(type) {
+		case time.Time:
+			return v.UTC().Format(time.RFC3339Nano), nil
+		case string:
+			for _, layout := range timestampLayouts {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t.UTC().Format(time.RFC3339Nano), nil
+				}
+			}
+			return nil, fmt.Errorf("invalid timestamp %q", v)
+		}
+	case Bytes:
+		switch v := value.(type) {
+		case []byte:
+			return base64.StdEncoding.EncodeToString(v), nil
+		case string:
+			return base64.StdEncoding.EncodeToString([]byte(v)), nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected value %v (%T)", value, value)
+}
+
+// Load inserts rows written by Dump
+func (t *Table) Load(tx *sql.Tx, r io.Reader) (int, error) {
+	placeholders := make([]string, 0, len(t.Columns))
+	for i := range t.Columns {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+		t.Name, strings.Join(t.columnNames(), ", "), strings.Join(placeholders, ",")))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	count := 0
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var row map[string]interface{}
+		if err := decoder.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return count, fmt.Errorf("%s row %d: %v", t.Name, count+1, err)
+		}
+
+		args := make([]interface{}, 0, len(t.Columns))
+		for _, column := range t.Columns {
+			value, err := denormalize(column.Kind, row[column.Name])
+			if err != nil {
+				return count, fmt.Errorf("%s row %d, %s: %v", t.Name, count+1, column.Name, err)
+			}
+			args = append(args, value)
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return count, fmt.Errorf("%s row %d: %v", t.Name, count+1, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func denormalize(kind Kind, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case Integer:
+		if n, ok := value.(json.Number); ok {
+			return n.Int64()
+		}
+	case Text:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case Timestamp:
+		if s, ok := value.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	case Bytes:
+		if s, ok := value.(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	}
+	return nil, fmt.Errorf("unexpected value %v", value)
+}
+
+// Clear deletes all rows of a table
+func (t *Table) Clear(tx *sql.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s`, t.Name))
+	return err
+}
+
+// ResetSequences lets postgres continue after the highest id of every table, after rows were inserted with their ids.
+// sqlite keeps track of that by itself.
+func ResetSequences(tx *sql.Tx, databaseType string) error {
+	if databaseType != "postgres" {
+		return nil
+	}
+	for _, table := range Tables {
+		if !table.Serial {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%s', 'id'), coalesce(max(id), 0) + 1, false) FROM %s`,
+			table.Name, table.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
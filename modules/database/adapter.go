@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
 
 	"github.com/JamesClonk/vcap"
 	"github.com/Sirupsen/logrus"
@@ -62,3 +64,15 @@ func NewAdapter() (db *Adapter) {
 
 	return db
 }
+
+// NewAdapterFromURI sets up an adapter for any database, the type is taken from the scheme of the uri.
+// sqlite databases use sqlite3://<file>, postgres ones postgres:// or postgresql://
+func NewAdapterFromURI(uri string) (*Adapter, error) {
+	switch {
+	case strings.HasPrefix(uri, "sqlite3://"):
+		return newSQLiteAdapter(uri), nil
+	case strings.HasPrefix(uri, "postgres://"), strings.HasPrefix(uri, "postgresql://"):
+		return newPostgresAdapter(uri), nil
+	}
+	return nil, errors.New("Invalid database uri, must start with sqlite3://, postgres:// or postgresql://")
+}
@@ -0,0 +1,205 @@
+package enrichment
+
+import (
+	"strings"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+type Proposal struct {
+	MovieId  int            `json:"movie_id" xml:"movie_id,attr"`
+	Provider string         `json:"provider" xml:"provider"`
+	SourceId string         `json:"source_id" xml:"source_id"`
+	Changes  []*Change      `json:"changes" xml:"changes"`
+	Movie    *moviedb.Movie `json:"-" xml:"-"`
+}
+
+type Change struct {
+	Field    string      `json:"field" xml:"field,attr"`
+	Current  interface{} `json:"current" xml:"current"`
+	Proposed interface{} `json:"proposed" xml:"proposed"`
+	apply    func(*moviedb.Movie)
+}
+
+// Propose looks up the given movie at the metadata provider and returns all fields that would change.
+// If sourceId is empty, the movie is searched for by title and year and the first hit is used.
+func Propose(provider MetadataProvider, movie *moviedb.Movie, sourceId string) (*Proposal, error) {
+	if len(sourceId) == 0 {
+		results, err := provider.Search(movie.Title, movie.Year)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return nil, ErrNoMatch
+		}
+		sourceId = results[0].Id
+	}
+
+	details, err := provider.Details(sourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proposal{
+		MovieId:  movie.Id,
+		Provider: provider.Name(),
+		SourceId: sourceId,
+		Changes:  Diff(movie, details),
+		Movie:    movie,
+	}, nil
+}
+
+// Apply returns a copy of the movie with the proposed changes applied.
+// Only the given fields are applied, or all of them if none are given.
+func (p *Proposal) Apply(fields ...string) *moviedb.Movie {
+	movie := copyMovie(p.Movie)
+	for _, change := range p.Changes {
+		if len(fields) == 0 || contains(fields, change.Field) {
+			change.apply(movie)
+		}
+	}
+	return movie
+}
+
+// Diff compares a movie with the details of a metadata provider.
+// Scalar fields are only proposed if the provider knows about them, lists are only ever extended.
+func Diff(movie, details *moviedb.Movie) []*Change {
+	changes := []*Change{}
+
+	if len(details.Description) > 0 && details.Description != movie.Description {
+		description := details.Description
+		changes = append(changes, &Change{"description", movie.Description, description,
+			func(m *moviedb.Movie) { m.Description = description }})
+	}
+	if details.Year > 0 && details.Year != movie.Year {
+		year := details.Year
+		changes = append(changes, &Change{"year", movie.Year, year,
+			func(m *moviedb.Movie) { m.Year = year }})
+	}
+	if details.Length > 0 && details.Length != movie.Length {
+		length := details.Length
+		changes = append(changes, &Change{"length", movie.Length, length,
+			func(m *moviedb.Movie) { m.Length = length }})
+	}
+	if len(details.Picture) > 0 && details.Picture != movie.Picture {
+		picture := details.Picture
+		changes = append(changes, &Change{"picture", movie.Picture, picture,
+			func(m *moviedb.Movie) { m.Picture = picture }})
+	}
+
+	var genres []string
+	for _, genre := range details.Genres {
+		if !containsGenre(movie.Genres, genre.Name) {
+			genres = append(genres, genre.Name)
+		}
+	}
+	if len(genres) > 0 {
+		changes = append(changes, &Change{"genres", genreNames(movie.Genres), genres,
+			func(m *moviedb.Movie) {
+				for _, genre := range genres {
+					m.Genres = append(m.Genres, &moviedb.Genre{Name: genre})
+				}
+			}})
+	}
+
+	if actors := missingPeople(movie.Actors, details.Actors); len(actors) > 0 {
+		changes = append(changes, &Change{"actors", personNames(movie.Actors), actors,
+			func(m *moviedb.Movie) {
+				for _, actor := range actors {
+					m.Actors = append(m.Actors, &moviedb.Person{Name: actor})
+				}
+			}})
+	}
+	if directors := missingPeople(movie.Directors, details.Directors); len(directors) > 0 {
+		changes = append(changes, &Change{"directors", personNames(movie.Directors), directors,
+			func(m *moviedb.Movie) {
+				for _, director := range directors {
+					m.Directors = append(m.Directors, &moviedb.Person{Name: director})
+				}
+			}})
+	}
+
+	var externalIds []*moviedb.ExternalId
+	for _, externalId := range details.ExternalIds {
+		if !containsExternalId(movie.ExternalIds, externalId) {
+			externalIds = append(externalIds, externalId)
+		}
+	}
+	if len(externalIds) > 0 {
+		changes = append(changes, &Change{"external_ids", movie.ExternalIds, externalIds,
+			func(m *moviedb.Movie) { m.ExternalIds = append(m.ExternalIds, externalIds...) }})
+	}
+
+	return changes
+}
+
+func copyMovie(movie *moviedb.Movie) *moviedb.Movie {
+	m := *movie
+	m.Alttitles = append([]*moviedb.AlternateTitle{}, movie.Alttitles...)
+	m.Languages = append([]*moviedb.Language{}, movie.Languages...)
+	m.Genres = append([]*moviedb.Genre{}, movie.Genres...)
+	m.Actors = append([]*moviedb.Person{}, movie.Actors...)
+	m.Directors = append([]*moviedb.Person{}, movie.Directors...)
+	m.ExternalIds = append([]*moviedb.ExternalId{}, movie.ExternalIds...)
+	return &m
+}
+
+func missingPeople(current, proposed []*moviedb.Person) []string {
+	var names []string
+	for _, person := range proposed {
+		found := false
+		for _, p := range current {
+			if strings.EqualFold(p.Name, person.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, person.Name)
+		}
+	}
+	return names
+}
+
+func containsGenre(genres []*moviedb.Genre, name string) bool {
+	for _, genre := range genres {
+		if strings.EqualFold(genre.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsExternalId(externalIds []*moviedb.ExternalId, externalId *moviedb.ExternalId) bool {
+	for _, e := range externalIds {
+		if strings.EqualFold(e.Provider, externalId.Provider) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func genreNames(genres []*moviedb.Genre) []string {
+	names := []string{}
+	for _, genre := range genres {
+		names = append(names, genre.Name)
+	}
+	return names
+}
+
+func personNames(people []*moviedb.Person) []string {
+	names := []string{}
+	for _, person := range people {
+		names = append(names, person.Name)
+	}
+	return names
+}
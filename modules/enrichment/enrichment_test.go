@@ -0,0 +1,150 @@
+package enrichment
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMetadataStub() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Query().Get("barcode") == "5050582721478":
+			fmt.Fprint(w, `[{"id":"mad-max-2","title":"Mad Max 2","year":1981}]`)
+		case req.URL.Query().Get("title") == "Mad Max 2" && req.URL.Query().Get("year") == "1981":
+			fmt.Fprint(w, `[{"id":"mad-max-2","title":"Mad Max 2","year":1981},{"id":"mad-max-3","title":"Mad Max 3","year":1985}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	})
+	mux.HandleFunc("/movie/mad-max-2", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{
+			"id": "mad-max-2",
+			"title": "Mad Max 2",
+			"year": 1981,
+			"description": "The Road Warrior.",
+			"length": 95,
+			"genres": ["Action", "Sci-Fi"],
+			"actors": ["Mel Gibson", "Bruce Spence"],
+			"directors": ["George Miller"],
+			"external_ids": [{"provider": "imdb", "id": "tt0082694"}]
+		}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func Test_Enrichment_HTTPProvider(t *testing.T) {
+	stub := newMetadataStub()
+	defer stub.Close()
+
+	provider := NewHTTPProvider(stub.URL + "/")
+
+	results, err := provider.Search("Mad Max 2", 1981)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, &SearchResult{Id: "mad-max-2", Title: "Mad Max 2", Year: 1981}, results[0])
+
+	results, err = provider.SearchByBarcode("5050582721478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(results))
+
+	movie, err := provider.Details("mad-max-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Mad Max 2", movie.Title)
+	assert.Equal(t, 95, movie.Length)
+	assert.Equal(t, []*moviedb.Genre{&moviedb.Genre{Name: "Action"}, &moviedb.Genre{Name: "Sci-Fi"}}, movie.Genres)
+	assert.Equal(t, []*moviedb.Person{&moviedb.Person{Name: "George Miller"}}, movie.Directors)
+
+	_, err = provider.Details("unknown")
+	assert.Equal(t, ErrNoMatch, err)
+}
+
+func Test_Enrichment_HTTPProviderFailures(t *testing.T) {
+	var path string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.EscapedPath()
+		switch req.URL.Query().Get("title") {
+		case "broken":
+			fmt.Fprint(w, `[{"id":`)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	// ids are a single path segment
+	provider := NewHTTPProvider(stub.URL)
+	_, err := provider.Details("a b/c+d")
+	assert.Equal(t, "/movie/a%20b%2Fc+d", path)
+	if assert.IsType(t, &UpstreamError{}, err) {
+		assert.EqualError(t, err.(*UpstreamError).Err, "metadata provider returned status 502")
+	}
+
+	_, err = provider.Search("broken", 0)
+	assert.IsType(t, &UpstreamError{}, err)
+
+	stub.Close()
+	_, err = provider.Search("Mad Max 2", 1981)
+	assert.IsType(t, &UpstreamError{}, err)
+	assert.EqualError(t, err, "metadata provider unavailable")
+}
+
+func Test_Enrichment_Propose(t *testing.T) {
+	stub := newMetadataStub()
+	defer stub.Close()
+
+	movie := &moviedb.Movie{
+		Id:     42,
+		Title:  "Mad Max 2",
+		Year:   1981,
+		Length: 95,
+		Genres: []*moviedb.Genre{&moviedb.Genre{Id: 1, Name: "action"}},
+		Actors: []*moviedb.Person{&moviedb.Person{Id: 189, Name: "Mel Gibson"}},
+	}
+
+	proposal, err := Propose(NewHTTPProvider(stub.URL), movie, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 42, proposal.MovieId)
+	assert.Equal(t, "mad-max-2", proposal.SourceId)
+
+	var fields []string
+	for _, change := range proposal.Changes {
+		fields = append(fields, change.Field)
+	}
+	assert.Equal(t, []string{"description", "genres", "actors", "directors", "external_ids"}, fields)
+	assert.Equal(t, []string{"Sci-Fi"}, proposal.Changes[1].Proposed)
+	assert.Equal(t, []string{"Bruce Spence"}, proposal.Changes[2].Proposed)
+
+	// apply only some of the changes
+	enriched := proposal.Apply("description", "actors")
+	assert.Equal(t, "The Road Warrior.", enriched.Description)
+	assert.Equal(t, 1, len(enriched.Genres))
+	assert.Equal(t, 2, len(enriched.Actors))
+	assert.Equal(t, 0, len(enriched.Directors))
+
+	// original movie must not be touched
+	assert.Equal(t, "", movie.Description)
+	assert.Equal(t, 1, len(movie.Actors))
+
+	// apply everything
+	enriched = proposal.Apply()
+	assert.Equal(t, 2, len(enriched.Genres))
+	assert.Equal(t, "George Miller", enriched.Directors[0].Name)
+	assert.Equal(t, "tt0082694", enriched.ExternalIds[0].ExternalId)
+
+	// no match
+	_, err = Propose(NewHTTPProvider(stub.URL), &moviedb.Movie{Title: "Unknown"}, "")
+	assert.Equal(t, ErrNoMatch, err)
+}
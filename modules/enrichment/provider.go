@@ -0,0 +1,142 @@
+package enrichment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+var (
+	ErrNoMatch = errors.New("no matching movie found at metadata provider")
+)
+
+// UpstreamError is returned if the metadata provider can't be reached or fails to answer properly
+type UpstreamError struct {
+	Err error
+}
+
+func (e *UpstreamError) Error() string {
+	return "metadata provider unavailable"
+}
+
+type MetadataProvider interface {
+	Name() string
+	Search(title string, year int) ([]*SearchResult, error)
+	SearchByBarcode(barcode string) ([]*SearchResult, error)
+	Details(id string) (*moviedb.Movie, error)
+}
+
+type SearchResult struct {
+	Id    string `json:"id" xml:"id,attr"`
+	Title string `json:"title" xml:"title"`
+	Year  int    `json:"year" xml:"year"`
+}
+
+// httpProvider talks JSON over HTTP to a metadata service with the following endpoints:
+//
+//	GET <base>/search?title=<title>&year=<year>
+//	GET <base>/search?barcode=<barcode>
+//	GET <base>/movie/<id>
+type httpProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+type details struct {
+	Id          string                `json:"id"`
+	Title       string                `json:"title"`
+	Year        int                   `json:"year"`
+	Description string                `json:"description"`
+	Length      int                   `json:"length"`
+	Picture     string                `json:"picture"`
+	Genres      []string              `json:"genres"`
+	Actors      []string              `json:"actors"`
+	Directors   []string              `json:"directors"`
+	ExternalIds []*moviedb.ExternalId `json:"external_ids"`
+}
+
+func NewHTTPProvider(baseURL string) MetadataProvider {
+	return &httpProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *httpProvider) Name() string {
+	return "http"
+}
+
+func (p *httpProvider) Search(title string, year int) ([]*SearchResult, error) {
+	query := url.Values{}
+	query.Set("title", title)
+	if year > 0 {
+		query.Set("year", strconv.Itoa(year))
+	}
+	return p.search(query)
+}
+
+func (p *httpProvider) SearchByBarcode(barcode string) ([]*SearchResult, error) {
+	query := url.Values{}
+	query.Set("barcode", barcode)
+	return p.search(query)
+}
+
+func (p *httpProvider) search(query url.Values) ([]*SearchResult, error) {
+	var results []*SearchResult
+	if err := p.get(fmt.Sprintf("%s/search?%s", p.baseURL, query.Encode()), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (p *httpProvider) Details(id string) (*moviedb.Movie, error) {
+	var d details
+	if err := p.get(fmt.Sprintf("%s/movie/%s", p.baseURL, url.PathEscape(id)), &d); err != nil {
+		return nil, err
+	}
+
+	movie := &moviedb.Movie{
+		Title:       d.Title,
+		Year:        d.Year,
+		Description: d.Description,
+		Length:      d.Length,
+		Picture:     d.Picture,
+		ExternalIds: d.ExternalIds,
+	}
+	for _, genre := range d.Genres {
+		movie.Genres = append(movie.Genres, &moviedb.Genre{Name: genre})
+	}
+	for _, actor := range d.Actors {
+		movie.Actors = append(movie.Actors, &moviedb.Person{Name: actor})
+	}
+	for _, director := range d.Directors {
+		movie.Directors = append(movie.Directors, &moviedb.Person{Name: director})
+	}
+	return movie, nil
+}
+
+func (p *httpProvider) get(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return &UpstreamError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNoMatch
+	case resp.StatusCode != http.StatusOK:
+		return &UpstreamError{fmt.Errorf("metadata provider returned status %d", resp.StatusCode)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &UpstreamError{fmt.Errorf("invalid response of metadata provider: %v", err)}
+	}
+	return nil
+}
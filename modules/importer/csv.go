@@ -0,0 +1,373 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+// Fields lists the movie fields a CSV column can be mapped to
+var Fields = []string{
+	"title", "alttitles", "year", "description", "format", "length", "region", "rating",
+	"disks", "score", "picture", "type", "acquired_at", "languages", "genres", "actors", "directors",
+}
+
+// Mapping maps movie fields to the CSV columns holding them
+type Mapping map[string]string
+
+// ParseMapping reads pairs like "title:Name", fields not mentioned are read from the column of the same name
+func ParseMapping(pairs ...string) (Mapping, error) {
+	mapping := Mapping{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("Invalid mapping: %s, must be field:column", pair)
+		}
+		field := strings.TrimSpace(parts[0])
+		if !isField(field) {
+			return nil, fmt.Errorf("Invalid mapping field: %s, must be one of: %s", field, strings.Join(Fields, ", "))
+		}
+		mapping[field] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+func isField(name string) bool {
+	for _, field := range Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+type CSVOptions struct {
+	Separator rune   // between columns, defaults to ','
+	Delimiter string // between values of multi-valued columns like actors or genres, defaults to "|"
+	Mapping   Mapping
+}
+
+// Row is a single CSV record turned into a movie, with all values that could not be read
+type Row struct {
+	Line   int
+	Movie  *moviedb.Movie
+	Errors []*moviedb.Violation
+}
+
+type csvImporter struct {
+	options CSVOptions
+}
+
+func (c *csvImporter) Read(r io.Reader) ([]*Row, error) {
+	return ReadCSV(r, c.options)
+}
+
+// ReadCSV reads movies from CSV with a header line, column names are not case sensitive.
+// A column mapped explicitly has to exist, columns named like a field are used unless that field is mapped to another column.
+// Movies without a disks column are assumed to have a single disk.
+func ReadCSV(r io.Reader, options CSVOptions) ([]*Row, error) {
+	if options.Separator == 0 {
+		options.Separator = ','
+	}
+	if len(options.Delimiter) == 0 {
+		options.Delimiter = "|"
+	}
+
+	reader := newCSVReader(r, options.Separator)
+	reader.trimLeadingSpace = true
+
+	header, _, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("CSV is empty, a header line is required")
+		}
+		return nil, err
+	}
+	columns, err := mapColumns(header, options.Mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []*Row{}
+	for {
+		record, line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, readRow(line, record, columns, options.Delimiter))
+	}
+	return rows, nil
+}
+
+// csvReader reads CSV records together with the line they start on, which encoding/csv does not tell.
+// Lines are joined as long as a quoted field is still open, then parsed as a record of their own.
+type csvReader struct {
+	scanner          *bufio.Scanner
+	comma            rune
+	trimLeadingSpace bool
+	fields           int // per record, taken from the first one like encoding/csv does, -1 allows any number
+	line             int
+}
+
+func newCSVReader(r io.Reader, comma rune) *csvReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	return &csvReader{scanner: scanner, comma: comma}
+}
+
+func (c *csvReader) Read() ([]string, int, error) {
+	for c.scanner.Scan() {
+		c.line++
+		text := c.scanner.Text()
+		// encoding/csv skips empty lines as well
+		if len(text) == 0 {
+			continue
+		}
+		line := c.line
+		for strings.Count(text, `"`)%2 == 1 && c.scanner.Scan() {
+			c.line++
+			text += "\n" + c.scanner.Text()
+		}
+
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.Comma = c.comma
+		reader.TrimLeadingSpace = c.trimLeadingSpace
+		reader.FieldsPerRecord = -1
+		record, err := reader.Read()
+		if err != nil {
+			if perr, ok := err.(*csv.ParseError); ok {
+				perr.StartLine += line - 1
+				perr.Line += line - 1
+			}
+			return nil, line, err
+		}
+		if c.fields == 0 {
+			c.fields = len(record)
+		}
+		if c.fields > 0 && len(record) != c.fields {
+			return nil, line, &csv.ParseError{StartLine: line, Line: line, Column: 1, Err: csv.ErrFieldCount}
+		}
+		return record, line, nil
+	}
+	if err := c.scanner.Err(); err != nil {
+		return nil, c.line, err
+	}
+	return nil, c.line, io.EOF
+}
+
+// mapColumns returns the index of the column to read for every field
+func mapColumns(header []string, mapping Mapping) (map[string]int, error) {
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	columns := map[string]int{}
+	for _, field := range Fields {
+		column, mapped := mapping[field]
+		if !mapped {
+			column = field
+		}
+		i, ok := index[strings.ToLower(column)]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("Column %s mapped to %s not found", column, field)
+			}
+			continue
+		}
+		columns[field] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, fmt.Errorf("No column for title found, map one with title:<column>")
+	}
+	return columns, nil
+}
+
+func readRow(line int, record []string, columns map[string]int, delimiter string) *Row {
+	row := &Row{Line: line, Movie: &moviedb.Movie{Disks: 1}, Errors: []*moviedb.Violation{}}
+	movie := row.Movie
+
+	value := func(field string) string {
+		if i, ok := columns[field]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	number := func(field string, target *int) {
+		if v := value(field); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				row.Errors = append(row.Errors, &moviedb.Violation{Field: field, Rule: "format", Message: "must be a number"})
+				return
+			}
+			*target = n
+		}
+	}
+	list := func(field string) []string {
+		values := []string{}
+		for _, v := range strings.Split(value(field), delimiter) {
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+
+	movie.Title = value("title")
+	movie.Description = value("description")
+	movie.Format = value("format")
+	movie.Region = value("region")
+	movie.Picture = value("picture")
+	movie.Type = value("type")
+	number("year", &movie.Year)
+	number("length", &movie.Length)
+	number("rating", &movie.Rating)
+	number("disks", &movie.Disks)
+	number("score", &movie.Score)
+
+	if v := value("acquired_at"); len(v) > 0 {
+		acquiredAt, err := moviedb.ParseTime(v)
+		if err != nil {
+			row.Errors = append(row.Errors, &moviedb.Violation{Field: "acquired_at", Rule: "format", Message: "must be a date like 2006-01-02 or RFC 3339"})
+		} else {
+			movie.AcquiredAt = &acquiredAt
+		}
+	}
+
+	movie.Alttitles = []*moviedb.AlternateTitle{}
+	for _, title := range list("alttitles") {
+		movie.Alttitles = append(movie.Alttitles, &moviedb.AlternateTitle{Title: title})
+	}
+	movie.Languages = []*moviedb.Language{}
+	for _, name := range list("languages") {
+		movie.Languages = append(movie.Languages, &moviedb.Language{Name: name})
+	}
+	movie.Genres = []*moviedb.Genre{}
+	for _, name := range list("genres") {
+		movie.Genres = append(movie.Genres, &moviedb.Genre{Name: name})
+	}
+	movie.Actors = []*moviedb.Person{}
+	for _, name := range list("actors") {
+		movie.Actors = append(movie.Actors, &moviedb.Person{Name: name})
+	}
+	movie.Directors = []*moviedb.Person{}
+	for _, name := range list("directors") {
+		movie.Directors = append(movie.Directors, &moviedb.Person{Name: name})
+	}
+
+	return row
+}
+
+// CSVWriter writes movies in the format read by ReadCSV, so exports can be imported again.
+// There is a column for every field, named as mapped or like the field itself.
+type CSVWriter struct {
+	writer    *csv.Writer
+	options   CSVOptions
+	hasHeader bool
+}
+
+func NewCSVWriter(w io.Writer, options CSVOptions) *CSVWriter {
+	if options.Separator == 0 {
+		options.Separator = ','
+	}
+	if len(options.Delimiter) == 0 {
+		options.Delimiter = "|"
+	}
+	writer := csv.NewWriter(w)
+	writer.Comma = options.Separator
+	return &CSVWriter{writer: writer, options: options}
+}
+
+func (w *CSVWriter) writeHeader() error {
+	if w.hasHeader {
+		return nil
+	}
+	w.hasHeader = true
+
+	header := make([]string, 0, len(Fields))
+	for _, field := range Fields {
+		if column, ok := w.options.Mapping[field]; ok {
+			header = append(header, column)
+		} else {
+			header = append(header, field)
+		}
+	}
+	return w.writer.Write(header)
+}
+
+func (w *CSVWriter) Write(movie *moviedb.Movie) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	join := func(values []string) string {
+		return strings.Join(values, w.options.Delimiter)
+	}
+	names := func(people []*moviedb.Person) string {
+		values := make([]string, 0, len(people))
+		for _, person := range people {
+			values = append(values, person.Name)
+		}
+		return join(values)
+	}
+
+	alttitles := make([]string, 0, len(movie.Alttitles))
+	for _, alttitle := range movie.Alttitles {
+		alttitles = append(alttitles, alttitle.Title)
+	}
+	languages := make([]string, 0, len(movie.Languages))
+	for _, language := range movie.Languages {
+		languages = append(languages, language.Name)
+	}
+	genres := make([]string, 0, len(movie.Genres))
+	for _, genre := range movie.Genres {
+		genres = append(genres, genre.Name)
+	}
+	var acquiredAt string
+	if movie.AcquiredAt != nil {
+		acquiredAt = movie.AcquiredAt.UTC().Format(time.RFC3339)
+	}
+
+	values := map[string]string{
+		"title":       movie.Title,
+		"alttitles":   join(alttitles),
+		"year":        strconv.Itoa(movie.Year),
+		"description": movie.Description,
+		"format":      movie.Format,
+		"length":      strconv.Itoa(movie.Length),
+		"region":      movie.Region,
+		"rating":      strconv.Itoa(movie.Rating),
+		"disks":       strconv.Itoa(movie.Disks),
+		"score":       strconv.Itoa(movie.Score),
+		"picture":     movie.Picture,
+		"type":        movie.Type,
+		"acquired_at": acquiredAt,
+		"languages":   join(languages),
+		"genres":      join(genres),
+		"actors":      names(movie.Actors),
+		"directors":   names(movie.Directors),
+	}
+	record := make([]string, 0, len(Fields))
+	for _, field := range Fields {
+		record = append(record, values[field])
+	}
+	return w.writer.Write(record)
+}
+
+// Flush writes everything buffered, and the header if no movie was written at all
+func (w *CSVWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
@@ -0,0 +1,259 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+// dvdProfilerImporter reads the XML collection export of DVD Profiler, a <Collection> of <DVD> elements.
+// The disk type comes from the media types of a DVD, diskType is used if they don't tell.
+type dvdProfilerImporter struct {
+	diskType string
+}
+
+type dvdProfilerDVD struct {
+	Title          string `xml:"Title"`
+	OriginalTitle  string `xml:"OriginalTitle"`
+	ProductionYear string `xml:"ProductionYear"`
+	RunningTime    string `xml:"RunningTime"`
+	Rating         string `xml:"Rating"`
+	Overview       string `xml:"Overview"`
+	MediaTypes     struct {
+		DVD    string `xml:"DVD"`
+		BluRay string `xml:"BluRay"`
+	} `xml:"MediaTypes"`
+	Format16X9   string              `xml:"Format>Format16X9"`
+	Regions      []string            `xml:"Regions>Region"`
+	Genres       []string            `xml:"Genres>Genre"`
+	Audio        []string            `xml:"Audio>AudioTrack>AudioContent"`
+	Actors       []dvdProfilerPerson `xml:"Actors>Actor"`
+	Credits      []dvdProfilerPerson `xml:"Credits>Credit"`
+	Discs        []struct{}          `xml:"Discs>Disc"`
+	PurchaseDate string              `xml:"PurchaseInfo>PurchaseDate"`
+}
+
+type dvdProfilerPerson struct {
+	FirstName     string `xml:"FirstName,attr"`
+	MiddleName    string `xml:"MiddleName,attr"`
+	LastName      string `xml:"LastName,attr"`
+	CreditSubtype string `xml:"CreditSubtype,attr"`
+}
+
+func (p dvdProfilerPerson) name() string {
+	names := []string{}
+	for _, name := range []string{p.FirstName, p.MiddleName, p.LastName} {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+var digits = regexp.MustCompile(`\d+`)
+
+func (d *dvdProfilerImporter) Read(r io.Reader) ([]*Row, error) {
+	// the decoder reads byte by byte from a lineCounter, after the encoding declaration from one on top of the charset
+	counter := newLineCounter(r, 1)
+	decoder := xml.NewDecoder(counter)
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		decoded, err := charsetReader(charset, input)
+		if err != nil {
+			return nil, err
+		}
+		counter = newLineCounter(decoded, counter.line)
+		return counter, nil
+	}
+	rows := []*Row{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "DVD" {
+			continue
+		}
+
+		line := counter.line
+		var dvd dvdProfilerDVD
+		if err := decoder.DecodeElement(&dvd, &start); err != nil {
+			return nil, err
+		}
+		rows = append(rows, dvd.row(line, d.diskType))
+	}
+	return rows, nil
+}
+
+// lineCounter counts the lines read through it, the decoder is at the end of the last token read
+type lineCounter struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newLineCounter(r io.Reader, line int) *lineCounter {
+	return &lineCounter{r: bufio.NewReader(r), line: line}
+}
+
+func (c *lineCounter) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil && b == '\n' {
+		c.line++
+	}
+	return b, err
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.line += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
+
+func (dvd *dvdProfilerDVD) row(line int, diskType string) *Row {
+	row := &Row{Line: line, Movie: &moviedb.Movie{Disks: 1}, Errors: []*moviedb.Violation{}}
+	movie := row.Movie
+
+	number := func(field, value string, target *int) {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				row.Errors = append(row.Errors, &moviedb.Violation{Field: field, Rule: "format", Message: "must be a number"})
+				return
+			}
+			*target = n
+		}
+	}
+
+	movie.Title = strings.TrimSpace(dvd.Title)
+	movie.Description = strings.TrimSpace(dvd.Overview)
+	number("year", dvd.ProductionYear, &movie.Year)
+	number("length", dvd.RunningTime, &movie.Length)
+	// ratings are like "FSK 16" or "R", only age ratings can be kept
+	if age := digits.FindString(dvd.Rating); len(age) > 0 {
+		movie.Rating, _ = strconv.Atoi(age)
+	}
+	if len(dvd.Discs) > 0 {
+		movie.Disks = len(dvd.Discs)
+	}
+
+	movie.Type = diskType
+	onDVD, onBluRay := isTrue(dvd.MediaTypes.DVD), isTrue(dvd.MediaTypes.BluRay)
+	if onBluRay && !onDVD {
+		movie.Type = "BluRay"
+	} else if onDVD && !onBluRay {
+		movie.Type = "DVD"
+	}
+	if isTrue(dvd.Format16X9) {
+		movie.Format = "16:9"
+	}
+	if len(dvd.Regions) > 0 {
+		movie.Region = strings.TrimSpace(dvd.Regions[0])
+	}
+
+	if v := strings.TrimSpace(dvd.PurchaseDate); len(v) > 0 {
+		acquiredAt, err := moviedb.ParseTime(v)
+		if err != nil {
+			row.Errors = append(row.Errors, &moviedb.Violation{Field: "acquired_at", Rule: "format", Message: "must be a date like 2006-01-02 or RFC 3339"})
+		} else {
+			movie.AcquiredAt = &acquiredAt
+		}
+	}
+
+	movie.Alttitles = []*moviedb.AlternateTitle{}
+	if title := strings.TrimSpace(dvd.OriginalTitle); len(title) > 0 && title != movie.Title {
+		movie.Alttitles = append(movie.Alttitles, &moviedb.AlternateTitle{Title: title, Type: moviedb.AlttitleOriginal})
+	}
+	movie.Languages = []*moviedb.Language{}
+	seen := map[string]bool{}
+	for _, name := range dvd.Audio {
+		if name = strings.TrimSpace(name); len(name) > 0 && !seen[name] {
+			movie.Languages = append(movie.Languages, &moviedb.Language{Name: name})
+			seen[name] = true
+		}
+	}
+	movie.Genres = []*moviedb.Genre{}
+	for _, name := range dvd.Genres {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			movie.Genres = append(movie.Genres, &moviedb.Genre{Name: name})
+		}
+	}
+	// actor lists can contain dividers without names
+	movie.Actors = []*moviedb.Person{}
+	for _, actor := range dvd.Actors {
+		if name := actor.name(); len(name) > 0 {
+			movie.Actors = append(movie.Actors, &moviedb.Person{Name: name})
+		}
+	}
+	movie.Directors = []*moviedb.Person{}
+	for _, credit := range dvd.Credits {
+		if name := credit.name(); len(name) > 0 && credit.CreditSubtype == "Director" {
+			movie.Directors = append(movie.Directors, &moviedb.Person{Name: name})
+		}
+	}
+	return row
+}
+
+func isTrue(value string) bool {
+	return strings.EqualFold(strings.TrimSpace(value), "true")
+}
+
+// windows1252 holds the characters of windows-1252 which differ from iso-8859-1, for bytes 0x80 to 0x9f
+var windows1252 = [32]rune{
+	'\u20ac', '\u0081', '\u201a', '\u0192', '\u201e', '\u2026', '\u2020', '\u2021',
+	'\u02c6', '\u2030', '\u0160', '\u2039', '\u0152', '\u008d', '\u017d', '\u008f',
+	'\u0090', '\u2018', '\u2019', '\u201c', '\u201d', '\u2022', '\u2013', '\u2014',
+	'\u02dc', '\u2122', '\u0161', '\u203a', '\u0153', '\u009d', '\u017e', '\u0178',
+}
+
+// charsetReader decodes the single byte charsets DVD Profiler writes its exports in
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "windows-1252", "cp1252":
+		return &singleByteReader{r: input, table: &windows1252}, nil
+	case "iso-8859-1", "latin1":
+		return &singleByteReader{r: input}, nil
+	}
+	return nil, fmt.Errorf("unsupported charset: %s", charset)
+}
+
+// singleByteReader turns iso-8859-1 into UTF-8, and windows-1252 too with its table
+type singleByteReader struct {
+	r       io.Reader
+	table   *[32]rune
+	in      [1024]byte
+	encoded [utf8.UTFMax]byte
+	pending []byte
+	err     error
+}
+
+func (s *singleByteReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		n, err := s.r.Read(s.in[:])
+		for _, b := range s.in[:n] {
+			r := rune(b)
+			if s.table != nil && b >= 0x80 && b < 0xa0 {
+				r = s.table[b-0x80]
+			}
+			n := utf8.EncodeRune(s.encoded[:], r)
+			s.pending = append(s.pending, s.encoded[:n]...)
+		}
+		s.err = err
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,273 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/jamesclonk-io/stdlib/logger"
+)
+
+var log *logrus.Logger
+
+func init() {
+	log = logger.GetLogger()
+}
+
+const (
+	StatusValid     = "valid"     // dry run only, would have been imported
+	StatusInvalid   = "invalid"   // row has errors and was skipped
+	StatusDuplicate = "duplicate" // row looks like an existing movie or an earlier row and was skipped
+	StatusMatch     = "match"     // row has a title similar to an existing movie and was skipped
+	StatusImported  = "imported"
+	StatusFailed    = "failed" // saving failed, the whole batch was rolled back
+)
+
+type Options struct {
+	DryRun    bool // only validate and look for duplicates, nothing is saved
+	BatchSize int  // rows committed per transaction, defaults to 50
+	Force     bool // import rows looking like duplicates or matching an existing movie too
+
+	// titles at least this similar to one of an existing movie are reported as a match, defaults to 0.85
+	MinSimilarity float64
+}
+
+// Importer reads the movies of a collection, as exported by some collection manager
+type Importer interface {
+	Read(r io.Reader) ([]*Row, error)
+}
+
+// Formats lists the collection formats there is an Importer for
+var Formats = []string{"csv", "dvdprofiler", "letterboxd", "list"}
+
+type FormatOptions struct {
+	CSV  CSVOptions // only used for csv
+	Type string     // disk type of movies from formats without one, and of dvdprofiler entries without media types, defaults to DVD
+}
+
+// NewImporter returns the Importer of a format
+func NewImporter(format string, options FormatOptions) (Importer, error) {
+	if len(options.Type) == 0 {
+		options.Type = "DVD"
+	}
+
+	switch format {
+	case "csv":
+		return &csvImporter{options.CSV}, nil
+	case "dvdprofiler":
+		return &dvdProfilerImporter{options.Type}, nil
+	case "letterboxd":
+		return &letterboxdImporter{options.Type}, nil
+	case "list":
+		return &listImporter{options.Type}, nil
+	}
+	return nil, fmt.Errorf("Invalid format: %s, must be one of: %s", format, strings.Join(Formats, ", "))
+}
+
+type Report struct {
+	DryRun     bool         `json:"dry_run" xml:"dry_run,attr"`
+	Rows       int          `json:"rows" xml:"rows"`
+	Valid      int          `json:"valid" xml:"valid"`
+	Invalid    int          `json:"invalid" xml:"invalid"`
+	Duplicates int          `json:"duplicates" xml:"duplicates"`
+	Imported   int          `json:"imported" xml:"imported"`
+	Failed     int          `json:"failed" xml:"failed"`
+	Matches    int          `json:"matches" xml:"matches"`
+	Results    []*RowResult `json:"results" xml:"results>result"`
+}
+
+type RowResult struct {
+	Line           int                  `json:"line" xml:"line,attr"`
+	Title          string               `json:"title" xml:"title"`
+	Status         string               `json:"status" xml:"status,attr"`
+	Id             int                  `json:"id,omitempty" xml:"id,omitempty"` // of the imported movie
+	Errors         []*moviedb.Violation `json:"errors,omitempty" xml:"errors>error,omitempty"`
+	Duplicates     []*moviedb.Duplicate `json:"duplicates,omitempty" xml:"duplicates>duplicate,omitempty"`
+	DuplicateLines []int                `json:"duplicate_lines,omitempty" xml:"duplicate_lines>line,omitempty"` // earlier rows of the same import
+	Matches        []*moviedb.Duplicate `json:"matches,omitempty" xml:"matches>match,omitempty"`                // existing movies with a similar title
+	Error          string               `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// Import validates all rows and checks them for duplicates, then saves the valid ones in batches.
+// Every batch is a transaction of its own, if saving a row fails the rest of its batch is rolled back with it
+// and marked as failed, while the following batches are still imported.
+func Import(mdb moviedb.MovieDB, rows []*Row, options Options) (*Report, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if options.MinSimilarity <= 0 {
+		options.MinSimilarity = 0.85
+	}
+
+	if err := matchLanguages(mdb, rows); err != nil {
+		return nil, err
+	}
+
+	// the collection is read once for all rows, nothing is saved before all of them are checked
+	checker := mdb.NewChecker()
+	report := &Report{DryRun: options.DryRun, Rows: len(rows), Results: []*RowResult{}}
+	accepted := []*Row{}
+	for _, row := range rows {
+		result, err := check(checker, row, accepted, options)
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, result)
+		if result.Status == StatusValid {
+			accepted = append(accepted, row)
+		}
+	}
+
+	if !options.DryRun {
+		batch := []*RowResult{}
+		movies := []*moviedb.Movie{}
+		for i, row := range rows {
+			if report.Results[i].Status != StatusValid {
+				continue
+			}
+			batch = append(batch, report.Results[i])
+			movies = append(movies, row.Movie)
+			if len(batch) == options.BatchSize {
+				save(mdb, batch, movies)
+				batch, movies = batch[:0], movies[:0]
+			}
+		}
+		if len(batch) > 0 {
+			save(mdb, batch, movies)
+		}
+	}
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case StatusValid:
+			report.Valid++
+		case StatusInvalid:
+			report.Invalid++
+		case StatusDuplicate:
+			report.Duplicates++
+		case StatusMatch:
+			report.Matches++
+		case StatusImported:
+			report.Valid++
+			report.Imported++
+		case StatusFailed:
+			report.Valid++
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// check validates a row and looks for duplicates in the database and among the rows accepted so far.
+// Rows without duplicates are matched against existing movies by title similarity too.
+func check(checker moviedb.Checker, row *Row, accepted []*Row, options Options) (*RowResult, error) {
+	result := &RowResult{Line: row.Line, Title: row.Movie.Title, Status: StatusValid, Errors: row.Errors}
+
+	if err := checker.ValidateMovie(row.Movie); err != nil {
+		verr, ok := err.(*moviedb.ValidationError)
+		if !ok {
+			return nil, err
+		}
+		// fields that could not be read are reported once, not again for their zero value
+		for _, violation := range verr.Violations {
+			if !hasViolation(row.Errors, violation.Field) {
+				result.Errors = append(result.Errors, violation)
+			}
+		}
+	}
+	if len(result.Errors) > 0 {
+		result.Status = StatusInvalid
+		return result, nil
+	}
+	result.Errors = nil
+
+	duplicates, err := checker.FindDuplicates(row.Movie)
+	if err != nil {
+		return nil, err
+	}
+	if len(duplicates) > 0 {
+		result.Duplicates = duplicates
+	}
+	for _, other := range accepted {
+		if moviedb.CompareMovies(row.Movie, other.Movie) != nil {
+			result.DuplicateLines = append(result.DuplicateLines, other.Line)
+		}
+	}
+	if len(result.Duplicates) > 0 || len(result.DuplicateLines) > 0 {
+		if !options.Force {
+			result.Status = StatusDuplicate
+		}
+		return result, nil
+	}
+
+	matches, err := checker.FindSimilar(row.Movie, options.MinSimilarity)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 0 {
+		result.Matches = matches
+		if !options.Force {
+			result.Status = StatusMatch
+		}
+	}
+	return result, nil
+}
+
+func hasViolation(violations []*moviedb.Violation, field string) bool {
+	for _, violation := range violations {
+		if violation.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func save(mdb moviedb.MovieDB, batch []*RowResult, movies []*moviedb.Movie) {
+	fail := func(err error) {
+		message := reportError(err)
+		for i, result := range batch {
+			result.Status, result.Id = StatusFailed, 0
+			movies[i].Id = 0
+			if len(result.Error) == 0 {
+				result.Error = "rolled back: " + message
+			}
+		}
+	}
+
+	tx, err := mdb.BeginTransaction()
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer tx.Rollback()
+
+	for i, movie := range movies {
+		if err := tx.ForceAddMovie(movie); err != nil {
+			batch[i].Error = reportError(err)
+			fail(err)
+			return
+		}
+		batch[i].Id = movie.Id
+	}
+	if err := tx.Commit(); err != nil {
+		fail(err)
+		return
+	}
+	for _, result := range batch {
+		result.Status = StatusImported
+	}
+}
+
+// reportError returns the message of an error for the report. Only errors of moviedb are meant for clients,
+// anything else like a failing SQL statement is logged and reported as a generic failure.
+func reportError(err error) string {
+	switch err.(type) {
+	case *moviedb.ValidationError, *moviedb.DuplicateError, *moviedb.ConflictError, *moviedb.NotFoundError,
+		*moviedb.UnavailableError, *moviedb.BusyError:
+		return err.Error()
+	}
+	log.Error(err)
+	return "could not be saved"
+}
@@ -0,0 +1,473 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	movieTestDbFile     string = "../../_fixtures/test.db"
+	movieTestDbFileCopy string = "../../_fixtures/test_import.db"
+)
+
+func init() {
+	os.Setenv("JCIO_DATABASE_TYPE", "sqlite")
+	os.Setenv("JCIO_DATABASE_URI", fmt.Sprintf("sqlite3://%s", movieTestDbFileCopy))
+}
+
+func resetDatabase() {
+	in, err := os.Open(movieTestDbFile)
+	if err != nil {
+		panic(err)
+	}
+	defer in.Close()
+	out, err := os.Create(movieTestDbFileCopy)
+	if err != nil {
+		panic(err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		panic(err)
+	}
+
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	migration.RunMigrations("../../migrations", adapter)
+}
+
+const moviesCSV = `Name;Released;Kind;Cast;Genre;Minutes
+Mad Max 2;1981;DVD;Mel Gibson, Bruce Spence;Action, Sci-Fi;95
+"Argo";2012;BluRay;Ben Affleck;Thriller;120
+Mad Max 3;eighty-five;DVD;Mel Gibson;Action;107
+;1999;DVD;;;
+Mad Max: Fury Road;2015;BluRay;Tom Hardy, Charlize Theron;Action;120
+Mad Max 2;1981;DVD;Mel Gibson;Action;95
+`
+
+func readMovies(t *testing.T) []*Row {
+	mapping, err := ParseMapping("title:Name", "year:Released", "type:Kind", "actors:Cast", "genres:Genre", "length:Minutes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadCSV(strings.NewReader(moviesCSV), CSVOptions{Separator: ';', Delimiter: ",", Mapping: mapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func Test_Importer_ReadCSV(t *testing.T) {
+	rows := readMovies(t)
+	if assert.Equal(t, 6, len(rows)) {
+		assert.Equal(t, 2, rows[0].Line)
+		assert.Equal(t, "Mad Max 2", rows[0].Movie.Title)
+		assert.Equal(t, 1981, rows[0].Movie.Year)
+		assert.Equal(t, 95, rows[0].Movie.Length)
+		assert.Equal(t, 1, rows[0].Movie.Disks)
+		assert.Equal(t, "DVD", rows[0].Movie.Type)
+		assert.Equal(t, []*moviedb.Person{{Name: "Mel Gibson"}, {Name: "Bruce Spence"}}, rows[0].Movie.Actors)
+		assert.Equal(t, []*moviedb.Genre{{Name: "Action"}, {Name: "Sci-Fi"}}, rows[0].Movie.Genres)
+		assert.Equal(t, 0, len(rows[0].Errors))
+
+		assert.Equal(t, "Argo", rows[1].Movie.Title)
+		assert.Equal(t, []*moviedb.Violation{{Field: "year", Rule: "format", Message: "must be a number"}}, rows[2].Errors)
+	}
+
+	// mapped columns have to exist, unknown fields can not be mapped
+	_, err := ReadCSV(strings.NewReader(moviesCSV), CSVOptions{Separator: ';', Mapping: Mapping{"title": "Titel"}})
+	assert.EqualError(t, err, "Column Titel mapped to title not found")
+	_, err = ParseMapping("name:Name")
+	assert.EqualError(t, err, "Invalid mapping field: name, must be one of: "+strings.Join(Fields, ", "))
+	_, err = ParseMapping("title")
+	assert.EqualError(t, err, "Invalid mapping: title, must be field:column")
+
+	// without mapping, columns named like fields are used
+	rows, err = ReadCSV(strings.NewReader("title,year,directors\nArgo,2012,Ben Affleck|Someone Else\n"), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2012, rows[0].Movie.Year)
+	assert.Equal(t, []*moviedb.Person{{Name: "Ben Affleck"}, {Name: "Someone Else"}}, rows[0].Movie.Directors)
+
+	_, err = ReadCSV(strings.NewReader("name,year\nArgo,2012\n"), CSVOptions{})
+	assert.EqualError(t, err, "No column for title found, map one with title:<column>")
+}
+
+func Test_Importer_CSVWriter(t *testing.T) {
+	resetDatabase()
+	mdb := moviedb.NewMovieDB(database.NewAdapter())
+
+	movie, err := mdb.GetMovie("914")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writer := NewCSVWriter(&buf, CSVOptions{Separator: ';', Mapping: Mapping{"title": "Name"}})
+	if err := writer.Write(movie); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(buf.String(), "Name;alttitles;year;description;format;length;region;rating;disks;score;picture;type;acquired_at;languages;genres;actors;directors\n"))
+
+	// reading the export again gives the same movie, without ids
+	rows, err := ReadCSV(&buf, CSVOptions{Separator: ';', Mapping: Mapping{"title": "Name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(rows)) {
+		read := rows[0].Movie
+		assert.Equal(t, 0, len(rows[0].Errors))
+		assert.Equal(t, movie.Title, read.Title)
+		assert.Equal(t, movie.Year, read.Year)
+		assert.Equal(t, movie.Description, read.Description)
+		assert.Equal(t, movie.Format, read.Format)
+		assert.Equal(t, movie.Length, read.Length)
+		assert.Equal(t, movie.Region, read.Region)
+		assert.Equal(t, movie.Rating, read.Rating)
+		assert.Equal(t, movie.Disks, read.Disks)
+		assert.Equal(t, movie.Score, read.Score)
+		assert.Equal(t, movie.Picture, read.Picture)
+		assert.Equal(t, movie.Type, read.Type)
+		assert.Equal(t, movie.AcquiredAt.UTC(), read.AcquiredAt.UTC())
+		assert.Equal(t, len(movie.Alttitles), len(read.Alttitles))
+		assert.Equal(t, len(movie.Languages), len(read.Languages))
+		assert.Equal(t, len(movie.Genres), len(read.Genres))
+		assert.Equal(t, len(movie.Directors), len(read.Directors))
+		for i, actor := range movie.Actors {
+			assert.Equal(t, actor.Name, read.Actors[i].Name)
+		}
+	}
+
+	// the header is written even without movies
+	buf.Reset()
+	if err := NewCSVWriter(&buf, CSVOptions{}).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.Join(Fields, ",")+"\n", buf.String())
+}
+
+func Test_Importer_DryRun(t *testing.T) {
+	resetDatabase()
+	mdb := moviedb.NewMovieDB(database.NewAdapter())
+
+	report, err := Import(mdb, readMovies(t), Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, true, report.DryRun)
+	assert.Equal(t, 6, report.Rows)
+	assert.Equal(t, 2, report.Valid)
+	assert.Equal(t, 2, report.Invalid)
+	assert.Equal(t, 2, report.Duplicates)
+	assert.Equal(t, 0, report.Imported)
+
+	statuses := []string{}
+	for _, result := range report.Results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []string{StatusValid, StatusDuplicate, StatusInvalid, StatusInvalid, StatusValid, StatusDuplicate}, statuses)
+
+	// duplicates of existing movies and of earlier rows
+	assert.Equal(t, 914, report.Results[1].Duplicates[0].Id)
+	assert.Equal(t, []int{2}, report.Results[5].DuplicateLines)
+
+	// unreadable values are reported once, not again as out of range
+	assert.Equal(t, 1, len(report.Results[2].Errors))
+	assert.Equal(t, "title", report.Results[3].Errors[0].Field)
+
+	// nothing was saved
+	movies, err := mdb.GetMovieListings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 912, len(movies))
+}
+
+func Test_Importer_Import(t *testing.T) {
+	resetDatabase()
+	mdb := moviedb.NewMovieDB(database.NewAdapter())
+
+	report, err := Import(mdb, readMovies(t), Options{BatchSize: 1, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, report.DryRun)
+	assert.Equal(t, 4, report.Imported)
+	assert.Equal(t, 2, report.Invalid)
+	assert.Equal(t, 0, report.Duplicates)
+	assert.Equal(t, 0, report.Failed)
+
+	ids := []int{}
+	for _, result := range report.Results {
+		ids = append(ids, result.Id)
+	}
+	assert.Equal(t, []int{915, 916, 0, 0, 917, 918}, ids)
+
+	movie, err := mdb.GetMovie("915")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Mad Max 2", movie.Title)
+	assert.Equal(t, 2, len(movie.Actors))
+	assert.Equal(t, 2, len(movie.Genres))
+
+	// without force, everything is a duplicate now
+	report, err = Import(mdb, readMovies(t), Options{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 4, report.Duplicates)
+
+	movies, err := mdb.GetMovieListings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 916, len(movies))
+}
+
+func Test_Importer_ImportFailure(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	mdb := moviedb.NewMovieDB(adapter)
+
+	if _, err := adapter.Database.Exec(`drop table movie_link_genre`); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Import(mdb, readMovies(t), Options{BatchSize: 2, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 4, report.Failed)
+	assert.Equal(t, "could not be saved", report.Results[0].Error)
+	assert.Equal(t, "rolled back: could not be saved", report.Results[1].Error)
+	for _, result := range report.Results {
+		assert.NotContains(t, result.Error, "movie_link_genre")
+	}
+}
+
+func Test_Importer_NewImporter(t *testing.T) {
+	for _, format := range Formats {
+		_, err := NewImporter(format, FormatOptions{})
+		assert.NoError(t, err)
+	}
+	_, err := NewImporter("xls", FormatOptions{})
+	assert.EqualError(t, err, "Invalid format: xls, must be one of: "+strings.Join(Formats, ", "))
+}
+
+const dvdProfilerXML = `<?xml version="1.0" encoding="windows-1252"?>
+<Collection>
+	<DVD>
+		<Title>Heat</Title>
+		<OriginalTitle>Heat</OriginalTitle>
+		<ProductionYear>1995</ProductionYear>
+		<RunningTime>164</RunningTime>
+		<Rating>FSK 16</Rating>
+		<Overview>A group of professional bank robbers...</Overview>
+		<MediaTypes><DVD>False</DVD><HDDVD>False</HDDVD><BluRay>True</BluRay></MediaTypes>
+		<Format><FormatAspectRatio>2.35</FormatAspectRatio><Format16X9>True</Format16X9></Format>
+		<Regions><Region>B</Region></Regions>
+		<Genres><Genre>Action</Genre><Genre>Thriller</Genre></Genres>
+		<Audio>
+			<AudioTrack><AudioContent>English</AudioContent><AudioFormat>DTS-HD</AudioFormat></AudioTrack>
+			<AudioTrack><AudioContent>German</AudioContent><AudioFormat>Dolby Digital</AudioFormat></AudioTrack>
+			<AudioTrack><AudioContent>English</AudioContent><AudioFormat>Commentary</AudioFormat></AudioTrack>
+		</Audio>
+		<Actors>
+			<Actor FirstName="Al" MiddleName="" LastName="Pacino" Role="Vincent Hanna"/>
+			<Divider Caption="Crew"/>
+			<Actor FirstName="Robert" MiddleName="" LastName="De Niro" Role="Neil McCauley"/>
+		</Actors>
+		<Credits>
+			<Credit FirstName="Michael" MiddleName="" LastName="Mann" CreditType="Direction" CreditSubtype="Director"/>
+			<Credit FirstName="Michael" MiddleName="" LastName="Mann" CreditType="Writing" CreditSubtype="Screenwriter"/>
+		</Credits>
+		<Discs><Disc><DescriptionSideA>Movie</DescriptionSideA></Disc><Disc><DescriptionSideA>Extras</DescriptionSideA></Disc></Discs>
+		<PurchaseInfo><PurchaseDate>2010-03-14</PurchaseDate></PurchaseInfo>
+	</DVD>
+	<DVD>
+		<Title>Der Untergang</Title>
+		<OriginalTitle>Downfall</OriginalTitle>
+		<ProductionYear>unknown</ProductionYear>
+		<Rating>R</Rating>
+		<MediaTypes><DVD>True</DVD><BluRay>False</BluRay></MediaTypes>
+	</DVD>
+</Collection>
+`
+
+func Test_Importer_DVDProfiler(t *testing.T) {
+	importer, _ := NewImporter("dvdprofiler", FormatOptions{})
+	rows, err := importer.Read(strings.NewReader(dvdProfilerXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 2, len(rows)) {
+		heat := rows[0].Movie
+		assert.Equal(t, 3, rows[0].Line)
+		assert.Equal(t, "Heat", heat.Title)
+		assert.Equal(t, 1995, heat.Year)
+		assert.Equal(t, 164, heat.Length)
+		assert.Equal(t, 16, heat.Rating)
+		assert.Equal(t, 2, heat.Disks)
+		assert.Equal(t, "BluRay", heat.Type)
+		assert.Equal(t, "16:9", heat.Format)
+		assert.Equal(t, "B", heat.Region)
+		assert.Equal(t, "2010-03-14", heat.AcquiredAt.Format("2006-01-02"))
+		assert.Equal(t, 0, len(heat.Alttitles))
+		assert.Equal(t, []*moviedb.Language{{Name: "English"}, {Name: "German"}}, heat.Languages)
+		assert.Equal(t, []*moviedb.Genre{{Name: "Action"}, {Name: "Thriller"}}, heat.Genres)
+		assert.Equal(t, []*moviedb.Person{{Name: "Al Pacino"}, {Name: "Robert De Niro"}}, heat.Actors)
+		assert.Equal(t, []*moviedb.Person{{Name: "Michael Mann"}}, heat.Directors)
+		assert.Equal(t, 0, len(rows[0].Errors))
+
+		downfall := rows[1].Movie
+		assert.Equal(t, 31, rows[1].Line)
+		assert.Equal(t, "DVD", downfall.Type)
+		assert.Equal(t, 0, downfall.Rating)
+		assert.Equal(t, 1, downfall.Disks)
+		assert.Equal(t, []*moviedb.AlternateTitle{{Title: "Downfall", Type: moviedb.AlttitleOriginal}}, downfall.Alttitles)
+		assert.Equal(t, []*moviedb.Violation{{Field: "year", Rule: "format", Message: "must be a number"}}, rows[1].Errors)
+	}
+
+	// exports are written in windows-1252
+	rows, err = importer.Read(strings.NewReader("<?xml version=\"1.0\" encoding=\"windows-1252\"?>\n" +
+		"<Collection><DVD><Title>Sie nannten ihn M\xfccke \x96 Teil 1</Title></DVD></Collection>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Sie nannten ihn Mücke – Teil 1", rows[0].Movie.Title)
+	assert.Equal(t, "DVD", rows[0].Movie.Type)
+
+	// the disk type option is used without media types
+	importer, _ = NewImporter("dvdprofiler", FormatOptions{Type: "BluRay"})
+	rows, err = importer.Read(strings.NewReader("<Collection>\n<DVD><Title>Heat</Title></DVD>\n" +
+		"<DVD><Title>Argo</Title><MediaTypes><DVD>True</DVD></MediaTypes></DVD></Collection>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 2, len(rows)) {
+		assert.Equal(t, "BluRay", rows[0].Movie.Type)
+		assert.Equal(t, 2, rows[0].Line)
+		assert.Equal(t, "DVD", rows[1].Movie.Type)
+		assert.Equal(t, 3, rows[1].Line)
+	}
+
+	_, err = importer.Read(strings.NewReader("<Collection><DVD>"))
+	assert.Error(t, err)
+}
+
+func Test_Importer_MatchLanguages(t *testing.T) {
+	resetDatabase()
+	mdb := moviedb.NewMovieDB(database.NewAdapter())
+
+	importer, _ := NewImporter("dvdprofiler", FormatOptions{})
+	rows, err := importer.Read(strings.NewReader(dvdProfilerXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows[0].Movie.Languages = append(rows[0].Movie.Languages,
+		&moviedb.Language{Name: "Englisch"}, &moviedb.Language{Name: "french"}, &moviedb.Language{Name: "Klingon"})
+	if err := matchLanguages(mdb, rows[:1]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []*moviedb.Language{
+		{Id: 2, Name: "Englisch", Country: "USA", NativeName: "English"},
+		{Id: 1, Name: "Deutsch", Country: "Schweiz", NativeName: "Deutsch"},
+		{Id: 3, Name: "Franz&#246;sisch", Country: "Frankreich", NativeName: "Fran&#231;ais"},
+		{Name: "Klingon"},
+	}, rows[0].Movie.Languages)
+}
+
+func Test_Importer_Letterboxd(t *testing.T) {
+	importer, _ := NewImporter("letterboxd", FormatOptions{Type: "BluRay"})
+	rows, err := importer.Read(strings.NewReader("Date,Name,Year,Letterboxd URI,Rating\n" +
+		"2020-01-01,Argo,2012,https://boxd.it/2YJa,4.5\n" +
+		"2020-01-02,\"Crouching Tiger, Hidden Dragon\",2000,https://boxd.it/1Zvy,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 2, len(rows)) {
+		assert.Equal(t, 2, rows[0].Line)
+		assert.Equal(t, "Argo", rows[0].Movie.Title)
+		assert.Equal(t, 2012, rows[0].Movie.Year)
+		assert.Equal(t, 5, rows[0].Movie.Score)
+		assert.Equal(t, "BluRay", rows[0].Movie.Type)
+		assert.Equal(t, "Crouching Tiger, Hidden Dragon", rows[1].Movie.Title)
+		assert.Equal(t, 0, rows[1].Movie.Score)
+	}
+
+	_, err = importer.Read(strings.NewReader("Title,Year\nArgo,2012\n"))
+	assert.EqualError(t, err, "No Name column found, not a Letterboxd export")
+}
+
+const movieList = `# a friend's collection
+- The Last Samurrai (2003)
+1. Face/Off (1997)
+Some Movie Nobody Has Ever Heard Of (1999)
+
+Untitled
+`
+
+func Test_Importer_List(t *testing.T) {
+	resetDatabase()
+	mdb := moviedb.NewMovieDB(database.NewAdapter())
+
+	importer, _ := NewImporter("list", FormatOptions{})
+	rows, err := importer.Read(strings.NewReader(movieList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 4, len(rows)) {
+		assert.Equal(t, 2, rows[0].Line)
+		assert.Equal(t, "The Last Samurrai", rows[0].Movie.Title)
+		assert.Equal(t, 2003, rows[0].Movie.Year)
+		assert.Equal(t, "DVD", rows[0].Movie.Type)
+		assert.Equal(t, "Face/Off", rows[1].Movie.Title)
+		assert.Equal(t, 6, rows[3].Line)
+		assert.Equal(t, "Untitled", rows[3].Movie.Title)
+		assert.Equal(t, 0, rows[3].Movie.Year)
+	}
+
+	// the preview shows exact duplicates and similar titles, before anything is saved
+	report, err := Import(mdb, rows, Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{}
+	for _, result := range report.Results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []string{StatusMatch, StatusDuplicate, StatusValid, StatusInvalid}, statuses)
+	assert.Equal(t, 1, report.Matches)
+	assert.Equal(t, 1, report.Duplicates)
+	assert.Equal(t, 1, report.Valid)
+	if assert.Equal(t, 2, len(report.Results[0].Matches)) {
+		assert.Equal(t, 181, report.Results[0].Matches[0].Id)
+		assert.Equal(t, "The Last Samurai", report.Results[0].Matches[0].Title)
+	}
+	assert.Equal(t, 0, len(report.Results[1].Matches))
+
+	// forced imports keep the matches for reference
+	report, err = Import(mdb, rows[:1], Options{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusImported, report.Results[0].Status)
+	assert.Equal(t, 2, len(report.Results[0].Matches))
+}
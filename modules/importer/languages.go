@@ -0,0 +1,85 @@
+package importer
+
+import (
+	"html"
+	"strings"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+// nativeNames maps English language names, like DVD Profiler and most other tools write them,
+// to the native names of the languages in movie_language, whose names are in German
+var nativeNames = map[string]string{
+	"english":    "English",
+	"german":     "Deutsch",
+	"french":     "Français",
+	"spanish":    "Español",
+	"chinese":    "Zhōngwén",
+	"mandarin":   "Zhōngwén",
+	"japanese":   "Nihongo",
+	"italian":    "Italiano",
+	"dutch":      "Nederlands",
+	"czech":      "Česká",
+	"hungarian":  "Magyar",
+	"thai":       "Phasa Thai",
+	"turkish":    "Türkçe",
+	"russian":    "Russkiy",
+	"portuguese": "Português",
+	"polish":     "Polski",
+	"korean":     "Hangul",
+	"hindi":      "Hindi",
+	"slovak":     "Slovencina",
+	"norwegian":  "Norsk",
+	"swedish":    "Svenska",
+	"lithuanian": "lietuviu kalba",
+}
+
+// matchLanguages replaces languages of rows by existing ones with the same name or native name, or whose native name
+// is known for an English name. An "English" audio track is linked to "Englisch" then, instead of creating a language.
+func matchLanguages(mdb moviedb.MovieDB, rows []*Row) error {
+	languages, err := mdb.GetLanguages()
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		matched := []*moviedb.Language{}
+		seen := map[int]bool{}
+		for _, language := range row.Movie.Languages {
+			if language.Id == 0 {
+				if existing := findLanguage(languages, language.Name); existing != nil {
+					copied := *existing
+					language = &copied
+				}
+			}
+			if language.Id > 0 {
+				if seen[language.Id] {
+					continue
+				}
+				seen[language.Id] = true
+			}
+			matched = append(matched, language)
+		}
+		row.Movie.Languages = matched
+	}
+	return nil
+}
+
+func findLanguage(languages []*moviedb.Language, name string) *moviedb.Language {
+	name = strings.TrimSpace(name)
+	names := []string{name}
+	if native, ok := nativeNames[strings.ToLower(name)]; ok {
+		names = append(names, native)
+	}
+
+	// names in movie_language are HTML escaped, like "Franz&#246;sisch"
+	for _, language := range languages {
+		for _, candidate := range names {
+			if strings.EqualFold(html.UnescapeString(language.Name), candidate) ||
+				strings.EqualFold(html.UnescapeString(language.NativeName), candidate) {
+				return language
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+// letterboxdImporter reads the CSV files of a Letterboxd export, like watched.csv, ratings.csv or diary.csv.
+// They have Name and Year columns, and Rating in stars from 0.5 to 5 for some of them.
+type letterboxdImporter struct {
+	diskType string
+}
+
+func (l *letterboxdImporter) Read(r io.Reader) ([]*Row, error) {
+	reader := newCSVReader(r, ',')
+	reader.fields = -1
+
+	header, _, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("CSV is empty, a header line is required")
+		}
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.TrimSpace(column)] = i
+	}
+	if _, ok := index["Name"]; !ok {
+		return nil, fmt.Errorf("No Name column found, not a Letterboxd export")
+	}
+
+	rows := []*Row{}
+	for {
+		record, line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := &Row{Line: line, Errors: []*moviedb.Violation{}, Movie: &moviedb.Movie{
+			Title:     value("Name"),
+			Type:      l.diskType,
+			Disks:     1,
+			Alttitles: []*moviedb.AlternateTitle{},
+			Languages: []*moviedb.Language{},
+			Genres:    []*moviedb.Genre{},
+			Actors:    []*moviedb.Person{},
+			Directors: []*moviedb.Person{},
+		}}
+		if v := value("Year"); len(v) > 0 {
+			year, err := strconv.Atoi(v)
+			if err != nil {
+				row.Errors = append(row.Errors, &moviedb.Violation{Field: "year", Rule: "format", Message: "must be a number"})
+			}
+			row.Movie.Year = year
+		}
+		if v := value("Rating"); len(v) > 0 {
+			stars, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				row.Errors = append(row.Errors, &moviedb.Violation{Field: "score", Rule: "format", Message: "must be a number"})
+			}
+			row.Movie.Score = int(math.Round(stars))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
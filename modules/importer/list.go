@@ -0,0 +1,53 @@
+package importer
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/moviedb"
+)
+
+var (
+	listTitle  = regexp.MustCompile(`^(.*?)\s*\((\d{4})\)$`)
+	listMarker = regexp.MustCompile(`^([-*]|\d+[.)])\s+`)
+)
+
+// listImporter reads plain text lists with a movie per line, like "Argo (2012)".
+// Years are optional, list markers like "-" or "1." and empty lines or lines starting with # are skipped.
+type listImporter struct {
+	diskType string
+}
+
+func (l *listImporter) Read(r io.Reader) ([]*Row, error) {
+	scanner := bufio.NewScanner(r)
+	rows := []*Row{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = listMarker.ReplaceAllString(text, "")
+
+		movie := &moviedb.Movie{
+			Title:     text,
+			Type:      l.diskType,
+			Disks:     1,
+			Alttitles: []*moviedb.AlternateTitle{},
+			Languages: []*moviedb.Language{},
+			Genres:    []*moviedb.Genre{},
+			Actors:    []*moviedb.Person{},
+			Directors: []*moviedb.Person{},
+		}
+		if match := listTitle.FindStringSubmatch(text); match != nil {
+			movie.Title = match[1]
+			movie.Year, _ = strconv.Atoi(match[2])
+		}
+		rows = append(rows, &Row{Line: line, Movie: movie, Errors: []*moviedb.Violation{}})
+	}
+	return rows, scanner.Err()
+}
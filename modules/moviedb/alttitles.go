@@ -0,0 +1,115 @@
+package moviedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	AlttitleOriginal  = "original"
+	AlttitleLocalized = "localized"
+	AlttitleWorking   = "working"
+	AlttitleShort     = "short"
+)
+
+var AlttitleTypes = []string{AlttitleOriginal, AlttitleLocalized, AlttitleWorking, AlttitleShort}
+
+func (mdb *movieDB) GetAlttitlesByMovie(id string) ([]*AlternateTitle, error) {
+	stmt, err := mdb.Prepare(`
+		select ma.id, ma.title, ma.type, ml.id, ml.name, ml.country, ml.native_name
+		from movie_alttitle ma
+		left join movie_language ml on (ml.id = ma.language_id)
+		where ma.movie_id = $1
+		order by ma.id asc`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	as := []*AlternateTitle{}
+	for rows.Next() {
+		var a AlternateTitle
+		var languageId sql.NullInt64
+		var name, country, nativeName sql.NullString
+		if err := rows.Scan(&a.Id, &a.Title, &a.Type, &languageId, &name, &country, &nativeName); err != nil {
+			return nil, err
+		}
+		if languageId.Valid {
+			a.Language = &Language{int(languageId.Int64), name.String, country.String, nativeName.String}
+		}
+		as = append(as, &a)
+	}
+	return as, nil
+}
+
+// legacyAlttitle returns the first alternate title, as it used to be stored in movie_movie.alttitle
+func legacyAlttitle(alttitles []*AlternateTitle) sql.NullString {
+	if len(alttitles) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: alttitles[0].Title, Valid: true}
+}
+
+func saveAlttitles(tx *sql.Tx, movie *Movie) error {
+	// clients not knowing about alttitles only send the legacy alttitle field
+	if movie.Alttitles == nil {
+		movie.Alttitles = []*AlternateTitle{}
+		if movie.Alttitle.Valid && len(movie.Alttitle.String) > 0 {
+			movie.Alttitles = append(movie.Alttitles, &AlternateTitle{Title: movie.Alttitle.String})
+		}
+	}
+
+	// alttitles are always replaced as a whole
+	if _, err := tx.Exec(`delete from movie_alttitle where movie_id = $1`, movie.Id); err != nil {
+		return err
+	}
+
+	for _, alttitle := range movie.Alttitles {
+		if len(alttitle.Title) == 0 {
+			return errors.New("alttitle must not be empty")
+		}
+		if len(alttitle.Type) == 0 {
+			alttitle.Type = AlttitleLocalized
+		}
+		if !isAlttitleType(alttitle.Type) {
+			return fmt.Errorf("invalid alttitle type: %s", alttitle.Type)
+		}
+
+		var languageId sql.NullInt64
+		if alttitle.Language != nil {
+			if err := saveLanguage(tx, alttitle.Language); err != nil {
+				return err
+			}
+			languageId = sql.NullInt64{Int64: int64(alttitle.Language.Id), Valid: true}
+		}
+
+		stmt, err := tx.Prepare(`INSERT INTO movie_alttitle (movie_id, title, language_id, type) VALUES ($1,$2,$3,$4)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		if _, err := stmt.Exec(movie.Id, alttitle.Title, languageId, alttitle.Type); err != nil {
+			return err
+		}
+	}
+	movie.Alttitle = legacyAlttitle(movie.Alttitles)
+
+	return nil
+}
+
+func isAlttitleType(value string) bool {
+	for _, t := range AlttitleTypes {
+		if t == value {
+			return true
+		}
+	}
+	return false
+}
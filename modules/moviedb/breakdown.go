@@ -0,0 +1,160 @@
+package moviedb
+
+import (
+	"database/sql"
+	"fmt"
+	gosort "sort"
+	"strconv"
+)
+
+var (
+	BreakdownDimensions = []string{"genre", "language", "decade", "director", "actor", "format"}
+	BreakdownSorts      = []string{"count", "score", "rating", "length", "name"}
+)
+
+// breakdownValues are summed up per group. Missing lengths count as 0,
+// missing scores and ratings are left out of the averages instead of dragging them down.
+const breakdownValues = `mm.score, mm.rating, coalesce(mm.length, 0)`
+
+// breakdownQueries select the key and name of the group each movie belongs to, along with its values.
+// Movies can be in several groups, like genres or actors. Movies without a year or format are grouped under an empty key.
+var breakdownQueries = map[string]string{
+	"genre": `select mg.id, mg.name, ` + breakdownValues + ` from movie_movie mm
+		join movie_link_genre mlg on (mlg.movie_id = mm.id) join movie_genre mg on (mg.id = mlg.genre_id)`,
+	"language": `select ml.id, ml.name, ` + breakdownValues + ` from movie_movie mm
+		join movie_link_language mll on (mll.movie_id = mm.id) join movie_language ml on (ml.id = mll.language_id)`,
+	"decade": `select coalesce(cast((mm.year / 10) * 10 as varchar(4)), ''), '', ` + breakdownValues + ` from movie_movie mm`,
+	"director": `select mp.id, mp.name, ` + breakdownValues + ` from movie_movie mm
+		join movie_link_director mld on (mld.movie_id = mm.id) join movie_people mp on (mp.id = mld.person_id)`,
+	"actor": `select mp.id, mp.name, ` + breakdownValues + ` from movie_movie mm
+		join movie_link_actor mla on (mla.movie_id = mm.id) join movie_people mp on (mp.id = mla.person_id)`,
+	"format": `select coalesce(mm.format, ''), coalesce(mf.name, mm.format, ''), ` + breakdownValues + ` from movie_movie mm
+		left join movie_format mf on (mf.code = mm.format)`,
+}
+
+type BreakdownOptions struct {
+	By    string
+	Sort  string
+	Order string
+	Top   int // 0 returns all groups
+}
+
+// GetBreakdown groups all movies by a dimension and sums them up per group.
+// Groups are sorted by count, score, rating, length or name, ties are sorted by name.
+func (mdb *movieDB) GetBreakdown(options BreakdownOptions) (*Breakdown, error) {
+	query, ok := breakdownQueries[options.By]
+	if !ok {
+		return nil, fmt.Errorf("unknown breakdown dimension: %s", options.By)
+	}
+
+	rows, err := mdb.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*BreakdownGroup{}
+	byKey := make(map[string]*BreakdownGroup)
+	scores := make(map[string]*average)
+	ratings := make(map[string]*average)
+	for rows.Next() {
+		var key, name string
+		var score, rating sql.NullInt64
+		var length int
+		if err := rows.Scan(&key, &name, &score, &rating, &length); err != nil {
+			return nil, err
+		}
+
+		g, ok := byKey[key]
+		if !ok {
+			g = &BreakdownGroup{Key: key, Name: name}
+			if options.By == "decade" && len(key) > 0 {
+				g.Name = key + "s"
+			}
+			groups = append(groups, g)
+			byKey[key] = g
+			scores[key] = &average{}
+			ratings[key] = &average{}
+		}
+		g.Count++
+		g.TotalLength += length
+		scores[key].add(score)
+		ratings[key].add(rating)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		g.AvgScore = scores[g.Key].value()
+		g.AvgRating = ratings[g.Key].value()
+	}
+
+	gosort.Sort(breakdownGroups{groups, options.By, options.Sort, options.Order == "desc"})
+	if options.Top > 0 && len(groups) > options.Top {
+		groups = groups[:options.Top]
+	}
+
+	return &Breakdown{By: options.By, Sort: options.Sort, Order: options.Order, Groups: groups}, nil
+}
+
+// average sums up the values present, groups without any have an average of 0
+type average struct {
+	sum   int64
+	count int
+}
+
+func (a *average) add(value sql.NullInt64) {
+	if value.Valid {
+		a.sum += value.Int64
+		a.count++
+	}
+}
+
+func (a *average) value() float64 {
+	if a.count == 0 {
+		return 0
+	}
+	return round(float64(a.sum)/float64(a.count), 2)
+}
+
+type breakdownGroups struct {
+	groups []*BreakdownGroup
+	by     string
+	sort   string
+	desc   bool
+}
+
+func (b breakdownGroups) Len() int      { return len(b.groups) }
+func (b breakdownGroups) Swap(i, j int) { b.groups[i], b.groups[j] = b.groups[j], b.groups[i] }
+func (b breakdownGroups) Less(i, j int) bool {
+	x, y := b.groups[i], b.groups[j]
+	var a, c float64
+	switch b.sort {
+	case "count":
+		a, c = float64(x.Count), float64(y.Count)
+	case "score":
+		a, c = x.AvgScore, y.AvgScore
+	case "rating":
+		a, c = x.AvgRating, y.AvgRating
+	case "length":
+		a, c = float64(x.TotalLength), float64(y.TotalLength)
+	}
+	if a != c {
+		return (a < c) != b.desc
+	}
+	return b.lessByName(x, y) != (b.sort == "name" && b.desc)
+}
+
+// lessByName compares decades numerically, all other groups by their name
+func (b breakdownGroups) lessByName(x, y *BreakdownGroup) bool {
+	if b.by == "decade" {
+		a, _ := strconv.Atoi(x.Key)
+		c, _ := strconv.Atoi(y.Key)
+		return a < c
+	}
+	if x.Name == y.Name {
+		return x.Key < y.Key
+	}
+	return x.Name < y.Name
+}
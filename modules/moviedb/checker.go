@@ -0,0 +1,78 @@
+package moviedb
+
+import "database/sql"
+
+// Checker validates movies and looks for duplicates like MovieDB does, but reads the collection only once,
+// the first time it is needed. Checking many movies at once, like an import does, stays cheap that way.
+// Movies saved after that are not seen, and a Checker is not safe for concurrent use.
+type Checker interface {
+	ValidateMovie(*Movie) error
+	FindDuplicates(*Movie) ([]*Duplicate, error)
+	FindSimilar(movie *Movie, minSimilarity float64) ([]*Duplicate, error)
+}
+
+// querier is implemented by both movieDB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type movieChecker struct {
+	q          querier
+	signatures []*movieSignature
+	codes      map[string][]*ReferenceCode // reference table -> its codes
+	languages  map[int]bool
+}
+
+func (mdb *movieDB) NewChecker() Checker {
+	return newChecker(mdb)
+}
+
+func newChecker(q querier) *movieChecker {
+	return &movieChecker{q: q, codes: make(map[string][]*ReferenceCode)}
+}
+
+func (c *movieChecker) getSignatures() ([]*movieSignature, error) {
+	if c.signatures == nil {
+		signatures, err := getSignatures(c.q)
+		if err != nil {
+			return nil, err
+		}
+		c.signatures = signatures
+	}
+	return c.signatures, nil
+}
+
+func (c *movieChecker) referenceCodes(table string) ([]*ReferenceCode, error) {
+	if _, ok := c.codes[table]; !ok {
+		codes, err := getReferenceCodes(c.q, table)
+		if err != nil {
+			return nil, err
+		}
+		c.codes[table] = codes
+	}
+	return c.codes[table], nil
+}
+
+func (c *movieChecker) languageIds() (map[int]bool, error) {
+	if c.languages == nil {
+		rows, err := c.q.Query(`select id from movie_language`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		languages := make(map[int]bool)
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			languages[id] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		c.languages = languages
+	}
+	return c.languages, nil
+}
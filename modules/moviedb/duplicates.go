@@ -0,0 +1,338 @@
+package moviedb
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	gosort "sort"
+	"strings"
+)
+
+var (
+	htmlTags = regexp.MustCompile(`<[^>]*>`)
+	nonAlnum = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	articles = []string{"the ", "a ", "an ", "der ", "die ", "das "}
+)
+
+type DuplicateError struct {
+	Candidates []*Duplicate
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("movie might be a duplicate of %d existing movie(s)", len(e.Candidates))
+}
+
+// movieSignature holds everything needed to compare two movies for duplicates
+type movieSignature struct {
+	id        int
+	title     string
+	year      int
+	titles    []string
+	directors []string
+}
+
+// NormalizeTitle strips markup, punctuation, case and leading articles from a title
+func NormalizeTitle(title string) string {
+	t := html.UnescapeString(htmlTags.ReplaceAllString(title, " "))
+	t = strings.TrimSpace(nonAlnum.ReplaceAllString(strings.ToLower(t), " "))
+	for _, article := range articles {
+		if strings.HasPrefix(t, article) {
+			return t[len(article):]
+		}
+	}
+	return t
+}
+
+func signatureOf(movie *Movie) *movieSignature {
+	s := &movieSignature{id: movie.Id, title: movie.Title, year: movie.Year}
+	s.titles = append(s.titles, NormalizeTitle(movie.Title))
+	for _, alttitle := range movie.Alttitles {
+		s.titles = append(s.titles, NormalizeTitle(alttitle.Title))
+	}
+	if movie.Alttitles == nil && movie.Alttitle.Valid && len(movie.Alttitle.String) > 0 {
+		s.titles = append(s.titles, NormalizeTitle(movie.Alttitle.String))
+	}
+	for _, director := range movie.Directors {
+		s.directors = append(s.directors, strings.ToLower(director.Name))
+	}
+	return s
+}
+
+// compareSignatures returns the reasons why two movies look like duplicates, or nil if they don't.
+// The title of one movie has to match the title or an alternate title of the other one,
+// and either the year or a director has to match too. Alternate titles are not compared
+// with each other, generic ones like "Season 1" would match all over the place.
+func compareSignatures(a, b *movieSignature) []string {
+	var reasons []string
+	switch {
+	case a.titles[0] == b.titles[0]:
+		reasons = append(reasons, "title")
+	case overlaps(a.titles[:1], b.titles[1:]), overlaps(b.titles[:1], a.titles[1:]):
+		reasons = append(reasons, "alttitle")
+	default:
+		return nil
+	}
+
+	sameYear := a.year == b.year || a.year == 0 || b.year == 0
+	if sameYear {
+		reasons = append(reasons, "year")
+	}
+	sameDirector := overlaps(a.directors, b.directors)
+	if sameDirector {
+		reasons = append(reasons, "director")
+	}
+	if !sameYear && !sameDirector {
+		return nil
+	}
+	return reasons
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		if len(x) == 0 {
+			continue
+		}
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getSignatures(q querier) ([]*movieSignature, error) {
+	rows, err := q.Query(`select id, title, year from movie_movie order by id asc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signatures := []*movieSignature{}
+	byId := make(map[int]*movieSignature)
+	for rows.Next() {
+		var s movieSignature
+		if err := rows.Scan(&s.id, &s.title, &s.year); err != nil {
+			return nil, err
+		}
+		s.titles = []string{NormalizeTitle(s.title)}
+		signatures = append(signatures, &s)
+		byId[s.id] = &s
+	}
+
+	rows1, err := q.Query(`select movie_id, title from movie_alttitle order by id asc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows1.Close()
+
+	for rows1.Next() {
+		var id int
+		var title string
+		if err := rows1.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		if s, ok := byId[id]; ok {
+			s.titles = append(s.titles, NormalizeTitle(title))
+		}
+	}
+
+	rows2, err := q.Query(`select mld.movie_id, mp.name
+		from movie_link_director mld join movie_people mp on (mp.id = mld.person_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows2.Close()
+
+	for rows2.Next() {
+		var id int
+		var name string
+		if err := rows2.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if s, ok := byId[id]; ok {
+			s.directors = append(s.directors, strings.ToLower(name))
+		}
+	}
+
+	return signatures, nil
+}
+
+func (mdb *movieDB) FindDuplicates(movie *Movie) ([]*Duplicate, error) {
+	return mdb.NewChecker().FindDuplicates(movie)
+}
+
+func (c *movieChecker) FindDuplicates(movie *Movie) ([]*Duplicate, error) {
+	signatures, err := c.getSignatures()
+	if err != nil {
+		return nil, err
+	}
+
+	candidate := signatureOf(movie)
+	ds := []*Duplicate{}
+	for _, s := range signatures {
+		if s.id == movie.Id {
+			continue
+		}
+		if reasons := compareSignatures(candidate, s); reasons != nil {
+			ds = append(ds, &Duplicate{Id: s.id, Title: s.title, Year: s.year, Reasons: reasons})
+		}
+	}
+	return ds, nil
+}
+
+func (mdb *movieDB) GetDuplicates() ([]*DuplicatePair, error) {
+	signatures, err := getSignatures(mdb)
+	if err != nil {
+		return nil, err
+	}
+
+	// only movies sharing at least one normalized title can be duplicates
+	byTitle := make(map[string][]*movieSignature)
+	for _, s := range signatures {
+		seen := make(map[string]bool)
+		for _, title := range s.titles {
+			if len(title) > 0 && !seen[title] {
+				byTitle[title] = append(byTitle[title], s)
+				seen[title] = true
+			}
+		}
+	}
+
+	compared := make(map[[2]int]bool)
+	pairs := []*DuplicatePair{}
+	for _, group := range byTitle {
+		for i := range group {
+			for j := i + 1; j < len(group); j++ {
+				a, b := group[i], group[j]
+				if a.id > b.id {
+					a, b = b, a
+				}
+				if compared[[2]int{a.id, b.id}] {
+					continue
+				}
+				compared[[2]int{a.id, b.id}] = true
+
+				if reasons := compareSignatures(a, b); reasons != nil {
+					pairs = append(pairs, &DuplicatePair{
+						Movies: []*Duplicate{
+							&Duplicate{Id: a.id, Title: a.title, Year: a.year},
+							&Duplicate{Id: b.id, Title: b.title, Year: b.year},
+						},
+						Reasons: reasons,
+					})
+				}
+			}
+		}
+	}
+
+	gosort.Sort(duplicatePairs(pairs))
+	return pairs, nil
+}
+
+type duplicatePairs []*DuplicatePair
+
+func (d duplicatePairs) Len() int      { return len(d) }
+func (d duplicatePairs) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d duplicatePairs) Less(i, j int) bool {
+	if d[i].Movies[0].Id == d[j].Movies[0].Id {
+		return d[i].Movies[1].Id < d[j].Movies[1].Id
+	}
+	return d[i].Movies[0].Id < d[j].Movies[0].Id
+}
+
+// CompareMovies returns the reasons why two movies look like duplicates, or nil if they don't
+func CompareMovies(a, b *Movie) []string {
+	return compareSignatures(signatureOf(a), signatureOf(b))
+}
+
+// TitleSimilarity compares two normalized titles, 1 means they are the same and 0 that they have nothing in common
+func TitleSimilarity(a, b string) float64 {
+	return similarity(NormalizeTitle(a), NormalizeTitle(b))
+}
+
+func similarity(a, b string) float64 {
+	x, y := []rune(a), []rune(b)
+	longest := len(x)
+	if len(y) > longest {
+		longest = len(y)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(x, y))/float64(longest)
+}
+
+// editDistance is the Levenshtein distance, the number of runes to insert, delete or replace to turn a into b
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// FindSimilar looks for movies with a title or alternate title at least minSimilarity like the title of a movie,
+// from a year at most one apart. Unlike FindDuplicates it catches typos and slightly different spellings,
+// best matches come first.
+func (mdb *movieDB) FindSimilar(movie *Movie, minSimilarity float64) ([]*Duplicate, error) {
+	return mdb.NewChecker().FindSimilar(movie, minSimilarity)
+}
+
+func (c *movieChecker) FindSimilar(movie *Movie, minSimilarity float64) ([]*Duplicate, error) {
+	signatures, err := c.getSignatures()
+	if err != nil {
+		return nil, err
+	}
+
+	title := NormalizeTitle(movie.Title)
+	ds := []*Duplicate{}
+	for _, s := range signatures {
+		if s.id == movie.Id {
+			continue
+		}
+		if movie.Year != 0 && s.year != 0 && (s.year < movie.Year-1 || s.year > movie.Year+1) {
+			continue
+		}
+
+		best, reason := 0.0, ""
+		for i, t := range s.titles {
+			similar := similarity(title, t)
+			if similar > best {
+				best, reason = similar, "similar_title"
+				if i > 0 {
+					reason = "similar_alttitle"
+				}
+			}
+		}
+		if best == 0 || best < minSimilarity {
+			continue
+		}
+		reasons := []string{reason}
+		if movie.Year == s.year {
+			reasons = append(reasons, "year")
+		}
+		ds = append(ds, &Duplicate{Id: s.id, Title: s.title, Year: s.year, Reasons: reasons, Similarity: best})
+	}
+
+	gosort.SliceStable(ds, func(i, j int) bool { return ds[i].Similarity > ds[j].Similarity })
+	return ds, nil
+}
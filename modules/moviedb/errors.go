@@ -0,0 +1,100 @@
+package moviedb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+)
+
+type NotFoundError struct {
+	Entity string
+	Id     string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Entity, e.Id)
+}
+
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// UnavailableError means the database could not be reached, the original error is kept for logging
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return "database unavailable"
+}
+
+// BusyError means the database is locked by another writer for now, unlike an outage retrying soon is fine
+type BusyError struct {
+	Err error
+}
+
+func (e *BusyError) Error() string {
+	return "database busy, please retry"
+}
+
+// unavailable wraps errors caused by a lost or refused database connection, and sqlite lock contention
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(net.Error); ok {
+		return &UnavailableError{err}
+	}
+	if err == driver.ErrBadConn || err == sql.ErrConnDone {
+		return &UnavailableError{err}
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "database is closed") {
+		return &UnavailableError{err}
+	}
+	if strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked") {
+		return &BusyError{err}
+	}
+	return err
+}
+
+// queries start with one of these, so connection problems surface as *UnavailableError
+
+func (mdb *movieDB) Begin() (*sql.Tx, error) {
+	tx, err := mdb.DB.Begin()
+	return tx, unavailable(err)
+}
+
+func (mdb *movieDB) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := mdb.DB.Prepare(query)
+	return stmt, unavailable(err)
+}
+
+func (mdb *movieDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := mdb.DB.Query(query, args...)
+	return rows, unavailable(err)
+}
+
+func (mdb *movieDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := mdb.DB.Exec(query, args...)
+	return result, unavailable(err)
+}
+
+// QueryRow returns its errors only on Scan, so they are wrapped there
+func (mdb *movieDB) QueryRow(query string, args ...interface{}) *row {
+	return &row{mdb.DB.QueryRow(query, args...)}
+}
+
+type row struct {
+	*sql.Row
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	return unavailable(r.Row.Scan(dest...))
+}
@@ -0,0 +1,54 @@
+package moviedb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ExportMovies calls fn for every movie matching the listing options, with all its relations.
+// Movies are loaded one at a time while going through the result, so memory use does not grow with the collection.
+// Without a sort order movies are exported by id, an error returned by fn stops the export.
+func (mdb *movieDB) ExportMovies(fn func(*Movie) error, opt ...MovieListingOptions) error {
+	var options MovieListingOptions
+	if len(opt) > 0 {
+		options = opt[0]
+	}
+
+	sql := `select mm.id from movie_movie mm `
+	filter, params := mdb.movieFilter(options.Query)
+	sql += filter
+
+	if len(options.Sort) > 0 {
+		sql += "order by "
+		for i, sort := range options.Sort {
+			if i > 0 {
+				sql += ", "
+			}
+			sql += fmt.Sprintf("%s %s", sort.Field(), sort.Order())
+		}
+		sql += ", mm.id asc"
+	} else {
+		sql += "order by mm.id asc"
+	}
+
+	rows, err := mdb.Query(sql, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		movie, err := mdb.GetMovie(strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		if err := fn(movie); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
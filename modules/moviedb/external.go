@@ -0,0 +1,162 @@
+package moviedb
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	EntityMovie  = "movie"
+	EntityPerson = "person"
+)
+
+// valid external id formats per provider and entity type
+var externalIdFormats = map[string]map[string]*regexp.Regexp{
+	"imdb": {
+		EntityMovie:  regexp.MustCompile(`^tt\d{7,8}$`),
+		EntityPerson: regexp.MustCompile(`^nm\d{7,8}$`),
+	},
+	"tmdb": {
+		EntityMovie:  regexp.MustCompile(`^\d+$`),
+		EntityPerson: regexp.MustCompile(`^\d+$`),
+	},
+	"wikidata": {
+		EntityMovie:  regexp.MustCompile(`^Q\d+$`),
+		EntityPerson: regexp.MustCompile(`^Q\d+$`),
+	},
+}
+
+func ValidateExternalId(entity, provider, externalId string) error {
+	formats, ok := externalIdFormats[provider]
+	if !ok {
+		return fmt.Errorf("unknown external id provider: %s", provider)
+	}
+	format, ok := formats[entity]
+	if !ok {
+		return fmt.Errorf("invalid entity type: %s", entity)
+	}
+	if !format.MatchString(externalId) {
+		return fmt.Errorf("invalid %s id for %s: %s", provider, entity, externalId)
+	}
+	return nil
+}
+
+func (mdb *movieDB) GetExternalIds(entity, id string) ([]*ExternalId, error) {
+	stmt, err := mdb.Prepare(`
+		select provider, external_id
+		from movie_external_id
+		where entity_type = $1
+		and entity_id = $2
+		order by provider asc`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(entity, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	es := []*ExternalId{}
+	for rows.Next() {
+		var e ExternalId
+		if err := rows.Scan(&e.Provider, &e.ExternalId); err != nil {
+			return nil, err
+		}
+		es = append(es, &e)
+	}
+	return es, nil
+}
+
+func (mdb *movieDB) GetMovieByExternalId(provider, externalId string) (*Movie, error) {
+	id, err := mdb.getEntityIdByExternalId(EntityMovie, provider, externalId)
+	if err != nil {
+		return nil, err
+	}
+	return mdb.GetMovie(id)
+}
+
+func (mdb *movieDB) GetPersonByExternalId(provider, externalId string) (*Person, error) {
+	id, err := mdb.getEntityIdByExternalId(EntityPerson, provider, externalId)
+	if err != nil {
+		return nil, err
+	}
+	return mdb.GetPerson(id)
+}
+
+func (mdb *movieDB) getEntityIdByExternalId(entity, provider, externalId string) (string, error) {
+	provider = strings.ToLower(provider)
+	if err := ValidateExternalId(entity, provider, externalId); err != nil {
+		return "", err
+	}
+
+	stmt, err := mdb.Prepare(`
+		select entity_id
+		from movie_external_id
+		where entity_type = $1
+		and provider = $2
+		and external_id = $3`)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var id int
+	err = stmt.QueryRow(entity, provider, externalId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", &NotFoundError{entity, fmt.Sprintf("%s:%s", provider, externalId)}
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", id), nil
+}
+
+func saveExternalIds(tx *sql.Tx, entity string, id int, externalIds []*ExternalId) error {
+	for _, externalId := range externalIds {
+		externalId.Provider = strings.ToLower(externalId.Provider)
+		if err := ValidateExternalId(entity, externalId.Provider, externalId.ExternalId); err != nil {
+			return err
+		}
+
+		// check if external id is already assigned to another entity
+		var owner int
+		err := tx.QueryRow(`select entity_id from movie_external_id
+			where entity_type = $1 and provider = $2 and external_id = $3`,
+			entity, externalId.Provider, externalId.ExternalId).Scan(&owner)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		case owner != id:
+			return &ConflictError{fmt.Sprintf("%s id %s is already assigned to %s %d",
+				externalId.Provider, externalId.ExternalId, entity, owner)}
+		default:
+			// already assigned to this entity
+			continue
+		}
+
+		// there can only be one external id per provider and entity
+		if _, err := tx.Exec(`delete from movie_external_id
+			where entity_type = $1 and entity_id = $2 and provider = $3`,
+			entity, id, externalId.Provider); err != nil {
+			return err
+		}
+
+		stmt, err := tx.Prepare(`INSERT INTO movie_external_id (entity_type, entity_id, provider, external_id) VALUES ($1,$2,$3,$4)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		if _, err := stmt.Exec(entity, id, externalId.Provider, externalId.ExternalId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
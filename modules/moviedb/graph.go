@@ -0,0 +1,225 @@
+package moviedb
+
+import (
+	"fmt"
+	gosort "sort"
+	"strconv"
+)
+
+// peopleGraph links people to the movies they acted in or directed, and movies back to their people.
+// It is built from the link tables on first use and dropped whenever a movie is saved or deleted.
+type peopleGraph struct {
+	people map[int][]int // person id -> movie ids, ascending
+	movies map[int][]int // movie id -> person ids, ascending
+	names  map[int]string
+	titles map[int]*MovieListing
+}
+
+func (mdb *movieDB) getGraph() (*peopleGraph, error) {
+	mdb.graphLock.Lock()
+	defer mdb.graphLock.Unlock()
+
+	if mdb.graph == nil {
+		g, err := mdb.buildGraph()
+		if err != nil {
+			return nil, err
+		}
+		mdb.graph = g
+	}
+	return mdb.graph, nil
+}
+
+// invalidateGraph makes the next lookup rebuild the graph from the database
+func (mdb *movieDB) invalidateGraph() {
+	mdb.graphLock.Lock()
+	defer mdb.graphLock.Unlock()
+	mdb.graph = nil
+}
+
+func (mdb *movieDB) buildGraph() (*peopleGraph, error) {
+	g := &peopleGraph{
+		people: make(map[int][]int),
+		movies: make(map[int][]int),
+		names:  make(map[int]string),
+		titles: make(map[int]*MovieListing),
+	}
+
+	// links of deleted movies or people are left out
+	rows, err := mdb.Query(`select distinct links.person_id, links.movie_id from (
+			select person_id, movie_id from movie_link_actor
+			union
+			select person_id, movie_id from movie_link_director
+		) links
+		join movie_movie mm on (mm.id = links.movie_id)
+		join movie_people mp on (mp.id = links.person_id)
+		order by links.person_id asc, links.movie_id asc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var personId, movieId int
+		if err := rows.Scan(&personId, &movieId); err != nil {
+			return nil, err
+		}
+		g.people[personId] = append(g.people[personId], movieId)
+		g.movies[movieId] = append(g.movies[movieId], personId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, ids := range g.movies {
+		gosort.Ints(ids)
+	}
+
+	rows1, err := mdb.Query(`select id, name from movie_people`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows1.Close()
+
+	for rows1.Next() {
+		var id int
+		var name string
+		if err := rows1.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		g.names[id] = name
+	}
+
+	rows2, err := mdb.Query(`select id, title, year, score, rating from movie_movie`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows2.Close()
+
+	for rows2.Next() {
+		var m MovieListing
+		if err := rows2.Scan(&m.Id, &m.Title, &m.Year, &m.Score, &m.Rating); err != nil {
+			return nil, err
+		}
+		g.titles[m.Id] = &m
+	}
+	return g, nil
+}
+
+func (g *peopleGraph) person(id int) (*Person, error) {
+	name, ok := g.names[id]
+	if !ok {
+		return nil, &NotFoundError{EntityPerson, strconv.Itoa(id)}
+	}
+	return &Person{Id: id, Name: name}, nil
+}
+
+// GetPersonPath returns the shortest chain of shared movies connecting two people,
+// breadth-first through everyone they acted in or directed a movie with.
+// Lower ids are visited first, so the same chain is returned as long as the collection doesn't change.
+func (mdb *movieDB) GetPersonPath(from, to string) (*PersonPath, error) {
+	g, err := mdb.getGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	fromId, err := strconv.Atoi(from)
+	if err != nil {
+		return nil, err
+	}
+	toId, err := strconv.Atoi(to)
+	if err != nil {
+		return nil, err
+	}
+
+	path := &PersonPath{Links: []*PathLink{}}
+	if path.From, err = g.person(fromId); err != nil {
+		return nil, err
+	}
+	if path.To, err = g.person(toId); err != nil {
+		return nil, err
+	}
+
+	// remember how each person was reached, through which movie and from whom
+	type step struct{ person, movie int }
+	reached := map[int]step{fromId: {}}
+	visitedMovies := make(map[int]bool)
+	queue := []int{fromId}
+	for len(queue) > 0 {
+		if _, ok := reached[toId]; ok {
+			break
+		}
+		current := queue[0]
+		queue = queue[1:]
+		for _, movieId := range g.people[current] {
+			if visitedMovies[movieId] {
+				continue
+			}
+			visitedMovies[movieId] = true
+			for _, personId := range g.movies[movieId] {
+				if _, ok := reached[personId]; !ok {
+					reached[personId] = step{current, movieId}
+					queue = append(queue, personId)
+				}
+			}
+		}
+	}
+	if _, ok := reached[toId]; !ok {
+		return nil, &NotFoundError{"path", fmt.Sprintf("from person %d to person %d", fromId, toId)}
+	}
+
+	for id := toId; id != fromId; id = reached[id].person {
+		s := reached[id]
+		link := &PathLink{Movie: g.titles[s.movie]}
+		link.From, _ = g.person(s.person)
+		link.To, _ = g.person(id)
+		path.Links = append([]*PathLink{link}, path.Links...)
+	}
+	path.Degrees = len(path.Links)
+	return path, nil
+}
+
+// GetCollaborators returns everyone who appeared in or directed a movie together with a person,
+// along with the number of movies they share
+func (mdb *movieDB) GetCollaborators(id string) ([]*PersonWithCount, error) {
+	g, err := mdb.getGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	personId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.person(personId); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int)
+	for _, movieId := range g.people[personId] {
+		for _, other := range g.movies[movieId] {
+			if other != personId {
+				counts[other]++
+			}
+		}
+	}
+
+	ps := []*PersonWithCount{}
+	for other, count := range counts {
+		ps = append(ps, &PersonWithCount{Id: other, Name: g.names[other], Count: count})
+	}
+	gosort.Sort(collaborators(ps))
+	return ps, nil
+}
+
+type collaborators []*PersonWithCount
+
+func (c collaborators) Len() int      { return len(c) }
+func (c collaborators) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c collaborators) Less(i, j int) bool {
+	if c[i].Count != c[j].Count {
+		return c[i].Count > c[j].Count
+	}
+	if c[i].Name != c[j].Name {
+		return c[i].Name < c[j].Name
+	}
+	return c[i].Id < c[j].Id
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jamesclonk-io/moviedb-backend/modules/database"
@@ -14,27 +17,55 @@ type MovieDB interface {
 	GetMovie(id string) (*Movie, error)
 	DeleteMovie(id string) (int64, error)
 	AddMovie(*Movie) error
+	ForceAddMovie(*Movie) error
 	SaveMovie(*Movie) error
+	SetMoviePicture(id int, picture string) error
+	ValidateMovie(*Movie) error
 	GetMovieListings(...MovieListingOptions) ([]*MovieListing, error)
+	ExportMovies(fn func(*Movie) error, opt ...MovieListingOptions) error
+	GetRecentMovies(limit int, opt ...MovieListingOptions) ([]*MovieChange, error)
+	GetRandomMovies(random RandomOptions, opt ...MovieListingOptions) (*RandomPick, error)
+	GetSimilarMovies(id string, weights SimilarityWeights, limit int, opt ...MovieListingOptions) ([]*SimilarMovie, error)
 	GetLanguagesByMovie(id string) ([]*Language, error)
 	GetGenresByMovie(id string) ([]*Genre, error)
 	GetActorsByMovie(id string) ([]*Person, error)
 	GetDirectorsByMovie(id string) ([]*Person, error)
+	GetAlttitlesByMovie(id string) ([]*AlternateTitle, error)
 	GetLanguages() ([]*Language, error)
+	GetFormats() ([]*ReferenceCode, error)
+	GetDiskTypes() ([]*ReferenceCode, error)
+	GetRegions() ([]*ReferenceCode, error)
 	GetGenres() ([]*Genre, error)
 	GetPerson(id string) (*Person, error)
 	GetActors() ([]*Person, error)
 	GetDirectors() ([]*Person, error)
-	GetStatistics() (*Statistics, error)
+	GetPersonPath(from, to string) (*PersonPath, error)
+	GetCollaborators(id string) ([]*PersonWithCount, error)
+	GetStatistics(...MovieListingOptions) (*Statistics, error)
+	GetTimeline(bucket string) (*Timeline, error)
+	GetBreakdown(options BreakdownOptions) (*Breakdown, error)
+	GetExternalIds(entity, id string) ([]*ExternalId, error)
+	GetMovieByExternalId(provider, externalId string) (*Movie, error)
+	GetPersonByExternalId(provider, externalId string) (*Person, error)
+	FindDuplicates(*Movie) ([]*Duplicate, error)
+	FindSimilar(movie *Movie, minSimilarity float64) ([]*Duplicate, error)
+	GetDuplicates() ([]*DuplicatePair, error)
+	BeginTransaction() (Transaction, error)
+	NewChecker() Checker
 }
 
 type movieDB struct {
 	*sql.DB
 	DatabaseType string
+
+	graph     *peopleGraph
+	graphLock sync.Mutex
+	picks     map[int]time.Time // movie id -> last time it was picked at random
+	picksLock sync.Mutex
 }
 
 func NewMovieDB(adapter *database.Adapter) MovieDB {
-	return &movieDB{adapter.Database, adapter.Type}
+	return &movieDB{DB: adapter.Database, DatabaseType: adapter.Type}
 }
 
 func (mdb *movieDB) GetLanguagesByMovie(id string) ([]*Language, error) {
@@ -194,9 +225,20 @@ func (mdb *movieDB) GetPerson(id string) (*Person, error) {
 	defer stmt.Close()
 
 	p := &Person{}
-	if err := stmt.QueryRow(id).Scan(&p.Id, &p.Name); err != nil {
+	err = stmt.QueryRow(id).Scan(&p.Id, &p.Name)
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{EntityPerson, id}
+	}
+	if err != nil {
 		return nil, err
 	}
+
+	externalIds, err := mdb.GetExternalIds(EntityPerson, id)
+	if err != nil {
+		return nil, err
+	}
+	p.ExternalIds = externalIds
+
 	return p, nil
 }
 
@@ -238,9 +280,31 @@ func (mdb *movieDB) GetDirectors() ([]*Person, error) {
 	return ps, nil
 }
 
-func (mdb *movieDB) GetStatistics() (*Statistics, error) {
+// GetStatistics sums up the whole collection, or only the movies matching the query options if given.
+// Sort options are ignored.
+func (mdb *movieDB) GetStatistics(opt ...MovieListingOptions) (*Statistics, error) {
+	var options MovieListingOptions
+	if len(opt) > 0 {
+		options = opt[0]
+	}
 	stats := Statistics{}
 
+	// in restricts a movie id column to the filtered movies, all aggregates below use it
+	filter, params := mdb.movieFilter(options.Query)
+	in := func(column string) string {
+		if len(filter) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("and %s in (select mm.id from movie_movie mm %s) ", column, filter)
+	}
+
+	// people without any movies only count when looking at the whole collection
+	people := ""
+	if len(filter) > 0 {
+		people = fmt.Sprintf(`and (exists (select 1 from movie_link_actor mla where mla.person_id = mp.id %s)
+			or exists (select 1 from movie_link_director mld where mld.person_id = mp.id %s)) `, in("mla.movie_id"), in("mld.movie_id"))
+	}
+
 	// -----------------------------------------------------------------
 	// dates & count
 	rows0, err := mdb.Query(`select id, date from movie_dbdate`)
@@ -262,14 +326,15 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 		}
 	}
 
-	if err := mdb.QueryRow(`select count(*) from movie_movie`).Scan(&stats.Count); err != nil {
+	if err := mdb.QueryRow(`select count(*) from movie_movie mm where 1 = 1 `+in("mm.id"), params...).Scan(&stats.Count); err != nil {
 		return nil, err
 	}
 
 	// -----------------------------------------------------------------
-	// general statistics
-	rows1, err := mdb.Query(`select disk_type, sum(disks), sum(length), count(*) 
-		from movie_movie group by disk_type order by disk_type desc`)
+	// general statistics, movies without a disk type or region are counted under an empty one
+	rows1, err := mdb.Query(`select coalesce(mm.disk_type, ''), coalesce(sum(mm.disks), 0), coalesce(sum(mm.length), 0), count(*) 
+		from movie_movie mm left join movie_disk_type mdt on (mdt.code = mm.disk_type) where 1 = 1 `+in("mm.id")+`
+		group by mm.disk_type, mdt.position order by mdt.position is null, mdt.position asc, 1 asc`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -286,22 +351,24 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 	stats.Movies = mcs
 
 	if err := mdb.QueryRow(`select sum(actors) as actors, sum(directors) as directors, sum(people) as people from (		
-			select 0 as actors, 0 as directors, count(*) as people from movie_people
+			select 0 as actors, 0 as directors, count(*) as people from movie_people mp where 1 = 1 `+people+`
 			union
 			select count(*) as actors, 0 as directors, 0 as people 
-				from (select distinct mp.id, mp.name from movie_people mp join movie_link_actor mla on (mla.person_id = mp.id)) actors
+				from (select distinct mp.id, mp.name from movie_people mp join movie_link_actor mla on (mla.person_id = mp.id) 
+					where 1 = 1 `+in("mla.movie_id")+`) actors
 			union
 			select 0 as actors, count(*) as directors, 0 as people 
-				from (select distinct mp.id, mp.name from movie_people mp join movie_link_director mld on (mld.person_id = mp.id)) directors
-		) final`).Scan(&stats.Actors, &stats.Directors, &stats.People); err != nil {
+				from (select distinct mp.id, mp.name from movie_people mp join movie_link_director mld on (mld.person_id = mp.id) 
+					where 1 = 1 `+in("mld.movie_id")+`) directors
+		) final`, params...).Scan(&stats.Actors, &stats.Directors, &stats.People); err != nil {
 		return nil, err
 	}
 
 	// -----------------------------------------------------------------
 	// actor, director and actor&director statistics
 	rows2, err := mdb.Query(`select mp.id, mp.name, count(*) 
-		from movie_people mp join movie_link_actor mla on (mla.person_id = mp.id) 
-		group by mp.id, mp.name order by 3 desc, 2 asc, 1 asc limit 5`)
+		from movie_people mp join movie_link_actor mla on (mla.person_id = mp.id) where 1 = 1 `+in("mla.movie_id")+`
+		group by mp.id, mp.name order by 3 desc, 2 asc, 1 asc limit 5`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -318,8 +385,8 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 	stats.TopActors = as
 
 	rows3, err := mdb.Query(`select mp.id, mp.name, count(*) 
-		from movie_people mp join movie_link_director mld on (mld.person_id = mp.id) 
-		group by mp.id, mp.name order by 3 desc, 2 asc, 1 asc limit 5`)
+		from movie_people mp join movie_link_director mld on (mld.person_id = mp.id) where 1 = 1 `+in("mld.movie_id")+`
+		group by mp.id, mp.name order by 3 desc, 2 asc, 1 asc limit 5`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -336,12 +403,13 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 	stats.TopDirectors = ds
 
 	rows4, err := mdb.Query(`select mp.id, mp.name, 
-			(select count(*) from movie_link_actor where person_id = mp.id)
-            + (select count(*) from movie_link_director where person_id = mp.id) as count
+			(select count(*) from movie_link_actor mla where mla.person_id = mp.id `+in("mla.movie_id")+`)
+            + (select count(*) from movie_link_director mld where mld.person_id = mp.id `+in("mld.movie_id")+`) as count
         from movie_people mp
             join movie_link_actor mla on (mla.person_id = mp.id)
 			join movie_link_director mld on (mld.person_id = mp.id)
-        group by mp.id, mp.name order by 3 desc, 2 asc, 1 asc limit 5`)
+		where 1 = 1 `+in("mla.movie_id")+in("mld.movie_id")+`
+        group by mp.id, mp.name order by 3 desc, 2 asc, 1 asc limit 5`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -359,8 +427,9 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 
 	// -----------------------------------------------------------------
 	// region, score and rating statistics
-	rows5, err := mdb.Query(`select disk_region, count(*) 
-		from movie_movie group by disk_region order by disk_region asc`)
+	rows5, err := mdb.Query(`select coalesce(mm.disk_region, ''), count(*) 
+		from movie_movie mm left join movie_region mr on (mr.code = mm.disk_region) where 1 = 1 `+in("mm.id")+`
+		group by mm.disk_region, mr.position order by mr.position is null, mr.position asc, 1 asc`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -376,7 +445,8 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 	}
 	stats.Regions = rs
 
-	rows6, err := mdb.Query(`select score, count(*) from movie_movie group by score order by score desc`)
+	rows6, err := mdb.Query(`select coalesce(cast(mm.score as varchar(4)), ''), count(*) from movie_movie mm where 1 = 1 `+in("mm.id")+`
+		group by mm.score order by mm.score desc`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -392,7 +462,8 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 	}
 	stats.Scores = ss
 
-	rows7, err := mdb.Query(`select rating, count(*) from movie_movie group by rating order by rating desc`)
+	rows7, err := mdb.Query(`select coalesce(cast(mm.rating as varchar(4)), ''), count(*) from movie_movie mm where 1 = 1 `+in("mm.id")+`
+		group by mm.rating order by mm.rating desc`, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -417,33 +488,56 @@ func (mdb *movieDB) GetStatistics() (*Statistics, error) {
 	stats.NewMoviesEstimate = round((daysSinceLastUpdate * avgMoviesPerDay), 1)
 	stats.AvgMoviesPerDay = round(avgMoviesPerDay, 2)
 
-	// calculating runtimes
-	stats.TotalLength = stats.Movies[0].Length + stats.Movies[1].Length
-	stats.AvgLengthPerMovie = int(round(float64(stats.TotalLength/stats.Count), 0))
-	stats.AvgLengthPerDisk = int(round(float64(stats.TotalLength/(stats.Movies[0].Disks+stats.Movies[1].Disks)), 0))
-
-	// counts
-	stats.DvdMovies = stats.Movies[0].Count
-	stats.BlurayMovies = stats.Movies[1].Count
-	stats.DvdDisks = stats.Movies[0].Disks
-	stats.BlurayDisks = stats.Movies[1].Disks
+	// calculating runtimes and counts
+	var disks int
+	for _, mt := range stats.Movies {
+		stats.TotalLength += mt.Length
+		disks += mt.Disks
+
+		switch mt.DiskType {
+		case "DVD":
+			stats.DvdMovies = mt.Count
+			stats.DvdDisks = mt.Disks
+		case "BluRay":
+			stats.BlurayMovies = mt.Count
+			stats.BlurayDisks = mt.Disks
+		}
+	}
+	if stats.Count > 0 {
+		stats.AvgLengthPerMovie = int(round(float64(stats.TotalLength/stats.Count), 0))
+	}
+	if disks > 0 {
+		stats.AvgLengthPerDisk = int(round(float64(stats.TotalLength/disks), 0))
+	}
 
 	return &stats, nil
 }
 
 func (mdb *movieDB) GetMovie(id string) (*Movie, error) {
-	stmt, err := mdb.Prepare(`select id, title, alttitle, year, description, format, length, 
-		disk_region, rating, disks, score, picture, disk_type from movie_movie where id = $1`)
+	stmt, err := mdb.Prepare(`select id, title, year, description, coalesce(format, ''), length, 
+		coalesce(disk_region, ''), rating, disks, score, picture, coalesce(disk_type, ''), acquired_at, 
+		created_at, updated_at from movie_movie where id = $1`)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
 	var m Movie
-	if err := stmt.QueryRow(id).Scan(&m.Id, &m.Title, &m.Alttitle, &m.Year, &m.Description, &m.Format, &m.Length,
-		&m.Region, &m.Rating, &m.Disks, &m.Score, &m.Picture, &m.Type); err != nil {
+	err = stmt.QueryRow(id).Scan(&m.Id, &m.Title, &m.Year, &m.Description, &m.Format, &m.Length,
+		&m.Region, &m.Rating, &m.Disks, &m.Score, &m.Picture, &m.Type, &m.AcquiredAt, &m.CreatedAt, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, &NotFoundError{EntityMovie, id}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	alttitles, err := mdb.GetAlttitlesByMovie(id)
+	if err != nil {
 		return nil, err
 	}
+	m.Alttitles = alttitles
+	m.Alttitle = legacyAlttitle(alttitles)
 
 	languages, err := mdb.GetLanguagesByMovie(id)
 	if err != nil {
@@ -469,32 +563,66 @@ func (mdb *movieDB) GetMovie(id string) (*Movie, error) {
 	}
 	m.Directors = directors
 
+	externalIds, err := mdb.GetExternalIds(EntityMovie, id)
+	if err != nil {
+		return nil, err
+	}
+	m.ExternalIds = externalIds
+
 	return &m, nil
 }
 
+// AddMovie refuses to add a movie that looks like a duplicate of an existing one, returning a *DuplicateError
 func (mdb *movieDB) AddMovie(movie *Movie) error {
-	// first get next/new movie_id
+	return mdb.withinTransaction(func(tx Transaction) error {
+		return tx.AddMovie(movie)
+	})
+}
+
+func (mdb *movieDB) ForceAddMovie(movie *Movie) error {
+	return mdb.withinTransaction(func(tx Transaction) error {
+		return tx.ForceAddMovie(movie)
+	})
+}
+
+// nextMovieId has to run within the transaction, so movies added earlier in it are accounted for
+func nextMovieId(tx *sql.Tx) (int, error) {
 	var newId int
-	row := mdb.QueryRow(`select max(id)+1 from movie_movie`)
+	row := tx.QueryRow(`select max(id)+1 from movie_movie`)
 	if err := row.Scan(&newId); err != nil {
-		return err
+		return 0, err
 	}
 	if newId < 900 {
-		return errors.New(fmt.Sprintf("new movie_id impossible! [%v]", newId))
+		return 0, errors.New(fmt.Sprintf("new movie_id impossible! [%v]", newId))
 	}
-
-	// set movie_id and call SaveMovie, which will check if movie already exists (it won't) and then inserts it
-	movie.Id = newId
-	return mdb.SaveMovie(movie)
+	return newId, nil
 }
 
 func (mdb *movieDB) SaveMovie(movie *Movie) error {
-	tx, err := mdb.Begin()
+	return mdb.withinTransaction(func(tx Transaction) error {
+		return tx.SaveMovie(movie)
+	})
+}
+
+// SetMoviePicture only changes the picture of a movie, the rest of it is neither validated nor saved again
+func (mdb *movieDB) SetMoviePicture(id int, picture string) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	result, err := mdb.Exec(`update movie_movie set picture = $1, updated_at = $2 where id = $3`, picture, now, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	if rows == 0 {
+		return &NotFoundError{EntityMovie, strconv.Itoa(id)}
+	}
+	return nil
+}
 
+// saveMovie inserts or updates a movie with all its relations, the movie has to be validated already
+func saveMovie(tx *sql.Tx, movie *Movie) error {
 	// check if movie already exists
 	var exists string
 	rows, err := tx.Query("select 'yes' from movie_movie where id = $1", movie.Id)
@@ -508,47 +636,58 @@ func (mdb *movieDB) SaveMovie(movie *Movie) error {
 		}
 	}
 
+	now := time.Now().UTC().Truncate(time.Second)
 	if exists == "yes" {
 		// update movie
 		stmt, err := tx.Prepare(`UPDATE movie_movie
 			set title = $1,
-			alttitle = $2,
-			year = $3,
-			description = $4,
-			format = $5,
-			length = $6,
-			disk_region = $7,
-			rating = $8,
-			disks = $9,
-			score = $10,
-			picture = $11,
-			disk_type = $12
-			where id = $13
+			year = $2,
+			description = $3,
+			format = $4,
+			length = $5,
+			disk_region = $6,
+			rating = $7,
+			disks = $8,
+			score = $9,
+			picture = $10,
+			disk_type = $11,
+			acquired_at = coalesce($12, acquired_at),
+			updated_at = $13
+			where id = $14
 			`)
 		if err != nil {
 			return err
 		}
 		defer stmt.Close()
 
-		if _, err := stmt.Exec(movie.Title, movie.Alttitle, movie.Year, movie.Description, movie.Format,
-			movie.Length, movie.Region, movie.Rating, movie.Disks, movie.Score, movie.Picture, movie.Type, movie.Id); err != nil {
+		if _, err := stmt.Exec(movie.Title, movie.Year, movie.Description, nullString(movie.Format),
+			movie.Length, nullString(movie.Region), movie.Rating, movie.Disks, movie.Score, movie.Picture, nullString(movie.Type), movie.AcquiredAt, now, movie.Id); err != nil {
 			return err
 		}
+		movie.UpdatedAt = &now
 
 	} else {
-		// insert movie
+		// insert movie, acquired now unless told otherwise
+		if movie.AcquiredAt == nil {
+			movie.AcquiredAt = &now
+		}
 		stmt, err := tx.Prepare(`INSERT INTO movie_movie
-			(id, title, alttitle, year, description, format, length, disk_region, rating, disks, score, picture, disk_type) 
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`)
+			(id, title, year, description, format, length, disk_region, rating, disks, score, picture, disk_type, acquired_at, created_at, updated_at) 
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$14)`)
 		if err != nil {
 			return err
 		}
 		defer stmt.Close()
 
-		if _, err := stmt.Exec(movie.Id, movie.Title, movie.Alttitle, movie.Year, movie.Description, movie.Format,
-			movie.Length, movie.Region, movie.Rating, movie.Disks, movie.Score, movie.Picture, movie.Type); err != nil {
+		if _, err := stmt.Exec(movie.Id, movie.Title, movie.Year, movie.Description, nullString(movie.Format),
+			movie.Length, nullString(movie.Region), movie.Rating, movie.Disks, movie.Score, movie.Picture, nullString(movie.Type), movie.AcquiredAt, now); err != nil {
 			return err
 		}
+		movie.CreatedAt, movie.UpdatedAt = &now, &now
+	}
+
+	if err := saveAlttitles(tx, movie); err != nil {
+		return err
 	}
 
 	if err := saveLanguages(tx, movie); err != nil {
@@ -567,60 +706,73 @@ func (mdb *movieDB) SaveMovie(movie *Movie) error {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
+	if err := saveExternalIds(tx, EntityMovie, movie.Id, movie.ExternalIds); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func saveLanguages(tx *sql.Tx, movie *Movie) error {
-	for idx, language := range movie.Languages {
-		// check if language already exists
-		var exists string
-		var id int
-		rows, err := tx.Query("select 'yes', id from movie_language where name = $1", language.Name)
-		if err != nil {
+func saveLanguage(tx *sql.Tx, language *Language) error {
+	// languages referenced only by id
+	if len(language.Name) == 0 && language.Id > 0 {
+		return nil
+	}
+
+	// check if language already exists
+	var exists string
+	var id int
+	rows, err := tx.Query("select 'yes', id from movie_language where name = $1", language.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&exists, &id); err != nil {
 			return err
 		}
-		defer rows.Close()
-		if rows.Next() {
-			if err := rows.Scan(&exists, &id); err != nil {
-				return err
-			}
+	}
+
+	// insert to language table
+	if exists == "yes" {
+		// update only
+		language.Id = id
+
+	} else {
+		// insert
+		// first get next/new language_id
+		var newId int
+		row := tx.QueryRow(`select max(id)+1 from movie_language`)
+		if err := row.Scan(&newId); err != nil {
+			return err
+		}
+		if newId < 10 {
+			return errors.New(fmt.Sprintf("new language_id impossible! [%v]", newId))
 		}
 
-		// insert to language table
-		if exists == "yes" {
-			// update only
-			movie.Languages[idx].Id = id
+		stmt, err := tx.Prepare(`INSERT INTO movie_language (id, name, country, native_name) VALUES ($1,$2,$3,$4)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
 
-		} else {
-			// insert
-			// first get next/new language_id
-			var newId int
-			row := tx.QueryRow(`select max(id)+1 from movie_language`)
-			if err := row.Scan(&newId); err != nil {
-				return err
-			}
-			if newId < 10 {
-				return errors.New(fmt.Sprintf("new language_id impossible! [%v]", newId))
-			}
+		if _, err := stmt.Exec(newId, language.Name, language.Country, language.NativeName); err != nil {
+			return err
+		}
+		language.Id = newId
+	}
 
-			stmt, err := tx.Prepare(`INSERT INTO movie_language (id, name, country, native_name) VALUES ($1,$2,$3,$4)`)
-			if err != nil {
-				return err
-			}
-			defer stmt.Close()
+	return nil
+}
 
-			if _, err := stmt.Exec(newId, language.Name, language.Country, language.NativeName); err != nil {
-				return err
-			}
-			movie.Languages[idx].Id = newId
+func saveLanguages(tx *sql.Tx, movie *Movie) error {
+	for idx, language := range movie.Languages {
+		if err := saveLanguage(tx, language); err != nil {
+			return err
 		}
 
 		// check if language link already exists
-		rows, err = tx.Query("select 'yes' from movie_link_language where movie_id = $1 and language_id = $2",
+		rows, err := tx.Query("select 'yes' from movie_link_language where movie_id = $1 and language_id = $2",
 			movie.Id, movie.Languages[idx].Id)
 		if err != nil {
 			return err
@@ -757,6 +909,10 @@ func saveActors(tx *sql.Tx, movie *Movie) error {
 			movie.Actors[idx].Id = newId
 		}
 
+		if err := saveExternalIds(tx, EntityPerson, movie.Actors[idx].Id, person.ExternalIds); err != nil {
+			return err
+		}
+
 		// check if actor link already exists
 		rows, err = tx.Query("select 'yes' from movie_link_actor where movie_id = $1 and person_id = $2",
 			movie.Id, movie.Actors[idx].Id)
@@ -826,6 +982,10 @@ func saveDirectors(tx *sql.Tx, movie *Movie) error {
 			movie.Directors[idx].Id = newId
 		}
 
+		if err := saveExternalIds(tx, EntityPerson, movie.Directors[idx].Id, person.ExternalIds); err != nil {
+			return err
+		}
+
 		// check if actor link already exists
 		rows, err = tx.Query("select 'yes' from movie_link_director where movie_id = $1 and person_id = $2",
 			movie.Id, movie.Directors[idx].Id)
@@ -852,12 +1012,15 @@ func saveDirectors(tx *sql.Tx, movie *Movie) error {
 
 func (mdb *movieDB) DeleteMovie(id string) (int64, error) {
 	var rowsDeleted int64
+	err := mdb.withinTransaction(func(tx Transaction) (err error) {
+		rowsDeleted, err = tx.DeleteMovie(id)
+		return err
+	})
+	return rowsDeleted, err
+}
 
-	tx, err := mdb.Begin()
-	if err != nil {
-		return 0, err
-	}
-	defer tx.Rollback()
+func deleteMovie(tx *sql.Tx, id string) (int64, error) {
+	var rowsDeleted int64
 
 	sqls := []string{
 		`delete from movie_movie where id = $1`,
@@ -865,20 +1028,22 @@ func (mdb *movieDB) DeleteMovie(id string) (int64, error) {
 		`delete from movie_link_director where movie_id = $1`,
 		`delete from movie_link_genre where movie_id = $1`,
 		`delete from movie_link_language where movie_id = $1`,
+		`delete from movie_alttitle where movie_id = $1`,
+		`delete from movie_external_id where entity_type = 'movie' and entity_id = $1`,
 	}
 
-	for _, sql := range sqls {
+	for idx, sql := range sqls {
 		rows, err := deleteWithinTransaction(tx, id, sql)
 		if err != nil {
 			return 0, err
 		}
+		// nothing to delete if the movie itself does not exist
+		if idx == 0 && rows == 0 {
+			return 0, &NotFoundError{EntityMovie, id}
+		}
 		rowsDeleted = rowsDeleted + rows
 	}
 
-	if err := tx.Commit(); err != nil {
-		return 0, err
-	}
-
 	return rowsDeleted, nil
 }
 
@@ -909,59 +1074,8 @@ func (mdb *movieDB) GetMovieListings(opt ...MovieListingOptions) ([]*MovieListin
 	}
 
 	sql := `select mm.id, mm.title, mm.year, mm.score, mm.rating from movie_movie mm `
-	var params []interface{} // all bind variables in here
-	paramCounter := 1
-
-	if len(options.Query) > 0 {
-		for _, query := range options.Query {
-			switch {
-			case query.Query() == "language":
-				sql += fmt.Sprintf("join movie_link_language mll on (mll.movie_id = mm.id and mll.language_id = $%d) ", paramCounter)
-				params = append(params, query.Value())
-				paramCounter += 1
-			case query.Query() == "genre":
-				sql += fmt.Sprintf("join movie_link_genre mlg on (mlg.movie_id = mm.id and mlg.genre_id = $%d) ", paramCounter)
-				params = append(params, query.Value())
-				paramCounter += 1
-			case query.Query() == "actor":
-				sql += fmt.Sprintf("join movie_link_actor mla on (mla.movie_id = mm.id and mla.person_id = $%d) ", paramCounter)
-				params = append(params, query.Value())
-				paramCounter += 1
-			case query.Query() == "director":
-				sql += fmt.Sprintf("join movie_link_director mld on (mld.movie_id = mm.id and mld.person_id = $%d) ", paramCounter)
-				params = append(params, query.Value())
-				paramCounter += 1
-			}
-		}
-		sql += "where 1 = 1 "
-		for _, query := range options.Query {
-			switch {
-			case query.Query() == "char" && query.Value() == "num":
-				sql += "and substr(title,1,1) in ('1','2','3','4','5','6','7','8','9','0') "
-			case query.Query() == "char":
-				sql += fmt.Sprintf("and upper(substr(title,1,1)) = upper($%d) ", paramCounter)
-				params = append(params, query.Value())
-				paramCounter += 1
-			case query.Query() == "search":
-				if mdb.DatabaseType == "postgres" {
-					sql += fmt.Sprintf("and (title ilike $%d or alttitle ilike $%d or description ilike $%d) ",
-						paramCounter, paramCounter, paramCounter)
-				} else {
-					sql += fmt.Sprintf("and (title like $%d or alttitle like $%d or description like $%d) ",
-						paramCounter, paramCounter, paramCounter)
-				}
-				params = append(params, fmt.Sprintf("%%%s%%", query.Value()))
-				paramCounter += 1
-			case query.Query() != "language" &&
-				query.Query() != "genre" &&
-				query.Query() != "actor" &&
-				query.Query() != "director":
-				sql += fmt.Sprintf("and %s = $%d ", query.Query(), paramCounter)
-				params = append(params, query.Value())
-				paramCounter += 1
-			}
-		}
-	}
+	filter, params := mdb.movieFilter(options.Query)
+	sql += filter
 
 	if len(options.Sort) > 0 {
 		sql += "order by "
@@ -1002,6 +1116,146 @@ func (mdb *movieDB) GetMovieListings(opt ...MovieListingOptions) ([]*MovieListin
 	return ms, nil
 }
 
+// GetRecentMovies returns the movies matching the listing options which were updated last, or sorted like
+// the options say if they do, at most limit of them, or all if limit is 0
+func (mdb *movieDB) GetRecentMovies(limit int, opt ...MovieListingOptions) ([]*MovieChange, error) {
+	var options MovieListingOptions
+	if len(opt) > 0 {
+		options = opt[0]
+	}
+
+	filter, params := mdb.movieFilter(options.Query)
+	if len(filter) == 0 {
+		filter = "where 1 = 1 "
+	}
+	sql := `select mm.id, mm.title, mm.year, mm.score, mm.rating, coalesce(mm.description, ''), coalesce(mm.picture, ''), mm.created_at, mm.updated_at
+		from movie_movie mm ` + filter + `and mm.created_at is not null and mm.updated_at is not null order by `
+	if len(options.Sort) > 0 {
+		for _, sort := range options.Sort {
+			sql += fmt.Sprintf("%s %s, ", sort.Field(), sort.Order())
+		}
+		sql += "mm.id desc"
+	} else {
+		sql += "mm.updated_at desc, mm.id desc"
+	}
+	if limit > 0 {
+		sql += fmt.Sprintf(" limit %d", limit)
+	}
+
+	rows, err := mdb.Query(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*MovieChange{}
+	for rows.Next() {
+		var m MovieListing
+		var c MovieChange
+		if err := rows.Scan(&m.Id, &m.Title, &m.Year, &m.Score, &m.Rating, &c.Description, &c.Picture, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.Movie = &m
+		c.Change = "updated"
+		if c.CreatedAt.Equal(c.UpdatedAt) {
+			c.Change = "added"
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+// movieFilter returns the joins and conditions selecting all movies matching the given queries,
+// to be used right after "from movie_movie mm". Bind variables are numbered starting with $1.
+func (mdb *movieDB) movieFilter(queries []Query) (string, []interface{}) {
+	if len(queries) == 0 {
+		return "", nil
+	}
+
+	sql := ""
+	var params []interface{} // all bind variables in here
+	paramCounter := 1
+
+	for _, query := range queries {
+		switch {
+		case query.Query() == "language":
+			sql += fmt.Sprintf("join movie_link_language mll on (mll.movie_id = mm.id and mll.language_id = $%d) ", paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		case query.Query() == "genre":
+			sql += fmt.Sprintf("join movie_link_genre mlg on (mlg.movie_id = mm.id and mlg.genre_id = $%d) ", paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		case query.Query() == "actor":
+			sql += fmt.Sprintf("join movie_link_actor mla on (mla.movie_id = mm.id and mla.person_id = $%d) ", paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		case query.Query() == "director":
+			sql += fmt.Sprintf("join movie_link_director mld on (mld.movie_id = mm.id and mld.person_id = $%d) ", paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		}
+	}
+	sql += "where 1 = 1 "
+	for _, query := range queries {
+		switch {
+		case query.Query() == "char" && query.Value() == "num":
+			sql += `and (substr(mm.title,1,1) in ('1','2','3','4','5','6','7','8','9','0') 
+				or exists (select 1 from movie_alttitle ma where ma.movie_id = mm.id 
+					and substr(ma.title,1,1) in ('1','2','3','4','5','6','7','8','9','0'))) `
+		case query.Query() == "char":
+			sql += fmt.Sprintf(`and (upper(substr(mm.title,1,1)) = upper($%d) 
+				or exists (select 1 from movie_alttitle ma where ma.movie_id = mm.id 
+					and upper(substr(ma.title,1,1)) = upper($%d))) `, paramCounter, paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		case query.Query() == "search":
+			like := "like"
+			if mdb.DatabaseType == "postgres" {
+				like = "ilike"
+			}
+			sql += fmt.Sprintf(`and (mm.title %s $%d or mm.description %s $%d 
+				or exists (select 1 from movie_alttitle ma where ma.movie_id = mm.id and ma.title %s $%d)) `,
+				like, paramCounter, like, paramCounter, like, paramCounter)
+			params = append(params, fmt.Sprintf("%%%s%%", query.Value()))
+			paramCounter += 1
+		case query.Query() == "since" || query.Query() == "until":
+			operator := ">="
+			if query.Query() == "until" {
+				operator = "<"
+			}
+			// sqlite keeps timestamps as text, with or without time zone
+			if mdb.DatabaseType == "postgres" {
+				sql += fmt.Sprintf("and mm.created_at %s $%d ", operator, paramCounter)
+			} else {
+				sql += fmt.Sprintf("and datetime(mm.created_at) %s datetime($%d) ", operator, paramCounter)
+			}
+			if t, err := ParseTime(query.Value()); err == nil {
+				params = append(params, t)
+			} else {
+				params = append(params, query.Value())
+			}
+			paramCounter += 1
+		case strings.HasPrefix(query.Query(), "min_"):
+			sql += fmt.Sprintf("and mm.%s >= $%d ", query.Query()[4:], paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		case strings.HasPrefix(query.Query(), "max_"):
+			sql += fmt.Sprintf("and mm.%s <= $%d ", query.Query()[4:], paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		case query.Query() != "language" &&
+			query.Query() != "genre" &&
+			query.Query() != "actor" &&
+			query.Query() != "director":
+			sql += fmt.Sprintf("and mm.%s = $%d ", query.Query(), paramCounter)
+			params = append(params, query.Value())
+			paramCounter += 1
+		}
+	}
+	return sql, params
+}
+
 func round(val float64, prec int) float64 {
 	var rounder float64
 	pow := math.Pow(10, float64(prec))
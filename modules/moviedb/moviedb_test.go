@@ -2,15 +2,18 @@ package moviedb
 
 import (
 	"database/sql"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,7 +26,16 @@ func init() {
 	os.Setenv("JCIO_DATABASE_TYPE", "sqlite")
 	os.Setenv("JCIO_DATABASE_URI", fmt.Sprintf("sqlite3://%s", movieTestDbFileCopy))
 
+	resetDatabase()
+}
+
+func resetDatabase() {
 	copyFile(movieTestDbFile, movieTestDbFileCopy)
+
+	// bring test database schema up to date
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	migration.RunMigrations("../../migrations", adapter)
 }
 
 func copyFile(from, to string) {
@@ -127,11 +139,27 @@ func Test_MovieDB_GetMovie(t *testing.T) {
 	assert.Equal(t, expectedMovie.Directors, movie.Directors)
 }
 
+func Test_MovieDB_MovieXML(t *testing.T) {
+	movie := &Movie{Id: 1, Title: "Ran", Alttitle: sql.NullString{String: "乱", Valid: true}, Year: 1985}
+	data, err := xml.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(data), `<Movie id="1"><title>Ran</title><alttitle>乱</alttitle><year>1985</year>`)
+
+	movie.Alttitle = sql.NullString{}
+	data, err = xml.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(data), `<Movie id="1"><title>Ran</title><year>1985</year>`)
+}
+
 func Test_MovieDB_DeleteMovie(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
-	defer copyFile(movieTestDbFile, movieTestDbFileCopy)
+	defer resetDatabase()
 
 	movie, err := mdb.GetMovie("7")
 	if err != nil {
@@ -143,14 +171,21 @@ func Test_MovieDB_DeleteMovie(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, int64(10), rows)
+	assert.Equal(t, int64(11), rows)
+
+	_, err = mdb.GetMovie("7")
+	assert.Equal(t, &NotFoundError{EntityMovie, "7"}, err)
+
+	rows, err = mdb.DeleteMovie("7")
+	assert.Equal(t, &NotFoundError{EntityMovie, "7"}, err)
+	assert.Equal(t, int64(0), rows)
 }
 
 func Test_MovieDB_AddMovie(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
-	defer copyFile(movieTestDbFile, movieTestDbFileCopy)
+	defer resetDatabase()
 
 	expectedMovie := &Movie{
 		Id:       0,
@@ -342,10 +377,10 @@ func Test_MovieDB_AddMovie(t *testing.T) {
 }
 
 func Test_MovieDB_UpdateMovie(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
-	defer copyFile(movieTestDbFile, movieTestDbFileCopy)
+	defer resetDatabase()
 
 	expectedMovie := &Movie{
 		Id:       3,
@@ -524,8 +559,117 @@ func Test_MovieDB_UpdateMovie(t *testing.T) {
 	}
 }
 
+func Test_MovieDB_SetMoviePicture(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	// movies not passing validation anymore can still get a picture
+	if _, err := mdb.Exec(`update movie_movie set year = 1700 where id = 7`); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SetMoviePicture(7, "austin_powers.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	movie, err := mdb.GetMovie("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "austin_powers.jpg", movie.Picture)
+	assert.Equal(t, 1700, movie.Year)
+
+	err = mdb.SetMoviePicture(99999, "missing.jpg")
+	assert.Equal(t, &NotFoundError{EntityMovie, "99999"}, err)
+}
+
+func Test_MovieDB_Transaction(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	// movies added within a transaction get consecutive ids
+	tx, err := mdb.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &Movie{Title: "Testfilm 1", Year: 2029, Disks: 1, Type: "DVD"}
+	second := &Movie{Title: "Testfilm 2", Year: 2029, Disks: 1, Type: "DVD"}
+	if err := tx.ForceAddMovie(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.ForceAddMovie(second); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 915, first.Id)
+	assert.Equal(t, 916, second.Id)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is left after rollback
+	_, err = mdb.GetMovie("915")
+	assert.Equal(t, &NotFoundError{EntityMovie, "915"}, err)
+
+	tx, err = mdb.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := tx.ForceAddMovie(first); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tx.DeleteMovie("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(11), rows)
+	assert.Equal(t, &ValidationError{[]*Violation{{"type", "required", "must not be empty"}}}, tx.SaveMovie(&Movie{Id: 8, Title: "Testfilm 3", Year: 2029, Disks: 1}))
+
+	// checks see the changes made within the transaction
+	samurai := &Movie{Title: "The Last Samurai", Year: 2003, Disks: 1, Type: "DVD"}
+	_, isDuplicate := tx.AddMovie(samurai).(*DuplicateError)
+	assert.True(t, isDuplicate)
+	if _, err := tx.DeleteMovie("181"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.DeleteMovie("420"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, tx.AddMovie(samurai))
+	if err := tx.AddMovie(&Movie{Title: "The Last Samurai", Year: 2003, Disks: 1, Type: "DVD"}); assert.NotNil(t, err) {
+		assert.Equal(t, &DuplicateError{[]*Duplicate{{Id: samurai.Id, Title: "The Last Samurai", Year: 2003, Reasons: []string{"title", "year"}}}}, err)
+	}
+
+	// changes after a savepoint can be undone alone
+	if err := tx.Savepoint("before_delete"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.DeleteMovie("9"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.RollbackTo("before_delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	movie, err := mdb.GetMovie("915")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "[915] Testfilm 1 (2029)", movie.String())
+	_, err = mdb.GetMovie("7")
+	assert.Equal(t, &NotFoundError{EntityMovie, "7"}, err)
+	_, err = mdb.GetMovie("9")
+	assert.NoError(t, err)
+}
+
 func Test_MovieDB_MovieListing(t *testing.T) {
-	copyFile(movieTestDbFile, movieTestDbFileCopy)
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
 
@@ -643,7 +787,7 @@ func Test_MovieDB_MovieListing(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, 23, len(movies))
+	assert.Equal(t, 31, len(movies)) // includes alttitles
 
 	expected = &MovieListing{
 		Id:     405,
@@ -660,14 +804,14 @@ func Test_MovieDB_MovieListing(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, 44, len(movies))
+	assert.Equal(t, 58, len(movies)) // includes alttitles
 
 	expected = &MovieListing{
-		Id:     543,
-		Title:  "Californication (1)",
-		Year:   2007,
+		Id:     308,
+		Title:  "Azumanga Daioh", // "Class Album: The Complete Azumanga Daioh"
+		Year:   2002,
 		Score:  4,
-		Rating: 18,
+		Rating: 12,
 	}
 	assert.Equal(t, expected, movies[0])
 
@@ -687,6 +831,61 @@ func Test_MovieDB_MovieListing(t *testing.T) {
 		Rating: 16,
 	}
 	assert.Equal(t, expected, movies[2])
+
+	// lower and upper bounds
+	movies, err = mdb.GetMovieListings(MovieListingOptions{
+		Query: []Query{NewQuery("genre", "1"), NewQuery("max_length", "90"), NewQuery("min_score", "4")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 12, len(movies))
+	assert.Equal(t, "Ghost in the Shell", movies[0].Title)
+	for _, movie := range movies {
+		assert.True(t, movie.Score >= 4)
+	}
+
+	// unknown bounds fall back to id
+	assert.Equal(t, "id", NewQuery("min_title", "A").Query())
+	assert.Equal(t, "max_year", NewQuery("max_year", "1990").Query())
+}
+
+func Test_MovieDB_ExportMovies(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	var movies []*Movie
+	err := mdb.ExportMovies(func(movie *Movie) error {
+		movies = append(movies, movie)
+		return nil
+	}, MovieListingOptions{Query: []Query{NewQuery("year", "2012")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 51, len(movies)) {
+		assert.Equal(t, "[782] The Borgias (2) (2012)", movies[0].String())
+		assert.Equal(t, "[914] Argo (2012)", movies[50].String())
+		assert.Equal(t, "Ben Affleck", movies[50].Directors[0].Name)
+		assert.NotEmpty(t, movies[50].Actors)
+		assert.NotEmpty(t, movies[50].Genres)
+		assert.NotEmpty(t, movies[50].Languages)
+	}
+
+	// sorted as requested, an error stops the export
+	stop := errors.New("stop")
+	movies = nil
+	err = mdb.ExportMovies(func(movie *Movie) error {
+		movies = append(movies, movie)
+		if len(movies) == 2 {
+			return stop
+		}
+		return nil
+	}, MovieListingOptions{Query: []Query{NewQuery("year", "2012")}, Sort: []Sort{NewSort("title", "asc")}})
+	assert.Equal(t, stop, err)
+	if assert.Equal(t, 2, len(movies)) {
+		assert.Equal(t, "21 Jump Street", movies[0].Title)
+		assert.Equal(t, "Abraham Lincoln: Vampire Hunter", movies[1].Title)
+	}
 }
 
 func Test_MovieDB_LanguagesByMovie(t *testing.T) {
@@ -839,145 +1038,1228 @@ func Test_MovieDB_Person(t *testing.T) {
 	mdb := getMovieDB()
 	defer mdb.Close()
 
-	expected := &Person{Id: 470, Name: "Roger Moore"}
+	expected := &Person{Id: 470, Name: "Roger Moore", ExternalIds: []*ExternalId{}}
 	person, err := mdb.GetPerson("470")
 	if err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, expected, person)
+
+	_, err = mdb.GetPerson("999999")
+	assert.Equal(t, &NotFoundError{EntityPerson, "999999"}, err)
 }
 
-func Test_MovieDB_Actors(t *testing.T) {
+func Test_MovieDB_Alttitles(t *testing.T) {
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
+	defer resetDatabase()
 
-	expected := "Sylvester Stallone"
-	actors, err := mdb.GetActors()
+	// migrated from legacy alttitle column
+	alttitles, err := mdb.GetAlttitlesByMovie("4")
 	if err != nil {
 		t.Fatal(err)
 	}
-	found := false
-	for i := range actors {
-		if actors[i].Name == expected {
-			found = true
-		}
+	assert.Equal(t, 1, len(alttitles))
+	assert.Equal(t, "C'era una volta il West", alttitles[0].Title)
+	assert.Equal(t, AlttitleLocalized, alttitles[0].Type)
+
+	movie, err := mdb.GetMovie("4")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if !found {
-		t.Errorf("Expected actors to contain [%v]", expected)
+	assert.Equal(t, sql.NullString{String: "C'era una volta il West", Valid: true}, movie.Alttitle)
+
+	movie.Alttitles = []*AlternateTitle{
+		&AlternateTitle{Title: "C'era una volta il West", Type: AlttitleOriginal, Language: &Language{Name: "Italienisch"}},
+		&AlternateTitle{Title: "Once Upon a Time in the West", Language: &Language{Id: 2}},
+		&AlternateTitle{Title: "Zapata Western", Type: AlttitleWorking},
+	}
+	if err := mdb.SaveMovie(movie); err != nil {
+		t.Fatal(err)
 	}
 
-	unexpected := "Steven Spielberg"
-	found = false
-	for i := range actors {
-		if actors[i].Name == unexpected {
-			found = true
-		}
+	alttitles, err = mdb.GetAlttitlesByMovie("4")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if found {
-		t.Errorf("Expected actors to not contain [%v]", unexpected)
+	assert.Equal(t, 3, len(alttitles))
+	assert.Equal(t, AlttitleOriginal, alttitles[0].Type)
+	assert.Equal(t, "Italienisch", alttitles[0].Language.Name)
+	assert.Equal(t, AlttitleLocalized, alttitles[1].Type)
+	assert.Equal(t, &Language{Id: 2, Name: "Englisch", Country: "USA", NativeName: "English"}, alttitles[1].Language)
+	assert.Nil(t, alttitles[2].Language)
+
+	// search and char consider every title
+	movies, err := mdb.GetMovieListings(MovieListingOptions{
+		Query: []Query{NewQuery("search", "Once Upon a Time in the West")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(movies))
+	assert.Equal(t, 4, movies[0].Id)
+
+	movies, err = mdb.GetMovieListings(MovieListingOptions{
+		Query: []Query{NewQuery("char", "z"), NewQuery("year", "1968")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(movies))
+	assert.Equal(t, "Spiel mir das Lied vom Tod", movies[0].Title)
+
+	// legacy clients only send alttitle
+	movie.Alttitles = nil
+	movie.Alttitle = sql.NullString{String: "Once Upon a Time in the West", Valid: true}
+	if err := mdb.SaveMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	alttitles, err = mdb.GetAlttitlesByMovie("4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(alttitles))
+	assert.Equal(t, "Once Upon a Time in the West", alttitles[0].Title)
+
+	movie.Alttitles = []*AlternateTitle{&AlternateTitle{Title: "Spiel mir das Lied", Type: "nickname"}}
+	err = mdb.SaveMovie(movie)
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []*Violation{
+			&Violation{"alttitles[0].type", "enum", "must be one of: original, localized, working, short"},
+		}, err.(*ValidationError).Violations)
 	}
 }
 
-func Test_MovieDB_Directors(t *testing.T) {
+func Test_MovieDB_ExternalIds(t *testing.T) {
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
-
-	expected := "Steven Spielberg"
-	directors, err := mdb.GetDirectors()
+	defer resetDatabase()
+
+	assert.Nil(t, ValidateExternalId(EntityMovie, "imdb", "tt0088247"))
+	assert.Nil(t, ValidateExternalId(EntityPerson, "imdb", "nm0000216"))
+	assert.Nil(t, ValidateExternalId(EntityMovie, "wikidata", "Q162255"))
+	assert.Nil(t, ValidateExternalId(EntityPerson, "tmdb", "1100"))
+	assert.NotNil(t, ValidateExternalId(EntityMovie, "imdb", "nm0000216"))
+	assert.NotNil(t, ValidateExternalId(EntityPerson, "imdb", "tt0088247"))
+	assert.NotNil(t, ValidateExternalId(EntityMovie, "imdb", "tt123"))
+	assert.NotNil(t, ValidateExternalId(EntityMovie, "netflix", "12345"))
+
+	movie, err := mdb.GetMovie("2")
 	if err != nil {
 		t.Fatal(err)
 	}
-	found := false
-	for i := range directors {
-		if directors[i].Name == expected {
-			found = true
-		}
+	movie.ExternalIds = []*ExternalId{
+		&ExternalId{Provider: "IMDb", ExternalId: "tt0137523"},
+		&ExternalId{Provider: "wikidata", ExternalId: "Q190050"},
 	}
-	if !found {
-		t.Errorf("Expected directors to contain [%v]", expected)
+	movie.Directors[0].ExternalIds = []*ExternalId{
+		&ExternalId{Provider: "imdb", ExternalId: "nm0000399"},
+	}
+	if err := mdb.SaveMovie(movie); err != nil {
+		t.Fatal(err)
 	}
 
-	unexpected := "Bruce Willis"
-	found = false
-	for i := range directors {
-		if directors[i].Name == unexpected {
-			found = true
-		}
+	movie, err = mdb.GetMovieByExternalId("imdb", "tt0137523")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if found {
-		t.Errorf("Expected directors to not contain [%v]", unexpected)
+	assert.Equal(t, "[2] Fight Club (1999)", movie.String())
+	assert.Equal(t, []*ExternalId{
+		&ExternalId{Provider: "imdb", ExternalId: "tt0137523"},
+		&ExternalId{Provider: "wikidata", ExternalId: "Q190050"},
+	}, movie.ExternalIds)
+
+	person, err := mdb.GetPersonByExternalId("imdb", "nm0000399")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &Person{Id: 11, Name: "David Fincher", ExternalIds: []*ExternalId{
+		&ExternalId{Provider: "imdb", ExternalId: "nm0000399"},
+	}}, person)
+
+	// replacing an external id of the same provider
+	movie.ExternalIds = []*ExternalId{&ExternalId{Provider: "imdb", ExternalId: "tt0137524"}}
+	if err := mdb.SaveMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	_, err = mdb.GetMovieByExternalId("imdb", "tt0137523")
+	assert.Equal(t, &NotFoundError{EntityMovie, "imdb:tt0137523"}, err)
+
+	// external ids must be unique per provider
+	other, err := mdb.GetMovie("3")
+	if err != nil {
+		t.Fatal(err)
 	}
+	other.ExternalIds = []*ExternalId{&ExternalId{Provider: "imdb", ExternalId: "tt0137524"}}
+	err = mdb.SaveMovie(other)
+	assert.Equal(t, &ConflictError{"imdb id tt0137524 is already assigned to movie 2"}, err)
+
+	// invalid formats are rejected
+	other.ExternalIds = []*ExternalId{&ExternalId{Provider: "imdb", ExternalId: "0137524"}}
+	assert.NotNil(t, mdb.SaveMovie(other))
 }
 
-func Test_MovieDB_Statistics(t *testing.T) {
+func Test_MovieDB_References(t *testing.T) {
+	resetDatabase()
 	mdb := getMovieDB()
 	defer mdb.Close()
+	defer resetDatabase()
 
-	// dates
-	stats, err := mdb.GetStatistics()
+	formats, err := mdb.GetFormats()
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, 912, stats.Count)
+	assert.Equal(t, []*ReferenceCode{
+		&ReferenceCode{"16:9", "Widescreen 16:9"},
+		&ReferenceCode{"4:3", "Fullscreen 4:3"},
+	}, formats)
 
-	gz, err := time.Parse(time.UnixDate, "Sun Aug 01 00:13:37 UTC 1999")
+	diskTypes, err := mdb.GetDiskTypes()
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, gz, stats.GroundZero)
+	assert.Equal(t, []*ReferenceCode{
+		&ReferenceCode{"DVD", "DVD"},
+		&ReferenceCode{"BluRay", "Blu-ray Disc"},
+	}, diskTypes)
 
-	lu, err := time.Parse(time.UnixDate, "Wed Jan 01 17:11:36 UTC 2014")
+	regions, err := mdb.GetRegions()
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, lu, stats.LastUpdate)
+	assert.Equal(t, 12, len(regions))
+	assert.Equal(t, &ReferenceCode{"0", "Region free"}, regions[0])
+	assert.Equal(t, &ReferenceCode{"B", "Region B (Europe, Africa, Oceania)"}, regions[10])
 
-	// stats
-	assert.Equal(t, 2, len(stats.Movies))
-	assert.Equal(t, "DVD", stats.Movies[0].DiskType)
-	assert.Equal(t, 1238, stats.Movies[0].Disks)
-	assert.Equal(t, 154253, stats.Movies[0].Length)
-	assert.Equal(t, 602, stats.Movies[0].Count)
-	assert.Equal(t, "BluRay", stats.Movies[1].DiskType)
-	assert.Equal(t, 493, stats.Movies[1].Disks)
-	assert.Equal(t, 61691, stats.Movies[1].Length)
-	assert.Equal(t, 310, stats.Movies[1].Count)
+	// empty codes are stored as NULL
+	movie, err := mdb.GetMovie("852")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", movie.Format)
+	if err := mdb.SaveMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	var format sql.NullString
+	if err := mdb.QueryRow(`select format from movie_movie where id = 852`).Scan(&format); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, format.Valid)
 
-	assert.Equal(t, 4696, stats.Actors)
-	assert.Equal(t, 578, stats.Directors)
-	assert.Equal(t, 5244, stats.People)
+	// rerun the migration on free text values
+	down, err := ioutil.ReadFile("../../migrations/sqlite/006_moviedb_reference_tables.down.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := ioutil.ReadFile("../../migrations/sqlite/006_moviedb_reference_tables.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Exec(string(down)); err != nil {
+		t.Fatal(err)
+	}
+	for _, update := range []string{
+		`update movie_movie set disk_type = 'blu-ray', format = '16/9', disk_region = 'b' where id = 1`,
+		`update movie_movie set disk_type = 'BD', format = 'Fullscreen', disk_region = 'free' where id = 2`,
+		`update movie_movie set disk_type = 'dvd ', format = '', disk_region = '' where id = 3`,
+		`update movie_movie set disk_type = 'Laserdisc', format = '2.35:1' where id = 4`,
+	} {
+		if _, err := mdb.Exec(update); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mdb.Exec(string(up)); err != nil {
+		t.Fatal(err)
+	}
 
-	assert.Equal(t, "Bud Spencer", stats.TopActors[0].Name)
-	assert.Equal(t, 338, stats.TopActors[1].Id)
-	assert.Equal(t, 21, stats.TopActors[2].Count)
+	for id, expected := range map[int][]sql.NullString{
+		1: {{String: "BluRay", Valid: true}, {String: "16:9", Valid: true}, {String: "B", Valid: true}},
+		2: {{String: "BluRay", Valid: true}, {String: "4:3", Valid: true}, {String: "0", Valid: true}},
+		3: {{String: "DVD", Valid: true}, {}, {}},
+		4: {{String: "Laserdisc", Valid: true}, {String: "2.35:1", Valid: true}, {String: "2", Valid: true}},
+	} {
+		var diskType, format, region sql.NullString
+		if err := mdb.QueryRow(`select disk_type, format, disk_region from movie_movie where id = $1`, id).Scan(&diskType, &format, &region); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expected, []sql.NullString{diskType, format, region}, fmt.Sprintf("movie %d", id))
+	}
 
-	assert.Equal(t, "Kenji Kamiyama", stats.TopDirectors[0].Name)
-	assert.Equal(t, 127, stats.TopDirectors[1].Id)
-	assert.Equal(t, 11, stats.TopDirectors[2].Count)
+	// unknown values are kept as codes of their own
+	diskTypes, err = mdb.GetDiskTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &ReferenceCode{"Laserdisc", "Laserdisc"}, diskTypes[2])
+}
 
-	assert.Equal(t, "Clint Eastwood", stats.TopActorsAndDirectors[0].Name)
-	assert.Equal(t, 189, stats.TopActorsAndDirectors[1].Id)
-	assert.Equal(t, 12, stats.TopActorsAndDirectors[2].Count)
+func Test_MovieDB_Unavailable(t *testing.T) {
+	mdb := getMovieDB()
+	mdb.Close()
+
+	_, err := mdb.GetGenres()
+	if assert.IsType(t, &UnavailableError{}, err) {
+		assert.Equal(t, "database unavailable", err.Error())
+	}
+	_, err = mdb.GetMovieListings()
+	assert.IsType(t, &UnavailableError{}, err)
+
+	// single row queries and statements too
+	_, err = mdb.GetSimilarMovies("1", DefaultSimilarityWeights, 0)
+	assert.IsType(t, &UnavailableError{}, err)
+	err = mdb.SetMoviePicture(1, "face_off.jpg")
+	assert.IsType(t, &UnavailableError{}, err)
+	_, err = mdb.BeginTransaction()
+	assert.IsType(t, &UnavailableError{}, err)
+}
 
-	assert.Equal(t, "0", stats.Regions[0].Type)
-	assert.Equal(t, 74, stats.Regions[1].Count)
-	assert.Equal(t, "B", stats.Regions[4].Type)
-	assert.Equal(t, 311, stats.Regions[4].Count)
+func Test_MovieDB_Busy(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
 
-	assert.Equal(t, "5", stats.Scores[0].Type)
-	assert.Equal(t, 214, stats.Scores[1].Count)
+	// another writer holds the lock
+	tx, err := mdb.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.DeleteMovie("7"); err != nil {
+		t.Fatal(err)
+	}
 
-	assert.Equal(t, "21", stats.Ratings[0].Type)
-	assert.Equal(t, 132, stats.Ratings[1].Count)
+	conn, err := sql.Open("sqlite3", movieTestDbFileCopy+"?_busy_timeout=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waiting := &movieDB{DB: conn, DatabaseType: "sqlite"}
 
-	// numbers
-	assert.True(t, stats.AvgMoviesPerDay > 0.15 && stats.AvgMoviesPerDay < 0.3)
-	assert.True(t, stats.NewMoviesEstimate > 90)
-	assert.Equal(t, 602, stats.DvdMovies)
-	assert.Equal(t, 310, stats.BlurayMovies)
-	assert.Equal(t, 1238, stats.DvdDisks)
+	err = waiting.SetMoviePicture(1, "face_off.jpg")
+	if assert.IsType(t, &BusyError{}, err) {
+		assert.Equal(t, "database busy, please retry", err.Error())
+	}
+
+	// it is free again once the other writer is done
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, waiting.SetMoviePicture(1, "face_off.jpg"))
+}
+
+func Test_MovieDB_Validation(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	movie, err := mdb.GetMovie("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, mdb.ValidateMovie(movie))
+
+	movie.Title = ""
+	movie.Year = 0
+	movie.Length = -5
+	movie.Score = 7
+	movie.Rating = 99
+	movie.Type = "VHS"
+	movie.Format = "2.35:1"
+	movie.Region = "X"
+	movie.Alttitles = []*AlternateTitle{&AlternateTitle{Title: "", Language: &Language{Id: 999}}}
+	movie.Languages = append(movie.Languages, &Language{Id: 1}, &Language{})
+	movie.Actors[1].Name = ""
+	movie.Directors[0].ExternalIds = []*ExternalId{&ExternalId{Provider: "imdb", ExternalId: "tt0137523"}}
+	acquiredAt := time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	movie.AcquiredAt = &acquiredAt
+
+	err = mdb.ValidateMovie(movie)
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []*Violation{
+			&Violation{"title", "required", "must not be empty"},
+			&Violation{"alttitles[0].title", "required", "must not be empty"},
+			&Violation{"year", "range", "must be between 1888 and 2100"},
+			&Violation{"length", "range", "must be at least 0"},
+			&Violation{"rating", "range", "must be between 0 and 21"},
+			&Violation{"score", "range", "must be between 0 and 5"},
+			&Violation{"acquired_at", "range", "must be between 1888-01-01 and tomorrow"},
+			&Violation{"actors[1].name", "required", "must not be empty"},
+			&Violation{"directors[0].external_ids[0].id", "format", "invalid imdb id for person: tt0137523"},
+			&Violation{"format", "reference", "must be one of: 16:9, 4:3"},
+			&Violation{"region", "reference", "must be one of: 0, 1, 2, 3, 4, 5, 6, 7, 8, A, B, C"},
+			&Violation{"type", "reference", "must be one of: DVD, BluRay"},
+			&Violation{"alttitles[0].language.id", "reference", "unknown language id 999"},
+			&Violation{"languages[3].name", "required", "must not be empty"},
+		}, err.(*ValidationError).Violations)
+	}
+
+	// nothing gets saved
+	assert.Equal(t, err, mdb.SaveMovie(movie))
+	movie, err = mdb.GetMovie("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Fight Club", movie.Title)
+}
+
+func Test_MovieDB_NormalizeTitle(t *testing.T) {
+	assert.Equal(t, "face off", NormalizeTitle("Face/Off"))
+	assert.Equal(t, "bourne identität", NormalizeTitle("Die Bourne Identit&#228;t"))
+	assert.Equal(t, "krieg der sterne das imperium schlägt zurück", NormalizeTitle("Krieg der Sterne:<br/>Das Imperium schl&#228;gt zur&#252;ck"))
+	assert.Equal(t, "last samurai", NormalizeTitle("  The Last Samurai "))
+	assert.Equal(t, "300", NormalizeTitle("300"))
+}
+
+func Test_MovieDB_Duplicates(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	pairs, err := mdb.GetDuplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 20, len(pairs))
+	assert.Equal(t, []*Duplicate{
+		&Duplicate{Id: 1, Title: "Face/Off", Year: 1997},
+		&Duplicate{Id: 414, Title: "Face/Off", Year: 1997},
+	}, pairs[0].Movies)
+	assert.Equal(t, []string{"title", "year", "director"}, pairs[0].Reasons)
+	assert.Equal(t, 124, pairs[6].Movies[0].Id)
+	assert.Equal(t, 882, pairs[6].Movies[1].Id)
+	assert.Equal(t, []string{"alttitle", "year", "director"}, pairs[6].Reasons)
+	assert.Equal(t, 603, pairs[18].Movies[0].Id)
+	assert.Equal(t, []string{"title", "director"}, pairs[18].Reasons)
+
+	// same title and year
+	candidates, err := mdb.FindDuplicates(&Movie{Title: "The Last Samurai", Year: 2003})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, 181, candidates[0].Id)
+	assert.Equal(t, []string{"title", "year"}, candidates[0].Reasons)
+
+	// alternate title and same director, year off by one
+	candidates, err = mdb.FindDuplicates(&Movie{
+		Title:     "The Bourne Identity",
+		Year:      2003,
+		Directors: []*Person{&Person{Name: "Doug Liman"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, 124, candidates[0].Id)
+	assert.Equal(t, []string{"alttitle", "director"}, candidates[0].Reasons)
+	assert.Equal(t, 882, candidates[1].Id)
+	assert.Equal(t, []string{"title", "director"}, candidates[1].Reasons)
+
+	// same title, but neither year nor director match
+	candidates, err = mdb.FindDuplicates(&Movie{Title: "Gladiator", Year: 1992})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(candidates))
+
+	// a movie is never a duplicate of itself
+	movie, err := mdb.GetMovie("25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, err = mdb.FindDuplicates(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(candidates))
+	assert.Equal(t, 545, candidates[0].Id)
+
+	// adding is refused unless forced
+	movie.Id = 0
+	err = mdb.AddMovie(movie)
+	if assert.IsType(t, &DuplicateError{}, err) {
+		assert.Equal(t, 2, len(err.(*DuplicateError).Candidates))
+	}
+	assert.Equal(t, 0, movie.Id)
+
+	if err := mdb.ForceAddMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 915, movie.Id)
+}
+
+func Test_MovieDB_FindSimilar(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	assert.Equal(t, 1.0, TitleSimilarity("The Last Samurai", "last samurai!"))
+	assert.InDelta(t, 0.92, TitleSimilarity("The Last Samurai", "The Last Samurrai"), 0.01)
+	assert.Equal(t, 0.0, TitleSimilarity("", ""))
+
+	// typos are found, best matches first
+	similar, err := mdb.FindSimilar(&Movie{Title: "The Last Samurrai", Year: 2003}, 0.85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 2, len(similar)) {
+		assert.Equal(t, 181, similar[0].Id)
+		assert.Equal(t, 420, similar[1].Id)
+		assert.Equal(t, []string{"similar_title", "year"}, similar[0].Reasons)
+		assert.InDelta(t, 0.92, similar[0].Similarity, 0.01)
+	}
+
+	// the year may be off by one, but not more
+	similar, err = mdb.FindSimilar(&Movie{Title: "Face Off", Year: 1998}, 0.85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(similar))
+	assert.Equal(t, []string{"similar_title"}, similar[0].Reasons)
+
+	similar, err = mdb.FindSimilar(&Movie{Title: "Face Off", Year: 2000}, 0.85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(similar))
+}
+
+func Test_MovieDB_Checker(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	checker := mdb.NewChecker()
+	movie := &Movie{Title: "The Last Samurai", Year: 2003, Disks: 1, Type: "DVD", Languages: []*Language{&Language{Id: 2}}}
+	assert.Nil(t, checker.ValidateMovie(movie))
+	duplicates, err := checker.FindDuplicates(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(duplicates))
+	similar, err := checker.FindSimilar(&Movie{Title: "The Last Samurrai", Year: 2003}, 0.85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(similar))
+
+	// the collection is read once, later changes are only seen by a new checker
+	if _, err := mdb.DeleteMovie("181"); err != nil {
+		t.Fatal(err)
+	}
+	duplicates, err = checker.FindDuplicates(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(duplicates))
+	duplicates, err = mdb.NewChecker().FindDuplicates(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(duplicates))
+
+	err = checker.ValidateMovie(&Movie{Title: "The Last Samurai", Year: 2003, Disks: 1, Type: "VHS", Languages: []*Language{&Language{Id: 999}}})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "invalid movie: type must be one of: DVD, BluRay; languages[0].id unknown language id 999", err.Error())
+	}
+}
+
+func Test_MovieDB_Actors(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	expected := "Sylvester Stallone"
+	actors, err := mdb.GetActors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i := range actors {
+		if actors[i].Name == expected {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected actors to contain [%v]", expected)
+	}
+
+	unexpected := "Steven Spielberg"
+	found = false
+	for i := range actors {
+		if actors[i].Name == unexpected {
+			found = true
+		}
+	}
+	if found {
+		t.Errorf("Expected actors to not contain [%v]", unexpected)
+	}
+}
+
+func Test_MovieDB_Directors(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	expected := "Steven Spielberg"
+	directors, err := mdb.GetDirectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i := range directors {
+		if directors[i].Name == expected {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected directors to contain [%v]", expected)
+	}
+
+	unexpected := "Bruce Willis"
+	found = false
+	for i := range directors {
+		if directors[i].Name == unexpected {
+			found = true
+		}
+	}
+	if found {
+		t.Errorf("Expected directors to not contain [%v]", unexpected)
+	}
+}
+
+func Test_MovieDB_Statistics(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	// dates
+	stats, err := mdb.GetStatistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 912, stats.Count)
+
+	gz, err := time.Parse(time.UnixDate, "Sun Aug 01 00:13:37 UTC 1999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gz, stats.GroundZero)
+
+	lu, err := time.Parse(time.UnixDate, "Wed Jan 01 17:11:36 UTC 2014")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lu, stats.LastUpdate)
+
+	// stats
+	assert.Equal(t, 2, len(stats.Movies))
+	assert.Equal(t, "DVD", stats.Movies[0].DiskType)
+	assert.Equal(t, 1238, stats.Movies[0].Disks)
+	assert.Equal(t, 154253, stats.Movies[0].Length)
+	assert.Equal(t, 602, stats.Movies[0].Count)
+	assert.Equal(t, "BluRay", stats.Movies[1].DiskType)
+	assert.Equal(t, 493, stats.Movies[1].Disks)
+	assert.Equal(t, 61691, stats.Movies[1].Length)
+	assert.Equal(t, 310, stats.Movies[1].Count)
+
+	assert.Equal(t, 4696, stats.Actors)
+	assert.Equal(t, 578, stats.Directors)
+	assert.Equal(t, 5244, stats.People)
+
+	assert.Equal(t, "Bud Spencer", stats.TopActors[0].Name)
+	assert.Equal(t, 338, stats.TopActors[1].Id)
+	assert.Equal(t, 21, stats.TopActors[2].Count)
+
+	assert.Equal(t, "Kenji Kamiyama", stats.TopDirectors[0].Name)
+	assert.Equal(t, 127, stats.TopDirectors[1].Id)
+	assert.Equal(t, 11, stats.TopDirectors[2].Count)
+
+	assert.Equal(t, "Clint Eastwood", stats.TopActorsAndDirectors[0].Name)
+	assert.Equal(t, 189, stats.TopActorsAndDirectors[1].Id)
+	assert.Equal(t, 12, stats.TopActorsAndDirectors[2].Count)
+
+	assert.Equal(t, "0", stats.Regions[0].Type)
+	assert.Equal(t, 74, stats.Regions[1].Count)
+	assert.Equal(t, "B", stats.Regions[4].Type)
+	assert.Equal(t, 311, stats.Regions[4].Count)
+
+	assert.Equal(t, "5", stats.Scores[0].Type)
+	assert.Equal(t, 214, stats.Scores[1].Count)
+
+	assert.Equal(t, "21", stats.Ratings[0].Type)
+	assert.Equal(t, 132, stats.Ratings[1].Count)
+
+	// numbers
+	assert.True(t, stats.AvgMoviesPerDay > 0.15 && stats.AvgMoviesPerDay < 0.3)
+	assert.True(t, stats.NewMoviesEstimate > 90)
+	assert.Equal(t, 602, stats.DvdMovies)
+	assert.Equal(t, 310, stats.BlurayMovies)
+	assert.Equal(t, 1238, stats.DvdDisks)
 	assert.Equal(t, 493, stats.BlurayDisks)
 	assert.Equal(t, 215944, stats.TotalLength)
 	assert.Equal(t, 236, stats.AvgLengthPerMovie)
 	assert.Equal(t, 124, stats.AvgLengthPerDisk)
 }
+
+func Test_MovieDB_StatisticsNulls(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	if _, err := mdb.Exec(`update movie_movie set disk_type = null, disk_region = null, score = null, rating = null where id in (7, 9)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// movies without disk type or region are counted under an empty one, so everything still adds up
+	stats, err := mdb.GetStatistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 912, stats.Count)
+	assert.Equal(t, 3, len(stats.Movies))
+	assert.Equal(t, "", stats.Movies[2].DiskType)
+	assert.Equal(t, 2, stats.Movies[2].Count)
+	assert.Equal(t, 215944, stats.TotalLength)
+
+	for _, counts := range [][]*TypeCount{stats.Regions, stats.Scores, stats.Ratings} {
+		total, unknown := 0, 0
+		for _, c := range counts {
+			total += c.Count
+			if c.Type == "" {
+				unknown += c.Count
+			}
+		}
+		assert.Equal(t, 912, total)
+		assert.Equal(t, 2, unknown)
+	}
+	assert.Equal(t, "", stats.Regions[len(stats.Regions)-1].Type)
+}
+
+func Test_MovieDB_Timeline(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	timeline, err := mdb.GetTimeline("month")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "month", timeline.Bucket)
+	assert.Equal(t, 174, len(timeline.Points))
+	assert.Equal(t, "1999-08", timeline.Points[0].Period)
+	assert.Equal(t, time.Date(1999, 8, 1, 0, 0, 0, 0, time.UTC), timeline.Points[0].Start)
+	assert.Equal(t, 6, timeline.Points[0].Movies)
+	assert.Equal(t, 8, timeline.Points[0].Disks)
+	assert.Equal(t, 742, timeline.Points[0].Length)
+	assert.Equal(t, 5, timeline.Points[1].Movies)
+	assert.Equal(t, 11, timeline.Points[1].TotalMovies)
+	assert.Equal(t, 13, timeline.Points[1].TotalDisks)
+	assert.Equal(t, 1215, timeline.Points[1].TotalLength)
+
+	// totals add up to the whole collection
+	last := timeline.Points[173]
+	assert.Equal(t, "2014-01", last.Period)
+	assert.Equal(t, 912, last.TotalMovies)
+	assert.Equal(t, 1731, last.TotalDisks)
+	assert.Equal(t, 215944, last.TotalLength)
+
+	// weeks start on monday and are labelled by ISO week
+	timeline, err = mdb.GetTimeline("week")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 754, len(timeline.Points))
+	assert.Equal(t, "1999-W30", timeline.Points[0].Period)
+	assert.Equal(t, time.Date(1999, 7, 26, 0, 0, 0, 0, time.UTC), timeline.Points[0].Start)
+	assert.Equal(t, "2014-W01", timeline.Points[753].Period)
+	assert.Equal(t, 912, timeline.Points[753].TotalMovies)
+
+	timeline, err = mdb.GetTimeline("year")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 16, len(timeline.Points))
+	assert.Equal(t, "1999", timeline.Points[0].Period)
+	assert.Equal(t, 27, timeline.Points[0].Movies)
+	assert.Equal(t, 63, timeline.Points[1].Movies)
+	assert.Equal(t, 90, timeline.Points[1].TotalMovies)
+
+	_, err = mdb.GetTimeline("decade")
+	assert.NotNil(t, err)
+}
+
+func Test_MovieDB_TimelineNulls(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	var disks, length int
+	if err := mdb.QueryRow(`select disks, length from movie_movie where id = 7`).Scan(&disks, &length); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Exec(`update movie_movie set disks = null, length = null where id = 7`); err != nil {
+		t.Fatal(err)
+	}
+
+	// movies without disks or length still count, with 0 of them
+	timeline, err := mdb.GetTimeline("year")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := timeline.Points[len(timeline.Points)-1]
+	assert.Equal(t, 912, last.TotalMovies)
+	assert.Equal(t, 1731-disks, last.TotalDisks)
+	assert.Equal(t, 215944-length, last.TotalLength)
+}
+
+func Test_MovieDB_TimelineBounds(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	// dates far off are put at the bounds of the timeline, instead of adding thousands of empty periods
+	if _, err := mdb.Exec(`update movie_movie set acquired_at = '0001-01-01 00:00:00' where id = 7`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Exec(`update movie_movie set acquired_at = '9999-01-01 00:00:00' where id = 8`); err != nil {
+		t.Fatal(err)
+	}
+
+	timeline, err := mdb.GetTimeline("year")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := timeline.Points[0], timeline.Points[len(timeline.Points)-1]
+	assert.Equal(t, "1888", first.Period)
+	assert.Equal(t, 1, first.Movies)
+	assert.Equal(t, time.Now().AddDate(0, 0, 1).Format("2006"), last.Period)
+	assert.Equal(t, 912, last.TotalMovies)
+	assert.Equal(t, time.Now().AddDate(0, 0, 1).Year()-1888+1, len(timeline.Points))
+}
+
+func Test_MovieDB_Breakdown(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	breakdown, err := mdb.GetBreakdown(BreakdownOptions{By: "genre", Sort: "count", Order: "desc", Top: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "genre", breakdown.By)
+	assert.Equal(t, 3, len(breakdown.Groups))
+	assert.Equal(t, &BreakdownGroup{Key: "1", Name: "Action", Count: 473, AvgScore: 3.32, AvgRating: 14.85, TotalLength: 96268}, breakdown.Groups[0])
+	assert.Equal(t, "Thriller", breakdown.Groups[1].Name)
+	assert.Equal(t, "Drama", breakdown.Groups[2].Name)
+
+	// same counts as the top 5 of the statistics, ties sorted by name
+	breakdown, err = mdb.GetBreakdown(BreakdownOptions{By: "director", Sort: "count", Order: "desc", Top: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Kenji Kamiyama", breakdown.Groups[0].Name)
+	assert.Equal(t, 17, breakdown.Groups[0].Count)
+	assert.Equal(t, "George Lucas", breakdown.Groups[1].Name)
+	assert.Equal(t, "Peter Jackson", breakdown.Groups[2].Name)
+	assert.Equal(t, "Steven Spielberg", breakdown.Groups[3].Name)
+
+	breakdown, err = mdb.GetBreakdown(BreakdownOptions{By: "decade", Sort: "name", Order: "asc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 6, len(breakdown.Groups))
+	assert.Equal(t, &BreakdownGroup{Key: "1960", Name: "1960s", Count: 12, AvgScore: 3.83, AvgRating: 15, TotalLength: 1517}, breakdown.Groups[0])
+	assert.Equal(t, "2010s", breakdown.Groups[5].Name)
+
+	breakdown, err = mdb.GetBreakdown(BreakdownOptions{By: "format", Sort: "length", Order: "desc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(breakdown.Groups))
+	assert.Equal(t, "Widescreen 16:9", breakdown.Groups[0].Name)
+	assert.Equal(t, 176389, breakdown.Groups[0].TotalLength)
+	assert.Equal(t, "", breakdown.Groups[2].Key)
+	assert.Equal(t, 3, breakdown.Groups[2].Count)
+
+	breakdown, err = mdb.GetBreakdown(BreakdownOptions{By: "genre", Sort: "name", Order: "desc", Top: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Western", breakdown.Groups[0].Name)
+	assert.Equal(t, "War", breakdown.Groups[1].Name)
+
+	_, err = mdb.GetBreakdown(BreakdownOptions{By: "studio"})
+	assert.NotNil(t, err)
+}
+
+func Test_MovieDB_BreakdownNulls(t *testing.T) {
+	resetDatabase()
+	mdb := getMovieDB()
+	defer mdb.Close()
+	defer resetDatabase()
+
+	if _, err := mdb.Exec(`update movie_movie set year = null, score = null, rating = null, length = null where id = 7`); err != nil {
+		t.Fatal(err)
+	}
+
+	// missing lengths count as 0, a missing year ends up in a decade of its own
+	for _, by := range BreakdownDimensions {
+		_, err := mdb.GetBreakdown(BreakdownOptions{By: by, Sort: "count"})
+		assert.Nil(t, err, by)
+	}
+	breakdown, err := mdb.GetBreakdown(BreakdownOptions{By: "decade", Sort: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 7, len(breakdown.Groups))
+	assert.Equal(t, &BreakdownGroup{Key: "", Name: "", Count: 1}, breakdown.Groups[0])
+	assert.Equal(t, "1960s", breakdown.Groups[1].Name)
+
+	// missing scores and ratings are left out of the averages
+	if _, err := mdb.Exec(`update movie_movie set year = 1966, score = null, rating = null where id = 7`); err != nil {
+		t.Fatal(err)
+	}
+	breakdown, err = mdb.GetBreakdown(BreakdownOptions{By: "decade", Sort: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &BreakdownGroup{Key: "1960", Name: "1960s", Count: 13, AvgScore: 3.83, AvgRating: 15, TotalLength: 1517}, breakdown.Groups[0])
+}
+
+func Test_MovieDB_StatisticsFiltered(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	stats, err := mdb.GetStatistics(MovieListingOptions{Query: []Query{NewQuery("disk_type", "BluRay")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 310, stats.Count)
+	assert.Equal(t, 1, len(stats.Movies))
+	assert.Equal(t, "BluRay", stats.Movies[0].DiskType)
+	assert.Equal(t, 0, stats.DvdMovies)
+	assert.Equal(t, 310, stats.BlurayMovies)
+	assert.Equal(t, 61691, stats.TotalLength)
+	assert.Equal(t, 199, stats.AvgLengthPerMovie)
+	assert.Equal(t, []*TypeCount{&TypeCount{"B", 310}}, stats.Regions)
+	assert.Equal(t, "Clint Eastwood", stats.TopActorsAndDirectors[0].Name)
+	assert.Equal(t, 15, stats.TopActorsAndDirectors[0].Count)
+
+	// top people only count the movies they appear in within the filtered ones
+	stats, err = mdb.GetStatistics(MovieListingOptions{Query: []Query{NewQuery("actor", "483")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 26, stats.Count)
+	assert.Equal(t, 25, stats.Actors)
+	assert.Equal(t, 11, stats.Directors)
+	assert.Equal(t, 36, stats.People)
+	assert.Equal(t, &PersonWithCount{483, "Bud Spencer", 26}, stats.TopActors[0])
+	assert.Equal(t, &PersonWithCount{484, "Terence Hill", 10}, stats.TopActors[1])
+	assert.Equal(t, &PersonWithCount{486, "Enzo Barboni", 6}, stats.TopDirectors[0])
+	assert.Equal(t, 0, len(stats.TopActorsAndDirectors))
+
+	stats, err = mdb.GetStatistics(MovieListingOptions{Query: []Query{NewQuery("genre", "1"), NewQuery("year", "2012")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 27, stats.Count)
+	assert.Equal(t, 1, stats.DvdMovies)
+	assert.Equal(t, 26, stats.BlurayMovies)
+	assert.Equal(t, &PersonWithCount{257, "Liam Neeson", 3}, stats.TopActors[0])
+
+	stats, err = mdb.GetStatistics(MovieListingOptions{Query: []Query{NewQuery("title", "does not exist")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, stats.Count)
+	assert.Equal(t, 0, stats.People)
+	assert.Equal(t, 0, len(stats.Movies))
+	assert.Equal(t, 0, stats.AvgLengthPerMovie)
+}
+
+func Test_MovieDB_PersonPath(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	path, err := mdb.GetPersonPath("7", "396")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Brad Pitt", path.From.Name)
+	assert.Equal(t, "Clint Eastwood", path.To.Name)
+	assert.Equal(t, 2, path.Degrees)
+	assert.Equal(t, &Person{Id: 7, Name: "Brad Pitt"}, path.Links[0].From)
+	assert.Equal(t, 213, path.Links[0].Movie.Id)
+	assert.Equal(t, &Person{Id: 338, Name: "Matt Damon"}, path.Links[0].To)
+	assert.Equal(t, &Person{Id: 338, Name: "Matt Damon"}, path.Links[1].From)
+	assert.Equal(t, "Invictus", path.Links[1].Movie.Title)
+	assert.Equal(t, &Person{Id: 396, Name: "Clint Eastwood"}, path.Links[1].To)
+
+	path, err = mdb.GetPersonPath("470", "331")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, path.Degrees)
+	assert.Equal(t, "Christopher Lee", path.Links[0].To.Name)
+	assert.Equal(t, "Liv Tyler", path.Links[1].To.Name)
+	assert.Equal(t, "Armageddon", path.Links[2].Movie.Title)
+
+	path, err = mdb.GetPersonPath("7", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, path.Degrees)
+	assert.Equal(t, 0, len(path.Links))
+
+	_, err = mdb.GetPersonPath("7", "99999")
+	assert.Equal(t, &NotFoundError{EntityPerson, "99999"}, err)
+
+	_, err = mdb.GetPersonPath("483", "7")
+	assert.Equal(t, &NotFoundError{"path", "from person 483 to person 7"}, err)
+
+	// the graph follows changes to movies
+	movie := &Movie{Title: "Vier Fäuste für Tyler Durden", Year: 2001, Disks: 1, Type: "DVD",
+		Actors: []*Person{&Person{Name: "Bud Spencer"}, &Person{Name: "Brad Pitt"}}}
+	if err := mdb.AddMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	path, err = mdb.GetPersonPath("483", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, path.Degrees)
+	assert.Equal(t, movie.Id, path.Links[0].Movie.Id)
+
+	if _, err := mdb.DeleteMovie(strconv.Itoa(movie.Id)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = mdb.GetPersonPath("483", "7")
+	assert.Equal(t, &NotFoundError{"path", "from person 483 to person 7"}, err)
+}
+
+func Test_MovieDB_Collaborators(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	collaborators, err := mdb.GetCollaborators("484")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 7, len(collaborators))
+	assert.Equal(t, &PersonWithCount{483, "Bud Spencer", 10}, collaborators[0])
+	assert.Equal(t, &PersonWithCount{486, "Enzo Barboni", 5}, collaborators[1])
+	assert.Equal(t, &PersonWithCount{487, "Sergio Corbucci", 2}, collaborators[2])
+
+	collaborators, err = mdb.GetCollaborators("470")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 11, len(collaborators))
+	assert.Equal(t, &PersonWithCount{369, "Desmond Llewelyn", 7}, collaborators[0])
+	assert.Equal(t, &PersonWithCount{462, "Bernard Lee", 4}, collaborators[1])
+
+	_, err = mdb.GetCollaborators("99999")
+	assert.Equal(t, &NotFoundError{EntityPerson, "99999"}, err)
+}
+
+func Test_MovieDB_SimilarMovies(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	similar, err := mdb.GetSimilarMovies("213", DefaultSimilarityWeights, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(similar))
+	assert.Equal(t, "Ocean's Twelve", similar[0].Movie.Title)
+	assert.Equal(t, 8.75, similar[0].Similarity)
+	assert.Equal(t, &SimilarityReason{"genre", []string{"Comedy", "Crime", "Thriller"}, 2}, similar[0].Reasons[0])
+	assert.Equal(t, &SimilarityReason{"director", []string{"Steven Soderbergh"}, 3}, similar[0].Reasons[1])
+	assert.Equal(t, "actor", similar[0].Reasons[2].Kind)
+	assert.Equal(t, 7, len(similar[0].Reasons[2].Matches))
+	assert.Equal(t, &SimilarityReason{"decade", []string{"2000s"}, 1}, similar[0].Reasons[4])
+	assert.Equal(t, "Ocean's Thirteen", similar[1].Movie.Title)
+	assert.Equal(t, 7.9, similar[1].Similarity)
+	assert.Equal(t, "Traffic", similar[2].Movie.Title)
+
+	// only directors count, ties are sorted by id
+	similar, err = mdb.GetSimilarMovies("213", SimilarityWeights{Director: 1}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 8, len(similar))
+	assert.Equal(t, 97, similar[0].Movie.Id)
+	assert.Equal(t, 110, similar[1].Movie.Id)
+	assert.Equal(t, 248, similar[2].Movie.Id)
+	assert.Equal(t, 1.0, similar[0].Similarity)
+
+	// filters restrict the candidates
+	similar, err = mdb.GetSimilarMovies("213", DefaultSimilarityWeights, 1, MovieListingOptions{Query: []Query{NewQuery("year", "1998")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(similar))
+	assert.Equal(t, "Out Of Sight", similar[0].Movie.Title)
+	assert.Equal(t, 4.733, similar[0].Similarity)
+
+	_, err = mdb.GetSimilarMovies("99999", DefaultSimilarityWeights, 0)
+	assert.Equal(t, &NotFoundError{EntityMovie, "99999"}, err)
+}
+
+func Test_MovieDB_RandomMovies(t *testing.T) {
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	// the same seed picks the same movies
+	pick, err := mdb.GetRandomMovies(RandomOptions{Count: 3, Seed: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(42), pick.Seed)
+	assert.Equal(t, 3, len(pick.Movies))
+	assert.Equal(t, 369, pick.Movies[0].Id)
+	assert.Equal(t, 125, pick.Movies[1].Id)
+	assert.Equal(t, 117, pick.Movies[2].Id)
+
+	again, err := mdb.GetRandomMovies(RandomOptions{Count: 3, Seed: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, pick.Movies, again.Movies)
+
+	// higher scores are more likely
+	pick, err = mdb.GetRandomMovies(RandomOptions{Count: 3, Seed: 42, Weights: []string{"score"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 369, pick.Movies[0].Id)
+	assert.Equal(t, 586, pick.Movies[1].Id)
+	assert.Equal(t, 3, pick.Movies[2].Id)
+
+	// recently picked movies are unlikely
+	pick, err = mdb.GetRandomMovies(RandomOptions{Count: 3, Seed: 42, Weights: []string{"recent"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, movie := range pick.Movies {
+		assert.NotContains(t, []int{369, 125, 117, 586, 3}, movie.Id)
+	}
+
+	// filters are honored and no movie is picked twice
+	options := MovieListingOptions{
+		Query: []Query{NewQuery("genre", "1"), NewQuery("max_length", "90"), NewQuery("min_score", "4")},
+	}
+	pick, err = mdb.GetRandomMovies(RandomOptions{Count: 100, Seed: 7}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 12, len(pick.Movies))
+	seen := make(map[int]bool)
+	for _, movie := range pick.Movies {
+		assert.False(t, seen[movie.Id])
+		assert.True(t, movie.Score >= 4)
+		seen[movie.Id] = true
+	}
+}
+
+func Test_MovieDB_RecentMovies(t *testing.T) {
+	resetDatabase()
+	defer resetDatabase()
+
+	mdb := getMovieDB()
+	defer mdb.Close()
+
+	// backfilled from the acquisition dates
+	movies, err := mdb.GetMovieListings(MovieListingOptions{
+		Query: []Query{NewQuery("since", "2013-12-01"), NewQuery("until", "2014-01-01T17:11:36Z")},
+		Sort:  []Sort{NewSort("created_at", "desc")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, len(movies))
+	assert.Equal(t, "The Wolverine", movies[0].Title)
+	assert.Equal(t, "James Bond 007: Skyfall", movies[4].Title)
+
+	changes, err := mdb.GetRecentMovies(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, 914, changes[0].Movie.Id)
+	assert.Equal(t, "added", changes[0].Change)
+	assert.Equal(t, time.Date(2014, 1, 1, 17, 11, 36, 0, time.UTC), changes[0].CreatedAt)
+	assert.Equal(t, 913, changes[1].Movie.Id)
+
+	// saving a movie updates it
+	movie, err := mdb.GetMovie("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := *movie.CreatedAt
+	if err := mdb.SaveMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	movie, err = mdb.GetMovie("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, created, *movie.CreatedAt)
+	assert.True(t, movie.UpdatedAt.After(created))
+
+	// adding one creates it
+	added := &Movie{Title: "Brand New", Year: 2015, Disks: 1, Type: "DVD"}
+	if err := mdb.AddMovie(added); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, added.CreatedAt, added.UpdatedAt)
+
+	changes, err = mdb.GetRecentMovies(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, added.Id, changes[0].Movie.Id)
+	assert.Equal(t, "added", changes[0].Change)
+	assert.Equal(t, 1, changes[1].Movie.Id)
+	assert.Equal(t, "updated", changes[1].Change)
+	assert.Equal(t, 914, changes[2].Movie.Id)
+
+	// filters apply too
+	changes, err = mdb.GetRecentMovies(0, MovieListingOptions{Query: []Query{NewQuery("year", "2015")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "Brand New", changes[0].Movie.Title)
+
+	// or sorted by when they were added, updates don't matter then
+	changes, err = mdb.GetRecentMovies(2, MovieListingOptions{Sort: []Sort{NewSort("created_at", "asc")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(changes))
+	assert.True(t, changes[0].CreatedAt.Before(changes[1].CreatedAt) || changes[0].CreatedAt.Equal(changes[1].CreatedAt))
+	assert.Equal(t, 1999, changes[0].CreatedAt.Year())
+
+	// with what feeds need to show
+	changes, err = mdb.GetRecentMovies(1, MovieListingOptions{Sort: []Sort{NewSort("created_at", "desc")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Brand New", changes[0].Movie.Title)
+	changes, err = mdb.GetRecentMovies(1, MovieListingOptions{Query: []Query{NewQuery("year", "2012")}, Sort: []Sort{NewSort("created_at", "desc")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "argo.jpg", changes[0].Picture)
+	assert.Contains(t, changes[0].Description, "Acting under the cover")
+}
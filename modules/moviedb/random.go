@@ -0,0 +1,76 @@
+package moviedb
+
+import (
+	"math"
+	"math/rand"
+	gosort "sort"
+	"time"
+)
+
+var RandomWeights = []string{"score", "recent"}
+
+// recentlyPicked is how long it takes for a picked movie to get its full chance again
+const recentlyPicked = 30 * 24 * time.Hour
+
+type RandomOptions struct {
+	Count   int
+	Seed    int64
+	Weights []string // any of RandomWeights, multiplied with each other
+}
+
+// GetRandomMovies picks movies matching the listing options at random, without picking any movie twice.
+// Weights bias the pick towards movies with a higher score, or towards movies that were not picked
+// within the last 30 days. The same seed gives the same movies, as long as neither the collection
+// nor the recent picks changed. Picks are remembered in memory only.
+func (mdb *movieDB) GetRandomMovies(random RandomOptions, opt ...MovieListingOptions) (*RandomPick, error) {
+	candidates, err := mdb.GetMovieListings(opt...)
+	if err != nil {
+		return nil, err
+	}
+
+	mdb.picksLock.Lock()
+	defer mdb.picksLock.Unlock()
+	if mdb.picks == nil {
+		mdb.picks = make(map[int]time.Time)
+	}
+
+	// weighted sampling without replacement: every movie draws a key of u^(1/weight),
+	// the movies with the highest keys are picked
+	now := time.Now()
+	r := rand.New(rand.NewSource(random.Seed))
+	keys := make(map[int]float64)
+	for _, m := range candidates {
+		weight := 1.0
+		for _, w := range random.Weights {
+			switch w {
+			case "score":
+				weight *= float64(m.Score + 1)
+			case "recent":
+				if picked, ok := mdb.picks[m.Id]; ok && now.Sub(picked) < recentlyPicked {
+					weight *= math.Max(0.01, float64(now.Sub(picked))/float64(recentlyPicked))
+				}
+			}
+		}
+		keys[m.Id] = math.Pow(r.Float64(), 1/weight)
+	}
+	gosort.Sort(randomListings{candidates, keys})
+
+	if len(candidates) > random.Count {
+		candidates = candidates[:random.Count]
+	}
+	for _, m := range candidates {
+		mdb.picks[m.Id] = now
+	}
+	return &RandomPick{Seed: random.Seed, Movies: candidates}, nil
+}
+
+type randomListings struct {
+	movies []*MovieListing
+	keys   map[int]float64
+}
+
+func (r randomListings) Len() int      { return len(r.movies) }
+func (r randomListings) Swap(i, j int) { r.movies[i], r.movies[j] = r.movies[j], r.movies[i] }
+func (r randomListings) Less(i, j int) bool {
+	return r.keys[r.movies[i].Id] > r.keys[r.movies[j].Id]
+}
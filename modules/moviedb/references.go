@@ -0,0 +1,82 @@
+package moviedb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// reference tables holding the canonical codes of movie_movie columns
+const (
+	ReferenceFormat   = "movie_format"
+	ReferenceDiskType = "movie_disk_type"
+	ReferenceRegion   = "movie_region"
+)
+
+func (mdb *movieDB) GetFormats() ([]*ReferenceCode, error) {
+	return getReferenceCodes(mdb, ReferenceFormat)
+}
+
+func (mdb *movieDB) GetDiskTypes() ([]*ReferenceCode, error) {
+	return getReferenceCodes(mdb, ReferenceDiskType)
+}
+
+func (mdb *movieDB) GetRegions() ([]*ReferenceCode, error) {
+	return getReferenceCodes(mdb, ReferenceRegion)
+}
+
+func getReferenceCodes(q querier, table string) ([]*ReferenceCode, error) {
+	rows, err := q.Query(fmt.Sprintf(`select code, name from %s order by position asc, code asc`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := []*ReferenceCode{}
+	for rows.Next() {
+		var r ReferenceCode
+		if err := rows.Scan(&r.Code, &r.Name); err != nil {
+			return nil, err
+		}
+		rs = append(rs, &r)
+	}
+	return rs, nil
+}
+
+// validateReferences makes sure format, type and region are known canonical codes
+func (c *movieChecker) validateReferences(movie *Movie) ([]*Violation, error) {
+	references := []struct {
+		field, value, table string
+	}{
+		{"format", movie.Format, ReferenceFormat},
+		{"region", movie.Region, ReferenceRegion},
+		{"type", movie.Type, ReferenceDiskType},
+	}
+
+	violations := []*Violation{}
+	for _, ref := range references {
+		if len(ref.value) == 0 {
+			continue
+		}
+		codes, err := c.referenceCodes(ref.table)
+		if err != nil {
+			return nil, err
+		}
+
+		var known []string
+		found := false
+		for _, code := range codes {
+			known = append(known, code.Code)
+			found = found || code.Code == ref.value
+		}
+		if !found {
+			violations = append(violations, &Violation{ref.field, "reference", fmt.Sprintf("must be one of: %s", strings.Join(known, ", "))})
+		}
+	}
+	return violations, nil
+}
+
+// nullString stores empty codes as NULL, as they would violate the foreign keys
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: len(value) > 0}
+}
@@ -0,0 +1,170 @@
+package moviedb
+
+import (
+	"database/sql"
+	"fmt"
+	gosort "sort"
+	"strconv"
+)
+
+// SimilarityWeights says how much each kind of overlap counts towards the similarity of two movies
+type SimilarityWeights struct {
+	Genre    float64
+	Director float64
+	Actor    float64
+	Decade   float64
+	Language float64
+}
+
+var DefaultSimilarityWeights = SimilarityWeights{Genre: 2, Director: 3, Actor: 2, Decade: 1, Language: 1}
+
+// similarityQueries select the genres, people and languages of all movies, along with their names
+var similarityQueries = map[string]string{
+	"genre": `select mlg.movie_id, mg.id, mg.name
+		from movie_link_genre mlg join movie_genre mg on (mg.id = mlg.genre_id)`,
+	"director": `select mld.movie_id, mp.id, mp.name
+		from movie_link_director mld join movie_people mp on (mp.id = mld.person_id)`,
+	"actor": `select mla.movie_id, mp.id, mp.name
+		from movie_link_actor mla join movie_people mp on (mp.id = mla.person_id)`,
+	"language": `select mll.movie_id, ml.id, ml.name
+		from movie_link_language mll join movie_language ml on (ml.id = mll.language_id)`,
+}
+
+// movieFeatures maps a kind like "genre" to the ids and names a movie has of it
+type movieFeatures map[string]map[int]string
+
+// GetSimilarMovies ranks all other movies matching the query options by how similar they are to the given one.
+// Genres, directors, actors and languages count with the share of them both movies have in common
+// (their intersection divided by their union), the decade counts fully if it is the same.
+// Each kind is multiplied by its weight, movies without anything in common are left out.
+// At most limit movies are returned, all of them if limit is 0.
+func (mdb *movieDB) GetSimilarMovies(id string, weights SimilarityWeights, limit int, opt ...MovieListingOptions) ([]*SimilarMovie, error) {
+	var options MovieListingOptions
+	if len(opt) > 0 {
+		options = opt[0]
+	}
+
+	movieId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+	var year int
+	if err := mdb.QueryRow(`select year from movie_movie where id = $1`, movieId).Scan(&year); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &NotFoundError{EntityMovie, id}
+		}
+		return nil, err
+	}
+
+	features := make(map[int]movieFeatures)
+	for kind, query := range similarityQueries {
+		rows, err := mdb.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var movie, id int
+			var name string
+			if err := rows.Scan(&movie, &id, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if features[movie] == nil {
+				features[movie] = make(movieFeatures)
+			}
+			if features[movie][kind] == nil {
+				features[movie][kind] = make(map[int]string)
+			}
+			features[movie][kind][id] = name
+		}
+		rows.Close()
+	}
+
+	filter, params := mdb.movieFilter(options.Query)
+	rows, err := mdb.Query(`select mm.id, mm.title, mm.year, mm.score, mm.rating from movie_movie mm `+filter, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kinds := []struct {
+		name   string
+		weight float64
+	}{
+		{"genre", weights.Genre},
+		{"director", weights.Director},
+		{"actor", weights.Actor},
+		{"language", weights.Language},
+	}
+
+	similar := []*SimilarMovie{}
+	for rows.Next() {
+		var m MovieListing
+		if err := rows.Scan(&m.Id, &m.Title, &m.Year, &m.Score, &m.Rating); err != nil {
+			return nil, err
+		}
+		if m.Id == movieId {
+			continue
+		}
+
+		s := &SimilarMovie{Movie: &m, Reasons: []*SimilarityReason{}}
+		for _, kind := range kinds {
+			if kind.weight == 0 {
+				continue
+			}
+			matches, share := overlap(features[movieId][kind.name], features[m.Id][kind.name])
+			if len(matches) > 0 {
+				s.Reasons = append(s.Reasons, &SimilarityReason{kind.name, matches, round(kind.weight*share, 3)})
+			}
+		}
+		if weights.Decade != 0 && year > 0 && year/10 == m.Year/10 {
+			s.Reasons = append(s.Reasons, &SimilarityReason{"decade", []string{fmt.Sprintf("%ds", year/10*10)}, round(weights.Decade, 3)})
+		}
+
+		for _, reason := range s.Reasons {
+			s.Similarity += reason.Score
+		}
+		s.Similarity = round(s.Similarity, 3)
+		if s.Similarity > 0 {
+			similar = append(similar, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	gosort.Sort(similarMovies(similar))
+	if limit > 0 && len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+// overlap returns the sorted names both sets have in common and their share of all elements
+func overlap(a, b map[int]string) ([]string, float64) {
+	var matches []string
+	union := len(a)
+	for id, name := range b {
+		if _, ok := a[id]; ok {
+			matches = append(matches, name)
+		} else {
+			union++
+		}
+	}
+	if len(matches) == 0 {
+		return nil, 0
+	}
+	gosort.Strings(matches)
+	return matches, float64(len(matches)) / float64(union)
+}
+
+type similarMovies []*SimilarMovie
+
+func (s similarMovies) Len() int      { return len(s) }
+func (s similarMovies) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s similarMovies) Less(i, j int) bool {
+	if s[i].Similarity != s[j].Similarity {
+		return s[i].Similarity > s[j].Similarity
+	}
+	return s[i].Movie.Id < s[j].Movie.Id
+}
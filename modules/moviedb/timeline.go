@@ -0,0 +1,114 @@
+package moviedb
+
+import (
+	"fmt"
+	"time"
+)
+
+var TimelineBuckets = []string{"week", "month", "year"}
+
+// timelineStart is where timelines begin at the earliest, like acquisition dates are validated.
+// Older dates, and dates after tomorrow, could only be typos and would make for a lot of empty periods.
+var timelineStart = time.Date(1888, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// bucketStart returns the beginning of the bucket a point in time falls into, weeks start on monday
+func bucketStart(bucket string, t time.Time) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextBucket(bucket string, start time.Time) time.Time {
+	switch bucket {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "year":
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func bucketLabel(bucket string, start time.Time) string {
+	switch bucket {
+	case "week":
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "year":
+		return start.Format("2006")
+	}
+	return start.Format("2006-01")
+}
+
+// GetTimeline returns how the collection grew over time, grouped by week, month or year of acquisition.
+// Periods without any new movies are included, so the points form a continuous series.
+func (mdb *movieDB) GetTimeline(bucket string) (*Timeline, error) {
+	valid := false
+	for _, b := range TimelineBuckets {
+		valid = valid || b == bucket
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown timeline bucket: %s", bucket)
+	}
+
+	rows, err := mdb.Query(`select acquired_at, coalesce(disks, 0), coalesce(length, 0) from movie_movie
+		where acquired_at is not null order by acquired_at asc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timeline := &Timeline{Bucket: bucket, Points: []*TimelinePoint{}}
+	timelineEnd := time.Now().AddDate(0, 0, 1)
+	var point *TimelinePoint
+	for rows.Next() {
+		var acquired time.Time
+		var disks, length int
+		if err := rows.Scan(&acquired, &disks, &length); err != nil {
+			return nil, err
+		}
+
+		if acquired.Before(timelineStart) {
+			acquired = timelineStart
+		} else if acquired.After(timelineEnd) {
+			acquired = timelineEnd
+		}
+
+		start := bucketStart(bucket, acquired)
+		for point == nil || point.Start.Before(start) {
+			next := start
+			if point != nil {
+				next = nextBucket(bucket, point.Start)
+			}
+			point = timelinePoint(bucket, next, point)
+			timeline.Points = append(timeline.Points, point)
+		}
+
+		point.Movies++
+		point.Disks += disks
+		point.Length += length
+		point.TotalMovies++
+		point.TotalDisks += disks
+		point.TotalLength += length
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return timeline, nil
+}
+
+// timelinePoint starts a new period, carrying over the totals of the previous one
+func timelinePoint(bucket string, start time.Time, previous *TimelinePoint) *TimelinePoint {
+	p := &TimelinePoint{Period: bucketLabel(bucket, start), Start: start}
+	if previous != nil {
+		p.TotalMovies = previous.TotalMovies
+		p.TotalDisks = previous.TotalDisks
+		p.TotalLength = previous.TotalLength
+	}
+	return p
+}
@@ -0,0 +1,119 @@
+package moviedb
+
+import "database/sql"
+
+// Transaction groups changes to several movies, none of them are visible to others until Commit.
+// Validation and duplicate checks see the movies added, changed and deleted within the transaction too.
+type Transaction interface {
+	AddMovie(*Movie) error
+	ForceAddMovie(*Movie) error
+	SaveMovie(*Movie) error
+	DeleteMovie(id string) (int64, error)
+	Savepoint(name string) error
+	RollbackTo(name string) error
+	Commit() error
+	Rollback() error
+}
+
+type movieTx struct {
+	mdb *movieDB
+	tx  *sql.Tx
+}
+
+func (mdb *movieDB) BeginTransaction() (Transaction, error) {
+	tx, err := mdb.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &movieTx{mdb, tx}, nil
+}
+
+// checker reads the collection through the transaction, anew for every check as it changes with every movie saved
+func (t *movieTx) checker() Checker {
+	return newChecker(t.tx)
+}
+
+// withinTransaction commits if fn succeeds and rolls back otherwise
+func (mdb *movieDB) withinTransaction(fn func(Transaction) error) error {
+	tx, err := mdb.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddMovie refuses to add a movie that looks like a duplicate of an existing one, returning a *DuplicateError
+func (t *movieTx) AddMovie(movie *Movie) error {
+	if err := t.checker().ValidateMovie(movie); err != nil {
+		return unavailable(err)
+	}
+
+	candidates, err := t.checker().FindDuplicates(movie)
+	if err != nil {
+		return unavailable(err)
+	}
+	if len(candidates) > 0 {
+		return &DuplicateError{candidates}
+	}
+	return t.ForceAddMovie(movie)
+}
+
+func (t *movieTx) ForceAddMovie(movie *Movie) error {
+	if err := t.checker().ValidateMovie(movie); err != nil {
+		return unavailable(err)
+	}
+
+	newId, err := nextMovieId(t.tx)
+	if err != nil {
+		return unavailable(err)
+	}
+
+	// set movie_id and save it, which will check if movie already exists (it won't) and then inserts it
+	movie.Id = newId
+	return unavailable(saveMovie(t.tx, movie))
+}
+
+func (t *movieTx) SaveMovie(movie *Movie) error {
+	if err := t.checker().ValidateMovie(movie); err != nil {
+		return unavailable(err)
+	}
+	return unavailable(saveMovie(t.tx, movie))
+}
+
+func (t *movieTx) DeleteMovie(id string) (int64, error) {
+	rows, err := deleteMovie(t.tx, id)
+	return rows, unavailable(err)
+}
+
+// Savepoint marks a state of the transaction to return to with RollbackTo, undoing the changes since then
+// without ending the transaction. Postgres accepts no more statements after an error until that happened.
+func (t *movieTx) Savepoint(name string) error {
+	_, err := t.tx.Exec("SAVEPOINT " + name)
+	return unavailable(err)
+}
+
+func (t *movieTx) RollbackTo(name string) error {
+	_, err := t.tx.Exec("ROLLBACK TO SAVEPOINT " + name)
+	return unavailable(err)
+}
+
+func (t *movieTx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return unavailable(err)
+	}
+	t.mdb.invalidateGraph()
+	return nil
+}
+
+// Rollback is a no-op after Commit, so it can always be deferred
+func (t *movieTx) Rollback() error {
+	if err := t.tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		return unavailable(err)
+	}
+	return nil
+}
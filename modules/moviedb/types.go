@@ -2,8 +2,10 @@ package moviedb
 
 import (
 	"database/sql"
+	"encoding/xml"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -39,6 +41,38 @@ type MovieType struct {
 	Count    int    `json:"count" xml:"count"`
 }
 
+type Timeline struct {
+	Bucket string           `json:"bucket" xml:"bucket,attr"`
+	Points []*TimelinePoint `json:"points" xml:"points"`
+}
+
+type TimelinePoint struct {
+	Period      string    `json:"period" xml:"period,attr"`
+	Start       time.Time `json:"start" xml:"start"`
+	Movies      int       `json:"movies" xml:"movies"`
+	Disks       int       `json:"disks" xml:"disks"`
+	Length      int       `json:"length" xml:"length"`
+	TotalMovies int       `json:"total_movies" xml:"total_movies"`
+	TotalDisks  int       `json:"total_disks" xml:"total_disks"`
+	TotalLength int       `json:"total_length" xml:"total_length"`
+}
+
+type Breakdown struct {
+	By     string            `json:"by" xml:"by,attr"`
+	Sort   string            `json:"sort" xml:"sort,attr"`
+	Order  string            `json:"order" xml:"order,attr"`
+	Groups []*BreakdownGroup `json:"groups" xml:"groups"`
+}
+
+type BreakdownGroup struct {
+	Key         string  `json:"key" xml:"key,attr"`
+	Name        string  `json:"name" xml:"name"`
+	Count       int     `json:"count" xml:"count"`
+	AvgScore    float64 `json:"avg_score" xml:"avg_score"`
+	AvgRating   float64 `json:"avg_rating" xml:"avg_rating"`
+	TotalLength int     `json:"total_length" xml:"total_length"`
+}
+
 type PersonWithCount struct {
 	Id    int    `json:"id" xml:"id,attr"`
 	Name  string `json:"name" xml:"name"`
@@ -51,29 +85,53 @@ type TypeCount struct {
 }
 
 type Movie struct {
-	Id          int            `json:"id" xml:"id,attr"`
-	Title       string         `json:"title" xml:"title"`
-	Alttitle    sql.NullString `json:"alttitle" xml:"alttitle"`
-	Year        int            `json:"year" xml:"year"`
-	Description string         `json:"description" xml:"description"`
-	Format      string         `json:"format" xml:"format"`
-	Length      int            `json:"length" xml:"length"`
-	Region      string         `json:"region" xml:"region"`
-	Rating      int            `json:"rating" xml:"rating"`
-	Disks       int            `json:"disks" xml:"disks"`
-	Score       int            `json:"score" xml:"year"`
-	Picture     string         `json:"picture" xml:"picture"`
-	Type        string         `json:"type" xml:"type"`
-	Languages   []*Language    `json:"languages" xml:"languages"`
-	Genres      []*Genre       `json:"genres" xml:"genres"`
-	Actors      []*Person      `json:"actors" xml:"actors"`
-	Directors   []*Person      `json:"directors" xml:"directors"`
+	Id          int               `json:"id" xml:"id,attr"`
+	Title       string            `json:"title" xml:"title"`
+	Alttitle    sql.NullString    `json:"alttitle" xml:"alttitle"` // legacy, first of Alttitles
+	Alttitles   []*AlternateTitle `json:"alttitles" xml:"alttitles"`
+	Year        int               `json:"year" xml:"year"`
+	Description string            `json:"description" xml:"description"`
+	Format      string            `json:"format" xml:"format"`
+	Length      int               `json:"length" xml:"length"`
+	Region      string            `json:"region" xml:"region"`
+	Rating      int               `json:"rating" xml:"rating"`
+	Disks       int               `json:"disks" xml:"disks"`
+	Score       int               `json:"score" xml:"score"`
+	Picture     string            `json:"picture" xml:"picture"`
+	Type        string            `json:"type" xml:"type"`
+	AcquiredAt  *time.Time        `json:"acquired_at,omitempty" xml:"acquired_at,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty" xml:"created_at,omitempty"` // set by SaveMovie
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty" xml:"updated_at,omitempty"` // set by SaveMovie
+	Languages   []*Language       `json:"languages" xml:"languages"`
+	Genres      []*Genre          `json:"genres" xml:"genres"`
+	Actors      []*Person         `json:"actors" xml:"actors"`
+	Directors   []*Person         `json:"directors" xml:"directors"`
+	ExternalIds []*ExternalId     `json:"external_ids,omitempty" xml:"external_ids,omitempty"`
 }
 
 func (m *Movie) String() string {
 	return fmt.Sprintf("[%d] %s (%d)", m.Id, m.Title, m.Year)
 }
 
+// MarshalXML renders the legacy alttitle as plain text, left out if there is none.
+// Id, Title and Alttitle shadow the fields of the embedded movie, in the same order as in Movie.
+func (m Movie) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	type movie Movie
+	return encoder.EncodeElement(struct {
+		Id       int    `xml:"id,attr"`
+		Title    string `xml:"title"`
+		Alttitle string `xml:"alttitle,omitempty"`
+		movie
+	}{m.Id, m.Title, m.Alttitle.String, movie(m)}, start)
+}
+
+type AlternateTitle struct {
+	Id       int       `json:"id" xml:"id,attr"`
+	Title    string    `json:"title" xml:"title"`
+	Type     string    `json:"type" xml:"type,attr"`
+	Language *Language `json:"language,omitempty" xml:"language,omitempty"`
+}
+
 type Language struct {
 	Id         int    `json:"id" xml:"id,attr"`
 	Name       string `json:"name" xml:"name"`
@@ -87,15 +145,81 @@ type Genre struct {
 }
 
 type Person struct {
-	Id   int    `json:"id" xml:"id,attr"`
+	Id          int           `json:"id" xml:"id,attr"`
+	Name        string        `json:"name" xml:"name"`
+	ExternalIds []*ExternalId `json:"external_ids,omitempty" xml:"external_ids,omitempty"`
+}
+
+type PersonPath struct {
+	From    *Person     `json:"from" xml:"from"`
+	To      *Person     `json:"to" xml:"to"`
+	Degrees int         `json:"degrees" xml:"degrees,attr"`
+	Links   []*PathLink `json:"links" xml:"links"`
+}
+
+// PathLink connects two people through a movie they both were in
+type PathLink struct {
+	From  *Person       `json:"from" xml:"from"`
+	Movie *MovieListing `json:"movie" xml:"movie"`
+	To    *Person       `json:"to" xml:"to"`
+}
+
+type RandomPick struct {
+	Seed   int64           `json:"seed" xml:"seed,attr"`
+	Movies []*MovieListing `json:"movies" xml:"movies"`
+}
+
+// MovieChange is a movie that was recently added or updated
+type MovieChange struct {
+	Movie       *MovieListing `json:"movie" xml:"movie"`
+	Change      string        `json:"change" xml:"change,attr"` // added or updated
+	CreatedAt   time.Time     `json:"created_at" xml:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at" xml:"updated_at"`
+	Description string        `json:"-" xml:"-"` // for feeds, not part of the listing
+	Picture     string        `json:"-" xml:"-"`
+}
+
+type SimilarMovie struct {
+	Movie      *MovieListing       `json:"movie" xml:"movie"`
+	Similarity float64             `json:"similarity" xml:"similarity,attr"`
+	Reasons    []*SimilarityReason `json:"reasons" xml:"reasons"`
+}
+
+// SimilarityReason is something two movies have in common, and how much it adds to their similarity
+type SimilarityReason struct {
+	Kind    string   `json:"kind" xml:"kind,attr"`
+	Matches []string `json:"matches" xml:"matches"`
+	Score   float64  `json:"score" xml:"score,attr"`
+}
+
+type ExternalId struct {
+	Provider   string `json:"provider" xml:"provider,attr"`
+	ExternalId string `json:"id" xml:"id"`
+}
+
+type ReferenceCode struct {
+	Code string `json:"code" xml:"code,attr"`
 	Name string `json:"name" xml:"name"`
 }
 
+type Duplicate struct {
+	Id         int      `json:"id" xml:"id,attr"`
+	Title      string   `json:"title" xml:"title"`
+	Year       int      `json:"year" xml:"year"`
+	Reasons    []string `json:"reasons,omitempty" xml:"reasons>reason,omitempty"`
+	Similarity float64  `json:"similarity,omitempty" xml:"similarity,omitempty"` // of the titles, only set by FindSimilar
+}
+
+type DuplicatePair struct {
+	Movies  []*Duplicate `json:"movies" xml:"movies>movie"`
+	Reasons []string     `json:"reasons" xml:"reasons>reason"`
+}
+
 type MovieListing struct {
 	Id     int    `json:"id" xml:"id,attr"`
 	Title  string `json:"title" xml:"title"`
 	Year   int    `json:"year" xml:"year"`
-	Score  int    `json:"score" xml:"year"`
+	Score  int    `json:"score" xml:"score"`
 	Rating int    `json:"rating" xml:"rating"`
 }
 
@@ -132,9 +256,24 @@ func ParseMovieListingOptions(req *http.Request) MovieListingOptions {
 		options.Query = querylist
 	}
 
+	// since and until restrict movies to the ones added within that time
+	for _, key := range []string{"since", "until"} {
+		for _, value := range q[key] {
+			options.Query = append(options.Query, NewQuery(key, value))
+		}
+	}
+
 	return options
 }
 
+// ParseTime reads timestamps given in RFC 3339 format, or just dates like 2006-01-02 in UTC
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
 type Sort interface {
 	Field() string
 	Order() string
@@ -158,7 +297,8 @@ func (s *sort) SetOrderBy(field string, order string) {
 	case field == "title" || field == "year" ||
 		field == "score" || field == "rating" ||
 		field == "format" || field == "disk_region" ||
-		field == "length" || field == "disks" || field == "disk_type":
+		field == "length" || field == "disks" || field == "disk_type" ||
+		field == "created_at" || field == "updated_at":
 		s.field = field
 	default:
 		s.field = "id"
@@ -204,8 +344,17 @@ func (q *query) SetQuery(query string, value string) {
 		query == "language" || query == "genre" ||
 		query == "format" || query == "disks" ||
 		query == "char" || query == "search" ||
-		query == "actor" || query == "director" || query == "length":
+		query == "actor" || query == "director" || query == "length" ||
+		query == "since" || query == "until":
 		q.query = query
+	case strings.HasPrefix(query, "min_") || strings.HasPrefix(query, "max_"):
+		// lower and upper bounds of numeric fields, like min_score or max_length
+		switch query[4:] {
+		case "year", "score", "rating", "length", "disks":
+			q.query = query
+		default:
+			q.query = "id"
+		}
 	default:
 		q.query = "id"
 	}
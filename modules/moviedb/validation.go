@@ -0,0 +1,255 @@
+package moviedb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Rules maps field paths to the rules their values have to satisfy.
+// Paths use the json field names, elements of lists are addressed with "[]", like "actors[].name".
+type Rules map[string][]Rule
+
+// Rule checks a single value and returns a violation message, or an empty string if the value is fine
+type Rule struct {
+	Name  string
+	Check func(value interface{}) string
+}
+
+var movieRules = Rules{
+	"title":                               {Required, MaxLength(255)},
+	"year":                                {Range(1888, 2100)},
+	"length":                              {Min(0)},
+	"rating":                              {Range(0, 21)},
+	"score":                               {Range(0, 5)},
+	"disks":                               {Min(1)},
+	"type":                                {Required},
+	"acquired_at":                         {DateRange(1888)},
+	"alttitles[].title":                   {Required, MaxLength(255)},
+	"alttitles[].type":                    {OneOf(AlttitleTypes...)},
+	"genres[].name":                       {Required},
+	"actors[].name":                       {Required},
+	"directors[].name":                    {Required},
+	"external_ids[].provider":             {Required},
+	"actors[].external_ids[].provider":    {Required},
+	"directors[].external_ids[].provider": {Required},
+}
+
+var Required = Rule{"required", func(value interface{}) string {
+	if reflect.ValueOf(value).Len() == 0 {
+		return "must not be empty"
+	}
+	return ""
+}}
+
+func MaxLength(max int) Rule {
+	return Rule{"length", func(value interface{}) string {
+		if len([]rune(value.(string))) > max {
+			return fmt.Sprintf("must not be longer than %d characters", max)
+		}
+		return ""
+	}}
+}
+
+func Min(min int) Rule {
+	return Rule{"range", func(value interface{}) string {
+		if value.(int) < min {
+			return fmt.Sprintf("must be at least %d", min)
+		}
+		return ""
+	}}
+}
+
+func Range(min, max int) Rule {
+	return Rule{"range", func(value interface{}) string {
+		if v := value.(int); v < min || v > max {
+			return fmt.Sprintf("must be between %d and %d", min, max)
+		}
+		return ""
+	}}
+}
+
+// DateRange limits a date to the years since min until tomorrow, missing dates are left to Required
+func DateRange(min int) Rule {
+	return Rule{"range", func(value interface{}) string {
+		t, ok := value.(*time.Time)
+		if !ok || t == nil {
+			return ""
+		}
+		if t.Before(time.Date(min, 1, 1, 0, 0, 0, 0, time.UTC)) || t.After(time.Now().AddDate(0, 0, 1)) {
+			return fmt.Sprintf("must be between %d-01-01 and tomorrow", min)
+		}
+		return ""
+	}}
+}
+
+// OneOf restricts a string to a list of values, empty strings are left to Required
+func OneOf(values ...string) Rule {
+	return Rule{"enum", func(value interface{}) string {
+		v := value.(string)
+		if len(v) == 0 {
+			return ""
+		}
+		for _, allowed := range values {
+			if v == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of: %s", strings.Join(values, ", "))
+	}}
+}
+
+type Violation struct {
+	Field   string `json:"field" xml:"field,attr"`
+	Rule    string `json:"rule" xml:"rule,attr"`
+	Message string `json:"message" xml:",chardata"`
+}
+
+type ValidationError struct {
+	Violations []*Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, fmt.Sprintf("%s %s", v.Field, v.Message))
+	}
+	return fmt.Sprintf("invalid movie: %s", strings.Join(messages, "; "))
+}
+
+// ValidateMovie checks a movie against all field rules and makes sure referenced codes and languages exist.
+// All violations are collected and returned together as a *ValidationError.
+func (mdb *movieDB) ValidateMovie(movie *Movie) error {
+	return mdb.NewChecker().ValidateMovie(movie)
+}
+
+func (c *movieChecker) ValidateMovie(movie *Movie) error {
+	violations := validateStruct(reflect.ValueOf(movie).Elem(), "", "", movieRules)
+	violations = append(violations, validateExternalIds(movie)...)
+
+	referenceViolations, err := c.validateReferences(movie)
+	if err != nil {
+		return err
+	}
+	violations = append(violations, referenceViolations...)
+
+	languageViolations, err := c.validateLanguages(movie)
+	if err != nil {
+		return err
+	}
+	violations = append(violations, languageViolations...)
+
+	if len(violations) > 0 {
+		return &ValidationError{violations}
+	}
+	return nil
+}
+
+// validateStruct walks through all fields of a struct, descending into lists and referenced structs.
+// path is the actual location of a value, like "actors[2].name", key its lookup key in rules, like "actors[].name".
+func validateStruct(v reflect.Value, path, key string, rules Rules) []*Violation {
+	violations := []*Violation{}
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		fieldPath, fieldKey := joinPath(path, name), joinPath(key, name)
+		field := v.Field(i)
+
+		for _, rule := range rules[fieldKey] {
+			if message := rule.Check(field.Interface()); len(message) > 0 {
+				violations = append(violations, &Violation{fieldPath, rule.Name, message})
+			}
+		}
+
+		switch {
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Ptr:
+			for j := 0; j < field.Len(); j++ {
+				elemPath := fmt.Sprintf("%s[%d]", fieldPath, j)
+				if field.Index(j).IsNil() {
+					violations = append(violations, &Violation{elemPath, "required", "must not be null"})
+					continue
+				}
+				violations = append(violations, validateStruct(field.Index(j).Elem(), elemPath, fieldKey+"[]", rules)...)
+			}
+		case field.Kind() == reflect.Ptr && !field.IsNil():
+			violations = append(violations, validateStruct(field.Elem(), fieldPath, fieldKey, rules)...)
+		}
+	}
+	return violations
+}
+
+func joinPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+func validateExternalIds(movie *Movie) []*Violation {
+	violations := externalIdViolations(EntityMovie, "external_ids", movie.ExternalIds)
+	for i, actor := range movie.Actors {
+		if actor != nil {
+			violations = append(violations, externalIdViolations(EntityPerson, fmt.Sprintf("actors[%d].external_ids", i), actor.ExternalIds)...)
+		}
+	}
+	for i, director := range movie.Directors {
+		if director != nil {
+			violations = append(violations, externalIdViolations(EntityPerson, fmt.Sprintf("directors[%d].external_ids", i), director.ExternalIds)...)
+		}
+	}
+	return violations
+}
+
+func externalIdViolations(entity, path string, externalIds []*ExternalId) []*Violation {
+	violations := []*Violation{}
+	for i, externalId := range externalIds {
+		if externalId == nil || len(externalId.Provider) == 0 {
+			continue
+		}
+		if err := ValidateExternalId(entity, strings.ToLower(externalId.Provider), externalId.ExternalId); err != nil {
+			violations = append(violations, &Violation{fmt.Sprintf("%s[%d].id", path, i), "format", err.Error()})
+		}
+	}
+	return violations
+}
+
+// validateLanguages makes sure languages referenced by id exist, all others need a name
+func (c *movieChecker) validateLanguages(movie *Movie) ([]*Violation, error) {
+	var paths []string
+	var languages []*Language
+	for i, alttitle := range movie.Alttitles {
+		if alttitle != nil && alttitle.Language != nil {
+			paths = append(paths, fmt.Sprintf("alttitles[%d].language", i))
+			languages = append(languages, alttitle.Language)
+		}
+	}
+	for i, language := range movie.Languages {
+		if language != nil {
+			paths = append(paths, fmt.Sprintf("languages[%d]", i))
+			languages = append(languages, language)
+		}
+	}
+
+	violations := []*Violation{}
+	for i, language := range languages {
+		path := paths[i]
+		if language.Id == 0 {
+			if len(language.Name) == 0 {
+				violations = append(violations, &Violation{path + ".name", "required", "must not be empty"})
+			}
+			continue
+		}
+
+		known, err := c.languageIds()
+		if err != nil {
+			return nil, err
+		}
+		if !known[language.Id] {
+			violations = append(violations, &Violation{path + ".id", "reference", fmt.Sprintf("unknown language id %d", language.Id)})
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+)
+
+type databaseStore struct {
+	*sql.DB
+}
+
+func NewDatabaseStore(adapter *database.Adapter) BlobStore {
+	return &databaseStore{adapter.Database}
+}
+
+func (ds *databaseStore) Get(key string) (*Blob, error) {
+	stmt, err := ds.Prepare(`select key, content_type, hash, data, created_at from movie_blob where key = $1`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var b Blob
+	err = stmt.QueryRow(key).Scan(&b.Key, &b.ContentType, &b.Hash, &b.Data, &b.ModTime)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.ModTime = b.ModTime.UTC()
+	return &b, nil
+}
+
+func (ds *databaseStore) Put(key string, data []byte, contentType string) error {
+	tx, err := ds.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`delete from movie_blob where key = $1`, key); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO movie_blob (key, content_type, hash, data) VALUES ($1,$2,$3,$4)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(key, contentType, Hash(data), data); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *databaseStore) Delete(key string) error {
+	result, err := ds.Exec(`delete from movie_blob where key = $1`, key)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
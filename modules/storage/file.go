@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type fileStore struct {
+	path string
+}
+
+func NewFileStore(path string) BlobStore {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		log.Fatal(err)
+	}
+	return &fileStore{path}
+}
+
+// filename maps a key into the store directory, keys can never point outside of it
+func (fs *fileStore) filename(key string) string {
+	return filepath.Join(fs.path, filepath.Clean("/"+key))
+}
+
+func (fs *fileStore) Get(key string) (*Blob, error) {
+	filename := fs.filename(key)
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blob{
+		Key:         key,
+		ContentType: http.DetectContentType(data),
+		Hash:        Hash(data),
+		Data:        data,
+		ModTime:     info.ModTime().UTC(),
+	}, nil
+}
+
+func (fs *fileStore) Put(key string, data []byte, contentType string) error {
+	filename := fs.filename(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so readers never see partial data
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func (fs *fileStore) Delete(key string) error {
+	err := os.Remove(fs.filename(key))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
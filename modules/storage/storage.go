@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/stdlib/env"
+	"github.com/jamesclonk-io/stdlib/logger"
+)
+
+var (
+	log *logrus.Logger
+
+	ErrNotFound = errors.New("blob not found")
+)
+
+type BlobStore interface {
+	Get(key string) (*Blob, error)
+	Put(key string, data []byte, contentType string) error
+	Delete(key string) error
+}
+
+type Blob struct {
+	Key         string
+	ContentType string
+	Hash        string
+	Data        []byte
+	ModTime     time.Time
+}
+
+func init() {
+	log = logger.GetLogger()
+}
+
+func NewBlobStore(adapter *database.Adapter) (store BlobStore) {
+	// get blobstore type
+	storeType := env.Get("JCIO_BLOBSTORE_TYPE", "database")
+
+	switch storeType {
+	case "database":
+		store = NewDatabaseStore(adapter)
+	case "filesystem":
+		store = NewFileStore(env.Get("JCIO_BLOBSTORE_PATH", "./pictures"))
+	default:
+		log.Fatalf("Invalid blobstore type: %s\n", storeType)
+	}
+	return store
+}
+
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/database"
+	"github.com/jamesclonk-io/moviedb-backend/modules/database/migration"
+	"github.com/stretchr/testify/assert"
+)
+
+var gif = []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+func testBlobStore(t *testing.T, store BlobStore) {
+	_, err := store.Get("movie_1.gif")
+	assert.Equal(t, ErrNotFound, err)
+
+	if err := store.Put("movie_1.gif", gif, "image/gif"); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := store.Get("movie_1.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "movie_1.gif", blob.Key)
+	assert.Equal(t, "image/gif", blob.ContentType)
+	assert.Equal(t, gif, blob.Data)
+	assert.Equal(t, Hash(gif), blob.Hash)
+	assert.False(t, blob.ModTime.IsZero())
+
+	// overwrite
+	if err := store.Put("movie_1.gif", append(gif, 0), "image/gif"); err != nil {
+		t.Fatal(err)
+	}
+	blob, err = store.Get("movie_1.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Hash(append(gif, 0)), blob.Hash)
+
+	assert.Nil(t, store.Delete("movie_1.gif"))
+	assert.Equal(t, ErrNotFound, store.Delete("movie_1.gif"))
+	_, err = store.Get("movie_1.gif")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func Test_Storage_FileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "moviedb-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(dir)
+	testBlobStore(t, store)
+
+	// keys must not escape the store directory
+	if err := store.Put("../../escape.gif", gif, "image/gif"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "escape.gif"))
+	assert.Nil(t, err)
+}
+
+func Test_Storage_DatabaseStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "moviedb-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("JCIO_DATABASE_TYPE", "sqlite")
+	os.Setenv("JCIO_DATABASE_URI", fmt.Sprintf("sqlite3://%s", filepath.Join(dir, "blobs.db")))
+	adapter := database.NewAdapter()
+	defer adapter.Database.Close()
+	migration.RunMigrations("../../migrations", adapter)
+
+	testBlobStore(t, NewDatabaseStore(adapter))
+}
@@ -0,0 +1,169 @@
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxDimension limits width and height of pictures, larger ones would take too much memory to decode
+const MaxDimension = 8000
+
+type Size struct {
+	Name  string
+	Width int
+}
+
+// ParseSizes parses a list of named thumbnail widths, like "small:160,medium:320"
+func ParseSizes(sizes string) ([]Size, error) {
+	var result []Size
+	for _, size := range strings.Split(sizes, ",") {
+		size = strings.TrimSpace(size)
+		if len(size) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(size, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid thumbnail size: %s", size)
+		}
+		width, err := strconv.Atoi(parts[1])
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("invalid thumbnail width: %s", size)
+		}
+		result = append(result, Size{Name: parts[0], Width: width})
+	}
+	sort.Sort(byWidth(result))
+	return result, nil
+}
+
+type byWidth []Size
+
+func (s byWidth) Len() int           { return len(s) }
+func (s byWidth) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byWidth) Less(i, j int) bool { return s[i].Width < s[j].Width }
+
+// Key returns the blobstore key of a thumbnail. It contains the width,
+// so thumbnails are regenerated whenever the configured sizes change.
+func Key(key string, size Size) string {
+	return fmt.Sprintf("thumb_%s_%d_%s", size.Name, size.Width, key)
+}
+
+// Check reads only the header of a picture, and fails if it can't be decoded or is empty or too large.
+// A small compressed picture can still decode into a huge number of pixels.
+func Check(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return fmt.Errorf("picture is empty: %dx%d", config.Width, config.Height)
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return fmt.Errorf("picture is too large: %dx%d, at most %dx%d are allowed", config.Width, config.Height, MaxDimension, MaxDimension)
+	}
+	return nil
+}
+
+// Generate decodes a JPEG, PNG or GIF picture and returns it resized to the given width.
+// JPEG pictures stay JPEG, everything else is encoded as PNG.
+func Generate(data []byte, width int) ([]byte, string, error) {
+	if err := Check(data); err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	thumb := Resize(img, width)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, thumb); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// Resize scales an image down to the given width, keeping its aspect ratio.
+// Every target pixel is the area-weighted average of the source pixels it covers.
+// Images are never scaled up, empty ones are returned as they are.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if sw == 0 || sh == 0 {
+		return img
+	}
+	if width >= sw {
+		width = sw
+	}
+	height := sh * width / sw
+	if height < 1 {
+		height = 1
+	}
+
+	scaleX := float64(sw) / float64(width)
+	scaleY := float64(sh) / float64(height)
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for ty := 0; ty < height; ty++ {
+		y0, y1 := float64(ty)*scaleY, float64(ty+1)*scaleY
+		for tx := 0; tx < width; tx++ {
+			x0, x1 := float64(tx)*scaleX, float64(tx+1)*scaleX
+
+			var r, g, b, a, total float64
+			for sy := int(y0); float64(sy) < y1 && sy < sh; sy++ {
+				wy := overlap(y0, y1, sy)
+				for sx := int(x0); float64(sx) < x1 && sx < sw; sx++ {
+					weight := wy * overlap(x0, x1, sx)
+					pr, pg, pb, pa := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r += float64(pr) * weight
+					g += float64(pg) * weight
+					b += float64(pb) * weight
+					a += float64(pa) * weight
+					total += weight
+				}
+			}
+			if total == 0 || a == 0 {
+				continue
+			}
+
+			// RGBA() is alpha-premultiplied, NRGBA is not
+			thumb.SetNRGBA(tx, ty, color.NRGBA{
+				R: uint8(r / a * 0xff),
+				G: uint8(g / a * 0xff),
+				B: uint8(b / a * 0xff),
+				A: uint8(a / total / 0x101),
+			})
+		}
+	}
+	return thumb
+}
+
+// overlap returns how much of source pixel p lies within [from, to)
+func overlap(from, to float64, p int) float64 {
+	start, end := float64(p), float64(p+1)
+	if from > start {
+		start = from
+	}
+	if to < end {
+		end = to
+	}
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
@@ -0,0 +1,119 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Thumbnail_ParseSizes(t *testing.T) {
+	sizes, err := ParseSizes("large:640, small:160,medium:320")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Size{{"small", 160}, {"medium", 320}, {"large", 640}}, sizes)
+
+	_, err = ParseSizes("small:abc")
+	assert.NotNil(t, err)
+	_, err = ParseSizes("small")
+	assert.NotNil(t, err)
+	_, err = ParseSizes(":120")
+	assert.NotNil(t, err)
+	_, err = ParseSizes("small:-1")
+	assert.NotNil(t, err)
+}
+
+func Test_Thumbnail_Key(t *testing.T) {
+	assert.Equal(t, "thumb_small_160_argo.jpg", Key("argo.jpg", Size{"small", 160}))
+	assert.NotEqual(t, Key("argo.jpg", Size{"small", 160}), Key("argo.jpg", Size{"small", 200}))
+}
+
+func Test_Thumbnail_Resize(t *testing.T) {
+	// left half black, right half white
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 50; y++ {
+			if x >= 50 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	thumb := Resize(img, 10)
+	assert.Equal(t, image.Rect(0, 0, 10, 5), thumb.Bounds())
+	assert.Equal(t, color.NRGBA{0, 0, 0, 255}, thumb.At(0, 0))
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, thumb.At(9, 4))
+
+	// uneven scale factor averages pixels across the edge
+	thumb = Resize(img, 3)
+	assert.Equal(t, image.Rect(0, 0, 3, 1), thumb.Bounds())
+	r, _, _, _ := thumb.At(1, 0).RGBA()
+	assert.InDelta(t, 0x7fff, r, 0x200)
+
+	// never scale up
+	thumb = Resize(img, 400)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), thumb.Bounds())
+
+	// empty images stay as they are
+	empty := image.NewRGBA(image.Rect(0, 0, 0, 10))
+	assert.Equal(t, empty, Resize(empty, 10))
+}
+
+func Test_Thumbnail_Generate(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 64, 32), []color.Color{color.Black, color.White})
+
+	var pngData, jpegData, gifData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		data        []byte
+		contentType string
+	}{
+		{pngData.Bytes(), "image/png"},
+		{jpegData.Bytes(), "image/jpeg"},
+		{gifData.Bytes(), "image/png"},
+	} {
+		data, contentType, err := Generate(test.data, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.contentType, contentType)
+
+		thumb, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, image.Rect(0, 0, 16, 8), thumb.Bounds())
+	}
+
+	_, _, err := Generate([]byte("not a picture"), 16)
+	assert.NotNil(t, err)
+
+	// a picture claiming huge dimensions is rejected before decoding its pixels
+	huge := pngData.Bytes()
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	_, _, err = Generate(huge, 16)
+	assert.EqualError(t, err, "picture is too large: 100000x100000, at most 8000x8000 are allowed")
+	assert.NotNil(t, Check(huge))
+	assert.Nil(t, Check(gifData.Bytes()))
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+const codeNotAcceptable = "not_acceptable"
+
+var formatContentTypes = map[string]string{
+	"json": "application/json; charset=UTF-8",
+	"xml":  "application/xml; charset=UTF-8",
+	"csv":  "text/csv; charset=UTF-8",
+}
+
+// mediaFormats lists the formats each media range of an Accept header stands for
+var mediaFormats = map[string][]string{
+	"application/json": {"json"},
+	"application/xml":  {"xml"},
+	"text/xml":         {"xml"},
+	"text/csv":         {"csv"},
+	"application/*":    {"json", "xml"},
+	"text/*":           {"xml", "csv"},
+	"*/*":              {"json", "xml", "csv"},
+}
+
+// acceptedFormats returns the formats a client accepts, best first. Without a usable Accept header that is JSON,
+// clients asking only for types we can't produce still get JSON instead of a 406 Not Acceptable.
+// JSON also comes first whenever it is acceptable, unless XML or CSV is what the client asks for the most.
+// A browser sending "text/html,application/xml;q=0.9,*/*;q=0.8" gets JSON, as it prefers HTML over XML.
+func acceptedFormats(req *http.Request) []string {
+	type quality struct {
+		specificity int
+		q           float64
+	}
+	qualities := map[string]quality{}
+	top := 0.0
+	for _, value := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > top {
+			top = q
+		}
+
+		// the most specific media range decides, like application/xml;q=0 excludes XML from */*
+		specificity := 2
+		if mediaType == "*/*" {
+			specificity = 0
+		} else if strings.HasSuffix(mediaType, "/*") {
+			specificity = 1
+		}
+		for _, format := range mediaFormats[mediaType] {
+			current, ok := qualities[format]
+			if !ok || specificity > current.specificity || (specificity == current.specificity && q > current.q) {
+				qualities[format] = quality{specificity, q}
+			}
+		}
+	}
+
+	formats := []string{}
+	for _, format := range []string{"json", "xml", "csv"} {
+		if qualities[format].q > 0 {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return []string{"json"}
+	}
+	sort.SliceStable(formats, func(i, j int) bool { return qualities[formats[i]].q > qualities[formats[j]].q })
+	if formats[0] != "json" && qualities[formats[0]].q < top && qualities["json"].q > 0 {
+		preferred := []string{"json"}
+		for _, format := range formats {
+			if format != "json" {
+				preferred = append(preferred, format)
+			}
+		}
+		formats = preferred
+	}
+	return formats
+}
+
+// negotiation renders responses as XML or CSV if the client prefers that over JSON.
+// Handlers wrapped by negotiated pass their page on, everything else is left as it is.
+func negotiation(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	w.Header().Add("Vary", "Accept")
+	formats := acceptedFormats(req)
+	if formats[0] == "json" {
+		next(w, req)
+		return
+	}
+
+	nw := &negotiatingWriter{ResponseWriter: w, formats: formats}
+	next(nw, req)
+	nw.render(req)
+}
+
+// negotiated hands the page of a handler to the negotiatingWriter, before the backend renders it as JSON
+func negotiated(fn web.Handler) web.Handler {
+	return func(w http.ResponseWriter, req *http.Request) *web.Page {
+		page := fn(w, req)
+		if nw, ok := w.(*negotiatingWriter); ok && page != nil {
+			nw.page = page
+		}
+		return page
+	}
+}
+
+// negotiatingWriter holds back the JSON rendering of a page, so it can be rendered in another format instead
+type negotiatingWriter struct {
+	http.ResponseWriter
+	formats []string
+	page    *web.Page
+	status  int
+	json    bytes.Buffer
+}
+
+func (nw *negotiatingWriter) WriteHeader(status int) {
+	if nw.page == nil {
+		nw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	nw.status = status
+}
+
+func (nw *negotiatingWriter) Write(data []byte) (int, error) {
+	if nw.page == nil {
+		return nw.ResponseWriter.Write(data)
+	}
+	return nw.json.Write(data)
+}
+
+func (nw *negotiatingWriter) Flush() {
+	if flusher, ok := nw.ResponseWriter.(http.Flusher); ok && nw.page == nil {
+		flusher.Flush()
+	}
+}
+
+func (nw *negotiatingWriter) render(req *http.Request) {
+	if nw.page == nil {
+		return
+	}
+	if nw.status == 0 {
+		nw.status = http.StatusOK
+	}
+
+	for _, format := range nw.formats {
+		var data []byte
+		var err error
+		switch format {
+		case "json":
+			data = nw.json.Bytes()
+		case "xml":
+			data, err = marshalXML(nw.page.Content)
+		case "csv":
+			if !isList(nw.page.Content) {
+				continue
+			}
+			data, err = marshalCSV(nw.page.Content)
+		}
+		if err != nil {
+			log.Errorf("could not render %s: %v", format, err)
+			continue
+		}
+		nw.ResponseWriter.Header().Set("Content-Type", formatContentTypes[format])
+		nw.ResponseWriter.WriteHeader(nw.status)
+		nw.ResponseWriter.Write(data)
+		return
+	}
+
+	page := errorPage(req, http.StatusNotAcceptable, codeNotAcceptable,
+		fmt.Sprintf("Response can not be rendered as %s", strings.Join(nw.formats, ", ")), nil)
+	data, _ := json.Marshal(page.Content)
+	nw.ResponseWriter.Header().Set("Content-Type", formatContentTypes["json"])
+	nw.ResponseWriter.WriteHeader(page.StatusCode)
+	nw.ResponseWriter.Write(data)
+}
+
+// marshalXML uses the type name for the root element, like <movie> or <movie_listing>.
+// Lists are wrapped in <results>, maps are rendered with an element per key.
+func marshalXML(content interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+
+	v := reflect.ValueOf(content)
+	switch {
+	case !v.IsValid():
+		if err := encoder.EncodeElement("", xml.StartElement{Name: xml.Name{Local: "result"}}); err != nil {
+			return nil, err
+		}
+	case v.Kind() == reflect.Slice:
+		results := xml.StartElement{Name: xml.Name{Local: "results"}}
+		if err := encoder.EncodeToken(results); err != nil {
+			return nil, err
+		}
+		item := xml.StartElement{Name: xml.Name{Local: xmlName(v.Type().Elem())}}
+		for i := 0; i < v.Len(); i++ {
+			if err := encoder.EncodeElement(v.Index(i).Interface(), item); err != nil {
+				return nil, err
+			}
+		}
+		if err := encoder.EncodeToken(results.End()); err != nil {
+			return nil, err
+		}
+	case v.Kind() == reflect.Map:
+		result := xml.StartElement{Name: xml.Name{Local: "result"}}
+		if err := encoder.EncodeToken(result); err != nil {
+			return nil, err
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			element := xml.StartElement{Name: xml.Name{Local: fmt.Sprint(key)}}
+			if err := encoder.EncodeElement(v.MapIndex(key).Interface(), element); err != nil {
+				return nil, err
+			}
+		}
+		if err := encoder.EncodeToken(result.End()); err != nil {
+			return nil, err
+		}
+	default:
+		if err := encoder.EncodeElement(content, xml.StartElement{Name: xml.Name{Local: xmlName(v.Type())}}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// xmlName turns a struct type name like MovieListing into movie_listing
+func xmlName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || len(t.Name()) == 0 {
+		return "result"
+	}
+	var name []rune
+	for i, r := range t.Name() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				name = append(name, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		name = append(name, r)
+	}
+	return string(name)
+}
+
+func isList(content interface{}) bool {
+	v := reflect.ValueOf(content)
+	if !v.IsValid() || v.Kind() != reflect.Slice {
+		return false
+	}
+	elem := v.Type().Elem()
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	nullStringType = reflect.TypeOf(sql.NullString{})
+)
+
+// marshalCSV writes a list of structs with a column per field, named like in JSON.
+// Referenced structs are flattened into columns like "movie.title", lists are joined with "|".
+func marshalCSV(content interface{}) ([]byte, error) {
+	v := reflect.ValueOf(content)
+	elem := v.Type().Elem()
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(csvHeader(elem, "")); err != nil {
+		return nil, err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := writer.Write(csvRecord(v.Index(i), elem)); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// csvFields returns the json names and indexes of all exported fields of a struct
+func csvFields(t reflect.Type) ([]string, []int) {
+	var names []string
+	var indexes []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if len(field.PkgPath) > 0 || name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		names = append(names, name)
+		indexes = append(indexes, i)
+	}
+	return names, indexes
+}
+
+// nested structs are flattened, except for values like timestamps
+func isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && t != nullStringType
+}
+
+func csvHeader(t reflect.Type, prefix string) []string {
+	header := []string{}
+	names, indexes := csvFields(t)
+	for i, name := range names {
+		fieldType := t.Field(indexes[i]).Type
+		if isNested(fieldType) {
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			header = append(header, csvHeader(fieldType, prefix+name+".")...)
+			continue
+		}
+		header = append(header, prefix+name)
+	}
+	return header
+}
+
+func csvRecord(v reflect.Value, t reflect.Type) []string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return make([]string, len(csvHeader(t, "")))
+		}
+		v = v.Elem()
+	}
+
+	record := []string{}
+	_, indexes := csvFields(t)
+	for _, i := range indexes {
+		field := v.Field(i)
+		if isNested(field.Type()) {
+			fieldType := field.Type()
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			record = append(record, csvRecord(field, fieldType)...)
+			continue
+		}
+		record = append(record, csvValue(field))
+	}
+	return record
+}
+
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).UTC().Format(time.RFC3339)
+	case v.Type() == nullStringType:
+		return v.Interface().(sql.NullString).String
+	case v.Kind() == reflect.Slice:
+		values := []string{}
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, csvLabel(v.Index(i)))
+		}
+		return strings.Join(values, "|")
+	case v.Kind() == reflect.Struct:
+		return csvLabel(v)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// csvLabel is what represents a struct within a list, its name or title if it has one
+func csvLabel(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct || v.Type() == timeType || v.Type() == nullStringType {
+		return csvValue(v)
+	}
+
+	names, indexes := csvFields(v.Type())
+	for _, label := range []string{"name", "title", "kind", "type", "id"} {
+		for i, name := range names {
+			if name == label {
+				return csvValue(v.Field(indexes[i]))
+			}
+		}
+	}
+	return ""
+}
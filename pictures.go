@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/jamesclonk-io/moviedb-backend/modules/storage"
+	"github.com/jamesclonk-io/moviedb-backend/modules/thumbnail"
+	"github.com/jamesclonk-io/stdlib/web"
+)
+
+const maxPictureSize = 10 << 20 // 10 MB
+
+// pictureKey matches the keys of uploaded pictures, like movie_914_0123456789abcdef.jpg
+var pictureKey = regexp.MustCompile(`^movie_(\d+)_[0-9a-f]{16}\.(jpg|png|gif)$`)
+
+var pictureTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+func postMoviePicture(w http.ResponseWriter, req *http.Request) *web.Page {
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+	movie, err := mdb.GetMovie(id)
+	if err != nil {
+		return getError(req, err)
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxPictureSize+1024)
+	file, _, err := req.FormFile("picture")
+	if err != nil {
+		return badRequest(req, err)
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return badRequest(req, err)
+	}
+	if len(data) > maxPictureSize {
+		return errorPage(req, http.StatusRequestEntityTooLarge, codeTooLarge, "Picture too large", nil)
+	}
+
+	// sniff the actual content type, never trust the client
+	contentType := http.DetectContentType(data)
+	ext, ok := pictureTypes[contentType]
+	if !ok {
+		return errorPage(req, http.StatusUnsupportedMediaType, codeUnsupportedMedia, fmt.Sprintf("Unsupported picture type: %s", contentType), nil)
+	}
+
+	if err := thumbnail.Check(data); err != nil {
+		return errorPage(req, http.StatusUnprocessableEntity, codeValidation, fmt.Sprintf("Invalid picture: %v", err), nil)
+	}
+
+	key := fmt.Sprintf("movie_%d_%s%s", movie.Id, storage.Hash(data)[:16], ext)
+	if err := blobs.Put(key, data, contentType); err != nil {
+		return getError(req, err)
+	}
+
+	// replace previous picture, without saving the whole movie which might not pass validation anymore
+	previous := movie.Picture
+	if err := mdb.SetMoviePicture(movie.Id, key); err != nil {
+		return getError(req, err)
+	}
+	if previous != key {
+		deletePicture(movie.Id, previous)
+	}
+
+	// pregenerate thumbnails, missing ones will otherwise be generated on demand
+	for _, size := range thumbnailSizes {
+		if _, err := generateThumbnail(key, data, size); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return &web.Page{
+		StatusCode: http.StatusCreated,
+		Content:    map[string]string{"Result": "OK", "picture": key},
+	}
+}
+
+func getMoviePicture(w http.ResponseWriter, req *http.Request) *web.Page {
+	id, page := pathId(req)
+	if page != nil {
+		return page
+	}
+	movie, err := mdb.GetMovie(id)
+	if err != nil {
+		return getError(req, err)
+	}
+
+	var size *thumbnail.Size
+	if name := req.URL.Query().Get("size"); len(name) > 0 && name != "original" {
+		for i := range thumbnailSizes {
+			if thumbnailSizes[i].Name == name {
+				size = &thumbnailSizes[i]
+			}
+		}
+		if size == nil {
+			return badRequest(req, fmt.Errorf("Unknown picture size: %s", name))
+		}
+	}
+
+	blob, err := getPicture(movie.Picture, size)
+	if err == storage.ErrNotFound {
+		return errorPage(req, http.StatusNotFound, codeNotFound, "Picture not found", nil)
+	}
+	if err != nil {
+		return getError(req, err)
+	}
+
+	servePicture(w, req, blob)
+	return nil
+}
+
+// getPicture returns either the original picture or a thumbnail of it, which gets generated if it does not exist yet
+func getPicture(key string, size *thumbnail.Size) (*storage.Blob, error) {
+	if size == nil {
+		return blobs.Get(key)
+	}
+
+	blob, err := blobs.Get(thumbnail.Key(key, *size))
+	if err != storage.ErrNotFound {
+		return blob, err
+	}
+
+	original, err := blobs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return generateThumbnail(key, original.Data, *size)
+}
+
+func generateThumbnail(key string, data []byte, size thumbnail.Size) (*storage.Blob, error) {
+	thumb, contentType, err := thumbnail.Generate(data, size.Width)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbKey := thumbnail.Key(key, size)
+	if err := blobs.Put(thumbKey, thumb, contentType); err != nil {
+		return nil, err
+	}
+	return blobs.Get(thumbKey)
+}
+
+// deletePicture removes a picture uploaded for a movie, together with its thumbnails.
+// Legacy pictures are plain file names like argo.jpg, possibly shared by several movies, they are left alone.
+func deletePicture(movieId int, key string) {
+	if match := pictureKey.FindStringSubmatch(key); match == nil || match[1] != strconv.Itoa(movieId) {
+		return
+	}
+	for _, k := range append(thumbnailKeys(key), key) {
+		if err := blobs.Delete(k); err != nil && err != storage.ErrNotFound {
+			log.Error(err)
+		}
+	}
+}
+
+func thumbnailKeys(key string) []string {
+	var keys []string
+	for _, size := range thumbnailSizes {
+		keys = append(keys, thumbnail.Key(key, size))
+	}
+	return keys
+}
+
+func servePicture(w http.ResponseWriter, req *http.Request, blob *storage.Blob) {
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", strconv.Quote(blob.Hash))
+
+	// handles If-None-Match, If-Modified-Since and range requests
+	http.ServeContent(w, req, blob.Key, blob.ModTime, bytes.NewReader(blob.Data))
+}